@@ -54,12 +54,12 @@ func NewBackendFactoryWithContext(ctx context.Context, logger logging.Logger, me
 	backendFactory := martian.NewConfiguredBackendFactory(logger, requestExecutorFactory)
 	bf := pubsub.NewBackendFactory(ctx, logger, backendFactory)
 	backendFactory = bf.New
-	backendFactory = grpc.NewGrpcBackendFactory(logger, backendFactory)
+	backendFactory = grpc.NewGrpcBackendFactoryWithContext(ctx, logger, backendFactory)
 	backendFactory = amqp.NewBackendFactory(ctx, logger, backendFactory)
 	backendFactory = lambda.BackendFactory(logger, backendFactory)
 	backendFactory = cel.BackendFactory(logger, backendFactory)
 	backendFactory = lua.BackendFactory(logger, backendFactory)
-	backendFactory = ratelimit.BackendFactory(logger, backendFactory)
+	backendFactory = ratelimit.BackendFactoryWithContext(ctx, logger, backendFactory)
 	backendFactory = cb.BackendFactory(backendFactory, logger)
 	backendFactory = metricCollector.BackendFactory("backend", backendFactory)
 	backendFactory = opencensus.BackendFactory(backendFactory)
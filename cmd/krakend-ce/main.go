@@ -6,6 +6,7 @@ import (
 	flexibleconfig "api-gateway/v2/modules/krakend-flexibleconfig/v2"
 	viper "api-gateway/v2/modules/krakend-viper/v2"
 	"api-gateway/v2/modules/lura/v2/config"
+	"api-gateway/v2/proxy/grpc"
 	"context"
 	"log"
 	"os"
@@ -53,6 +54,7 @@ func main() {
 			Templates: os.Getenv(fcTemplates),
 		})
 	}
+	cfg = grpc.NewConfigParser(cfg)
 
 	cmd.Execute(cfg, krakend.NewExecutor(ctx))
 }
@@ -63,6 +65,8 @@ var aliases = map[string]string{
 	"api-gateway/v2/modules/lura/proxy/plugin":                   "plugin/req-resp-modifier",
 	"api-gateway/v2/modules/lura/proxy":                          "proxy",
 	"github_com/davron112/lura/router/gin":                       "router",
+	"github_com/davron112/lura/router/grpc":                      "router/grpc",
+	"api-gateway/v2/proxy/grpc/web":                              "grpc/web",
 
 	"api-gateway/v2/modules/krakend-httpcache":                "qos/http-cache",
 	"api-gateway/v2/modules/krakend-circuitbreaker/gobreaker": "qos/circuit-breaker",
@@ -72,6 +76,7 @@ var aliases = map[string]string{
 	"api-gateway/v2/modules/krakend-jose/signer":              "auth/signer",
 	"github_com/davron112/bloomfilter":                        "auth/revoker",
 	"github_com/davron112/jwtvalidator":                       "auth/jwtvalidator",
+	"github_com/davron112/introspection":                      "auth/introspection",
 
 	"github_com/davron112/krakend-botdetector": "security/bot-detector",
 	"github_com/davron112/krakend-httpsecure":  "security/http",
@@ -41,8 +41,10 @@ import (
 	"api-gateway/v2/modules/lura/v2/logging"
 	"api-gateway/v2/modules/lura/v2/proxy"
 	router "api-gateway/v2/modules/lura/v2/router/gin"
+	grpcrouter "api-gateway/v2/modules/lura/v2/router/grpc"
 	serverhttp "api-gateway/v2/modules/lura/v2/transport/http/server"
 	server "api-gateway/v2/modules/lura/v2/transport/http/server/plugin"
+	"api-gateway/v2/pkg/jwtvalidator"
 )
 
 // NewExecutor returns an executor for the cmd package. The executor initalizes the entire gateway by
@@ -97,6 +99,12 @@ type HandlerFactory interface {
 	NewHandlerFactory(logging.Logger, *metrics.Metrics, jose.RejecterFactory) router.HandlerFactory
 }
 
+// HandlerFactoryWithContext is a HandlerFactory whose handlers stop their background tasks
+// once the context of the service is done
+type HandlerFactoryWithContext interface {
+	NewHandlerFactoryWithContext(context.Context, logging.Logger, *metrics.Metrics, jose.RejecterFactory) router.HandlerFactory
+}
+
 // LoggerFactory returns a KrakenD Logger factory, ready to be passed to the KrakenD RouterFactory
 type LoggerFactory interface {
 	NewLogger(config.ServiceConfig) (logging.Logger, io.Writer, error)
@@ -170,6 +178,7 @@ func (e *ExecutorBuilder) NewCmdExecutor(ctx context.Context) cmd.Executor {
 		if err := jose.SetGlobalCacher(logger, cfg.ExtraConfig); err != nil && err != jose.ErrNoValidatorCfg {
 			logger.Error("[SERVICE: JOSE]", err.Error())
 		}
+		warnCertificateBoundTokens(cfg, logger)
 		tokenRejecterFactory, err := e.TokenRejecterFactory.NewTokenRejecter(
 			ctx,
 			cfg,
@@ -188,23 +197,48 @@ func (e *ExecutorBuilder) NewCmdExecutor(ctx context.Context) cmd.Executor {
 
 		agentPing := make(chan string, len(cfg.AsyncAgents))
 
+		var handlerFactory router.HandlerFactory
+		if hf, ok := e.HandlerFactory.(HandlerFactoryWithContext); ok {
+			handlerFactory = hf.NewHandlerFactoryWithContext(ctx, logger, metricCollector, tokenRejecterFactory)
+		} else {
+			handlerFactory = e.HandlerFactory.NewHandlerFactory(logger, metricCollector, tokenRejecterFactory)
+		}
+
 		// setup the krakend router
 		routerFactory := router.NewFactory(router.Config{
 			Engine: e.EngineFactory.NewEngine(cfg, router.EngineOptions{
-				Logger: logger,
-				Writer: gelfWriter,
-				Health: (<-chan string)(agentPing),
+				Context: ctx,
+				Logger:  logger,
+				Writer:  gelfWriter,
+				Health:  (<-chan string)(agentPing),
 			}),
 			ProxyFactory:   pf,
 			Middlewares:    e.Middlewares,
 			Logger:         logger,
-			HandlerFactory: e.HandlerFactory.NewHandlerFactory(logger, metricCollector, tokenRejecterFactory),
+			HandlerFactory: handlerFactory,
 			RunServer:      router.RunServerFunc(e.RunServerFactory.NewRunServer(logger, serverhttp.RunServerWithLoggerFactory(logger))),
 		})
 
 		// start the engines
 		logger.Info("Starting the KrakenD instance")
 
+		// the gRPC router serves the endpoints binding a gRPC method alongside the http one. The
+		// methods run through the http handler chain of their endpoints.
+		if _, ok := cfg.ExtraConfig[grpcrouter.Namespace]; ok {
+			grpcCfg := grpcrouter.Config{
+				HandlerFactory: NewGRPCHandlerFactory(handlerFactory),
+				ProxyFactory:   pf,
+				Logger:         logger,
+			}
+			go func() {
+				if err := grpcrouter.Serve(ctx, grpcCfg, cfg); err != nil {
+					logger.Error("[SERVICE: gRPC]", err.Error())
+					return
+				}
+				logger.Info("[SERVICE: gRPC] Router execution ended")
+			}()
+		}
+
 		if len(cfg.AsyncAgents) == 0 {
 			routerFactory.NewWithContext(ctx).Run(cfg)
 			return
@@ -382,6 +416,26 @@ const (
 	usageDelay   = 5 * time.Second
 )
 
+// warnCertificateBoundTokens logs the endpoints requiring certificate-bound tokens when the
+// service does not request the client certificates, as all their requests will be rejected
+func warnCertificateBoundTokens(cfg config.ServiceConfig, logger logging.Logger) {
+	if cfg.TLS != nil && cfg.TLS.EnableMTLS {
+		return
+	}
+	for _, e := range cfg.Endpoints {
+		bound := false
+		if scfg, err := jose.GetSignatureConfig(e); scfg != nil && err == nil {
+			bound = scfg.CertificateBound
+		}
+		if vcfg, err := jwtvalidator.ParseConfig(e.ExtraConfig); err == nil {
+			bound = bound || vcfg.CertificateBound
+		}
+		if bound {
+			logger.Warning(fmt.Sprintf("[ENDPOINT: %s] The mtls_bound_tokens option requires the enable_mtls flag of the service TLS config", e.Endpoint))
+		}
+	}
+}
+
 func startReporter(ctx context.Context, logger logging.Logger, cfg config.ServiceConfig) {
 	logPrefix := "[SERVICE: Telemetry]"
 	if os.Getenv(usageDisable) == "1" {
@@ -0,0 +1,203 @@
+package krakend
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"sync"
+
+	"api-gateway/v2/modules/lura/v2/config"
+	"api-gateway/v2/modules/lura/v2/proxy"
+	router "api-gateway/v2/modules/lura/v2/router/gin"
+	grpcrouter "api-gateway/v2/modules/lura/v2/router/grpc"
+	"github.com/gin-gonic/gin"
+	"github.com/jhump/protoreflect/desc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// NewGRPCHandlerFactory returns a gRPC HandlerFactory serving every method through the http
+// handler chain of its endpoint, so the gRPC requests pass the same auth, rate limit, bot detection
+// and metrics middlewares as the http ones. The incoming metadata is used as the request headers
+// and the fields of the message fill the params and the query string of the endpoint.
+func NewGRPCHandlerFactory(hf router.HandlerFactory) grpcrouter.HandlerFactory {
+	return func(cfg *config.EndpointConfig, md *desc.MethodDescriptor, p proxy.Proxy) grpcrouter.MethodHandler {
+		engine := gin.New()
+		engine.ContextWithFallback = true
+		// the gRPC clients can not be behind the http proxies of the gateway, so the client IP
+		// is always the address of the peer
+		engine.ForwardedByClientIP = false
+		// the params are matched on the escaped path, so their values can not change the route
+		engine.UseRawPath = true
+		engine.Handle(cfg.Method, cfg.Endpoint, hf(cfg, capturingProxy(p)))
+
+		return grpcrouter.EndpointHandler(cfg, md, chainProxy(engine, cfg))
+	}
+}
+
+// grpcCallKey is the key of the grpcCall in the context of the synthetic http requests
+type grpcCallKey struct{}
+
+// grpcCall keeps the result of the proxy executed by the http handler chain
+type grpcCall struct {
+	once     *sync.Once
+	executed bool
+	response *proxy.Response
+	err      error
+}
+
+// capturingProxy records the result of the proxy in the grpcCall of the request, so it is
+// returned to the gRPC client instead of the response rendered by the http handler
+func capturingProxy(next proxy.Proxy) proxy.Proxy {
+	return func(ctx context.Context, req *proxy.Request) (*proxy.Response, error) {
+		resp, err := next(ctx, req)
+		if call, ok := ctx.Value(grpcCallKey{}).(*grpcCall); ok {
+			call.once.Do(func() {
+				call.executed = true
+				call.response = resp
+				call.err = err
+			})
+		}
+		return resp, err
+	}
+}
+
+// chainProxy returns a proxy running the requests of the gRPC router through the http handler
+// chain registered in the engine
+func chainProxy(engine *gin.Engine, cfg *config.EndpointConfig) proxy.Proxy {
+	return func(ctx context.Context, req *proxy.Request) (*proxy.Response, error) {
+		var body []byte
+		if req.Body != nil {
+			b, err := io.ReadAll(req.Body)
+			req.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			body = b
+		}
+
+		call := &grpcCall{once: new(sync.Once)}
+		r, err := newChainRequest(context.WithValue(ctx, grpcCallKey{}, call), cfg, req, body)
+		if err != nil {
+			return nil, err
+		}
+		w := newChainResponseWriter()
+		engine.ServeHTTP(w, r)
+
+		if !call.executed {
+			// the request was rejected by the middlewares
+			return nil, chainError{status: w.status, msg: strings.TrimSpace(w.body.String())}
+		}
+		if call.response != nil {
+			// the middlewares can add headers to the response, like the rate limit ones
+			headers := make(map[string][]string, len(call.response.Metadata.Headers)+len(w.header))
+			for k, vs := range w.header {
+				headers[k] = vs
+			}
+			for k, vs := range call.response.Metadata.Headers {
+				headers[k] = vs
+			}
+			call.response.Metadata.Headers = headers
+		}
+		return call.response, call.err
+	}
+}
+
+// newChainRequest builds the http request matching the route of the endpoint with the params,
+// query string and body of the proxy request and the incoming metadata as headers
+func newChainRequest(ctx context.Context, cfg *config.EndpointConfig, req *proxy.Request, body []byte) (*http.Request, error) {
+	parts := strings.Split(cfg.Endpoint, "/")
+	for i, part := range parts {
+		if part == "" || (part[0] != ':' && part[0] != '*') {
+			continue
+		}
+		name := part[1:]
+		value := req.Params[textproto.CanonicalMIMEHeaderKey(name[:1])+name[1:]]
+		if part[0] == '*' {
+			segments := strings.Split(strings.TrimPrefix(value, "/"), "/")
+			for j, s := range segments {
+				segments[j] = url.PathEscape(s)
+			}
+			parts[i] = strings.Join(segments, "/")
+			continue
+		}
+		parts[i] = url.PathEscape(value)
+	}
+	u := &url.URL{
+		Path:     strings.Join(parts, "/"),
+		RawQuery: url.Values(req.Query).Encode(),
+	}
+	// the escaped path is used to match the route, so the values can not reach other routes
+	u.RawPath = u.Path
+	if p, err := url.PathUnescape(u.Path); err == nil {
+		u.Path = p
+	}
+
+	r, err := http.NewRequestWithContext(ctx, cfg.Method, "/", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	r.URL = u
+	r.RequestURI = u.RequestURI()
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	for k, vs := range md {
+		if strings.HasPrefix(k, ":") || strings.HasPrefix(k, "grpc-") {
+			continue
+		}
+		for _, v := range vs {
+			r.Header.Add(k, v)
+		}
+	}
+	// the body is the JSON representation of the message
+	r.Header.Set("Content-Type", "application/json")
+	if vs := md.Get(":authority"); len(vs) > 0 {
+		r.Host = vs[0]
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+		// the connection state of the TLS listeners binds the certificate-bound tokens
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			r.TLS = &info.State
+		}
+	}
+	return r, nil
+}
+
+// chainError is the rejection of a request by the middlewares of the http handler chain
+type chainError struct {
+	status int
+	msg    string
+}
+
+func (e chainError) Error() string {
+	if e.msg != "" {
+		return e.msg
+	}
+	return http.StatusText(e.status)
+}
+
+// StatusCode returns the status of the rejection, translated into a gRPC code by the router
+func (e chainError) StatusCode() int { return e.status }
+
+// chainResponseWriter collects the status, headers and body written by the http handler chain
+type chainResponseWriter struct {
+	header http.Header
+	status int
+	body   *bytes.Buffer
+}
+
+func newChainResponseWriter() *chainResponseWriter {
+	return &chainResponseWriter{header: http.Header{}, status: http.StatusOK, body: new(bytes.Buffer)}
+}
+
+func (w *chainResponseWriter) Header() http.Header { return w.header }
+
+func (w *chainResponseWriter) Write(b []byte) (int, error) { return w.body.Write(b) }
+
+func (w *chainResponseWriter) WriteHeader(status int) { w.status = status }
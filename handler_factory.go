@@ -13,7 +13,9 @@ import (
 	"api-gateway/v2/modules/lura/v2/proxy"
 	router "api-gateway/v2/modules/lura/v2/router/gin"
 	"api-gateway/v2/modules/lura/v2/transport/http/server"
+	"api-gateway/v2/pkg/introspection"
 	"api-gateway/v2/pkg/jwtvalidator"
+	"context"
 	"fmt"
 
 	"github.com/gin-gonic/gin"
@@ -21,14 +23,21 @@ import (
 
 // NewHandlerFactory returns a HandlerFactory with a rate-limit and a metrics collector middleware injected
 func NewHandlerFactory(logger logging.Logger, metricCollector *metrics.Metrics, rejecter jose.RejecterFactory) router.HandlerFactory {
+	return NewHandlerFactoryWithContext(context.Background(), logger, metricCollector, rejecter)
+}
+
+// NewHandlerFactoryWithContext returns a HandlerFactory with a rate-limit and a metrics collector middleware
+// injected, releasing the resources of the middlewares once the received context is cancelled
+func NewHandlerFactoryWithContext(ctx context.Context, logger logging.Logger, metricCollector *metrics.Metrics, rejecter jose.RejecterFactory) router.HandlerFactory {
 	handlerFactory := router.CustomErrorEndpointHandler(logger, server.DefaultToHTTPError)
-	handlerFactory = ratelimit.NewRateLimiterMw(logger, handlerFactory)
+	handlerFactory = ratelimit.NewRateLimiterMwWithContext(ctx, logger, handlerFactory)
 	handlerFactory = lua.HandlerFactory(logger, handlerFactory)
 	handlerFactory = ginjose.HandlerFactory(handlerFactory, logger, rejecter)
 	handlerFactory = metricCollector.NewHTTPHandlerFactory(handlerFactory)
 	handlerFactory = opencensus.New(handlerFactory)
 	handlerFactory = botdetector.New(handlerFactory, logger)
-	handlerFactory = jwtvalidator.New(handlerFactory, logger)
+	handlerFactory = jwtvalidator.NewWithContext(ctx, handlerFactory, logger, metricCollector.Metrics, rejecter)
+	handlerFactory = introspection.New(handlerFactory, logger, rejecter)
 
 	return func(cfg *config.EndpointConfig, p proxy.Proxy) gin.HandlerFunc {
 		logger.Debug(fmt.Sprintf("[ENDPOINT: %s] Building the http handler", cfg.Endpoint))
@@ -41,3 +50,7 @@ type handlerFactory struct{}
 func (handlerFactory) NewHandlerFactory(l logging.Logger, m *metrics.Metrics, r jose.RejecterFactory) router.HandlerFactory {
 	return NewHandlerFactory(l, m, r)
 }
+
+func (handlerFactory) NewHandlerFactoryWithContext(ctx context.Context, l logging.Logger, m *metrics.Metrics, r jose.RejecterFactory) router.HandlerFactory {
+	return NewHandlerFactoryWithContext(ctx, l, m, r)
+}
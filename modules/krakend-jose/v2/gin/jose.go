@@ -93,6 +93,12 @@ func TokenSignatureValidator(hf ginlura.HandlerFactory, logger logging.Logger, r
 			return erroredHandler
 		}
 
+		senderConstraint, err := krakendjose.NewSenderConstraintValidator(scfg.DPoP, scfg.CertificateBound)
+		if err != nil {
+			logger.Error(logPrefix, "Unable to create the sender constraint validator:", err.Error())
+			return erroredHandler
+		}
+
 		var aclCheck func(string, map[string]interface{}, []string) bool
 
 		if scfg.RolesKeyIsNested && strings.Contains(scfg.RolesKey, ".") && scfg.RolesKey[:4] != "http" {
@@ -154,6 +160,15 @@ func TokenSignatureValidator(hf ginlura.HandlerFactory, logger logging.Logger, r
 				return
 			}
 
+			accessToken, scheme := krakendjose.AccessToken(c.Request, scfg.CookieKey)
+			if err := senderConstraint.Validate(c.Request, accessToken, scheme, claims); err != nil {
+				if scfg.OperationDebug {
+					logger.Error(logPrefix, "Token sent by client is not bound to the sender:", err.Error())
+				}
+				c.AbortWithStatus(http.StatusUnauthorized)
+				return
+			}
+
 			if !aclCheck(scfg.RolesKey, claims, scfg.Roles) {
 				if scfg.OperationDebug {
 					logger.Error(logPrefix, "Token sent by client does not have sufficient roles")
@@ -171,6 +186,7 @@ func TokenSignatureValidator(hf ginlura.HandlerFactory, logger logging.Logger, r
 			}
 
 			propagateHeaders(cfg, scfg.PropagateClaimsToHeader, claims, c, logger)
+			c.Set(krakendjose.ClaimsContextKey, claims)
 
 			paramExtractor(c, claims)
 
@@ -22,10 +22,14 @@ func NewValidator(signatureConfig *SignatureConfig, ef ExtractorFactory) (*auth0
 	if !ok {
 		return nil, fmt.Errorf("JOSE: unknown algorithm %s", signatureConfig.Alg)
 	}
-	te := auth0.FromMultiple(
+	extractors := []auth0.RequestTokenExtractor{
 		auth0.RequestTokenExtractorFunc(auth0.FromHeader),
 		auth0.RequestTokenExtractorFunc(ef(signatureConfig.CookieKey)),
-	)
+	}
+	if signatureConfig.DPoP != nil {
+		extractors = append(extractors, auth0.RequestTokenExtractorFunc(FromDPoPHeader))
+	}
+	te := auth0.FromMultiple(extractors...)
 
 	decodedFs, err := DecodeFingerprints(signatureConfig.Fingerprints)
 	if err != nil {
@@ -289,22 +293,28 @@ func CalculateHeadersToPropagate(propagationCfg [][]string, claims map[string]in
 			err = fmt.Errorf("invalid number of claims to propagate: %+v", tuple)
 			continue
 		}
-		fromClaim := tuple[0]
-		toHeader := tuple[1]
-
-		c := Claims(claims)
-		if strings.Contains(fromClaim, ".") && (len(fromClaim) < 4 || fromClaim[:4] != "http") {
-			var claimsMap map[string]interface{}
-			fromClaim, claimsMap = getNestedClaim(fromClaim, claims)
-			c = Claims(claimsMap)
-		}
-		v, _ := c.Get(fromClaim)
-		propagated[toHeader] = v
+		v, _ := ClaimValue(claims, tuple[0])
+		propagated[tuple[1]] = v
 	}
 
 	return propagated, err
 }
 
+// ClaimsContextKey is the key of the validated claims in the context of the gin requests
+const ClaimsContextKey = "JWT.claims"
+
+// ClaimValue returns the normalized value of the claim, as propagated to the headers. The names
+// with dots, but not the ones starting with http, are paths to nested claims.
+func ClaimValue(claims map[string]interface{}, name string) (string, bool) {
+	c := Claims(claims)
+	if strings.Contains(name, ".") && (len(name) < 4 || name[:4] != "http") {
+		var claimsMap map[string]interface{}
+		name, claimsMap = getNestedClaim(name, claims)
+		c = Claims(claimsMap)
+	}
+	return c.Get(name)
+}
+
 var supportedAlgorithms = map[string]jose.SignatureAlgorithm{
 	"EdDSA": jose.EdDSA,
 	"HS256": jose.HS256,
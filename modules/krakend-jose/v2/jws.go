@@ -41,6 +41,10 @@ type SignatureConfig struct {
 	ScopesMatcher           string     `json:"scopes_matcher,omitempty"`
 	KeyIdentifyStrategy     string     `json:"key_identify_strategy"`
 	OperationDebug          bool       `json:"operation_debug,omitempty"`
+	// DPoP enables the validation of the DPoP proofs of the sender-constrained tokens
+	DPoP *DPoPConfig `json:"dpop,omitempty"`
+	// CertificateBound requires the tokens to be bound to the TLS client certificate
+	CertificateBound bool `json:"mtls_bound_tokens,omitempty"`
 }
 
 type SignerConfig struct {
@@ -0,0 +1,397 @@
+package jose
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/krakend/go-auth0"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	// DPoPScheme is the authorization scheme of the DPoP-bound access tokens
+	DPoPScheme = "DPoP"
+	// DPoPHeader is the header carrying the DPoP proof
+	DPoPHeader = "DPoP"
+
+	dpopProofType        = "dpop+jwt"
+	defaultDPoPIATWindow = time.Minute
+)
+
+var (
+	ErrDPoPProofMissing           = errors.New("the request does not contain a DPoP proof")
+	ErrInvalidDPoPProof           = errors.New("invalid DPoP proof")
+	ErrDPoPProofReplayed          = errors.New("the DPoP proof has already been used")
+	ErrDPoPBindingMismatch        = errors.New("the token is not bound to the DPoP proof key")
+	ErrCertificateMissing         = errors.New("the request does not contain a client certificate")
+	ErrCertificateBindingMismatch = errors.New("the token is not bound to the client certificate")
+)
+
+// DPoPConfig enables the validation of the DPoP proofs (RFC 9449) sent with the access tokens.
+// The tokens bound to a key (cnf.jkt) always require a valid proof signed with that key.
+type DPoPConfig struct {
+	// Required rejects the tokens not bound to a DPoP key
+	Required bool `json:"required,omitempty"`
+	// Algorithms is the allow-list of the signing algorithms of the proofs
+	Algorithms []string `json:"algorithms,omitempty"` // default value is every asymmetric algorithm
+	// IATWindow is the maximum difference between the iat claim of the proof and the local clock
+	IATWindow string `json:"iat_window,omitempty"` // default value is "1m"
+	// TrustedProxies is the list of addresses (IPs or CIDRs) of the proxies allowed to set the
+	// X-Forwarded-Proto header. The header is ignored for the requests from any other address
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+}
+
+var defaultDPoPAlgorithms = []string{
+	string(jose.RS256), string(jose.RS384), string(jose.RS512),
+	string(jose.PS256), string(jose.PS384), string(jose.PS512),
+	string(jose.ES256), string(jose.ES384), string(jose.ES512),
+	string(jose.EdDSA),
+}
+
+// SenderConstraintValidator checks the sender-constrained access tokens: the DPoP-bound ones
+// against the proof of the request and the certificate-bound ones (RFC 8705) against the TLS
+// peer certificate
+type SenderConstraintValidator struct {
+	dpop             *DPoPConfig
+	algorithms       map[string]struct{}
+	iatWindow        time.Duration
+	replay           *jtiCache
+	trustedProxies   []*net.IPNet
+	certificateBound bool
+	now              func() time.Time
+}
+
+// NewSenderConstraintValidator returns a validator for the given config, or nil if neither the
+// DPoP nor the certificate binding are enabled
+func NewSenderConstraintValidator(dpop *DPoPConfig, certificateBound bool) (*SenderConstraintValidator, error) {
+	if dpop == nil && !certificateBound {
+		return nil, nil
+	}
+	v := &SenderConstraintValidator{
+		dpop:             dpop,
+		certificateBound: certificateBound,
+		now:              time.Now,
+	}
+	if dpop == nil {
+		return v, nil
+	}
+
+	v.iatWindow = defaultDPoPIATWindow
+	if dpop.IATWindow != "" {
+		d, err := time.ParseDuration(dpop.IATWindow)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("JOSE: invalid DPoP iat_window %q", dpop.IATWindow)
+		}
+		v.iatWindow = d
+	}
+
+	algs := dpop.Algorithms
+	if len(algs) == 0 {
+		algs = defaultDPoPAlgorithms
+	}
+	v.algorithms = make(map[string]struct{}, len(algs))
+	for _, alg := range algs {
+		if strings.HasPrefix(alg, "HS") || alg == "none" {
+			return nil, fmt.Errorf("JOSE: the DPoP proofs can not use the algorithm %s", alg)
+		}
+		if _, ok := supportedAlgorithms[alg]; !ok {
+			return nil, fmt.Errorf("JOSE: unknown DPoP algorithm %s", alg)
+		}
+		v.algorithms[alg] = struct{}{}
+	}
+	for _, proxy := range dpop.TrustedProxies {
+		network, err := parseNetwork(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("JOSE: invalid DPoP trusted proxy %q", proxy)
+		}
+		v.trustedProxies = append(v.trustedProxies, network)
+	}
+	// a proof is accepted during the iat window before and after its issuing time
+	v.replay = newJTICache(2 * v.iatWindow)
+	return v, nil
+}
+
+// Validate checks the binding of the access token, sent with the given scheme, to the sender
+// of the request
+func (v *SenderConstraintValidator) Validate(r *http.Request, accessToken, scheme string, claims map[string]interface{}) error {
+	if v == nil {
+		return nil
+	}
+	cnf, _ := claims["cnf"].(map[string]interface{})
+
+	if v.certificateBound {
+		x5t, _ := cnf["x5t#S256"].(string)
+		if err := VerifyCertificateBinding(r, x5t); err != nil {
+			return err
+		}
+	}
+
+	if v.dpop == nil {
+		return nil
+	}
+	jkt, _ := cnf["jkt"].(string)
+	if jkt == "" {
+		if v.dpop.Required {
+			return fmt.Errorf("%w: the token has no cnf.jkt claim", ErrDPoPBindingMismatch)
+		}
+		return nil
+	}
+	// the DPoP-bound tokens can not be downgraded to bearer tokens
+	if !strings.EqualFold(scheme, DPoPScheme) {
+		return fmt.Errorf("%w: the token must be sent with the %s scheme", ErrDPoPBindingMismatch, DPoPScheme)
+	}
+	return v.verifyProof(r, accessToken, jkt)
+}
+
+type dpopClaims struct {
+	ID     string           `json:"jti"`
+	Method string           `json:"htm"`
+	URI    string           `json:"htu"`
+	Issued *jwt.NumericDate `json:"iat"`
+	ATH    string           `json:"ath"`
+}
+
+func (v *SenderConstraintValidator) verifyProof(r *http.Request, accessToken, jkt string) error {
+	proofs := r.Header.Values(DPoPHeader)
+	if len(proofs) == 0 {
+		return ErrDPoPProofMissing
+	}
+	if len(proofs) > 1 {
+		return fmt.Errorf("%w: the request contains more than one proof", ErrInvalidDPoPProof)
+	}
+
+	jws, err := jose.ParseSigned(proofs[0])
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidDPoPProof, err.Error())
+	}
+	if len(jws.Signatures) != 1 {
+		return fmt.Errorf("%w: the proof must have exactly one signature", ErrInvalidDPoPProof)
+	}
+	header := jws.Signatures[0].Protected
+	if typ, _ := header.ExtraHeaders[jose.HeaderType].(string); typ != dpopProofType {
+		return fmt.Errorf("%w: unexpected typ %q", ErrInvalidDPoPProof, typ)
+	}
+	if _, ok := v.algorithms[header.Algorithm]; !ok {
+		return fmt.Errorf("%w: the algorithm %s is not allowed", ErrInvalidDPoPProof, header.Algorithm)
+	}
+	key := header.JSONWebKey
+	if key == nil || !key.Valid() || !key.IsPublic() {
+		return fmt.Errorf("%w: the proof must embed a public jwk", ErrInvalidDPoPProof)
+	}
+
+	payload, err := jws.Verify(key.Key)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidDPoPProof, err.Error())
+	}
+	var c dpopClaims
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidDPoPProof, err.Error())
+	}
+
+	if c.ID == "" || c.Issued == nil {
+		return fmt.Errorf("%w: the jti and iat claims are required", ErrInvalidDPoPProof)
+	}
+	if c.Method != r.Method {
+		return fmt.Errorf("%w: the htm claim does not match the request method", ErrInvalidDPoPProof)
+	}
+	if !sameTargetURI(c.URI, r, v.requestScheme(r)) {
+		return fmt.Errorf("%w: the htu claim does not match the request URI", ErrInvalidDPoPProof)
+	}
+	now := v.now()
+	if skew := now.Sub(c.Issued.Time()); skew > v.iatWindow || skew < -v.iatWindow {
+		return fmt.Errorf("%w: the iat claim is out of the accepted window", ErrInvalidDPoPProof)
+	}
+	if !equalHash(c.ATH, accessToken) {
+		return fmt.Errorf("%w: the ath claim does not match the access token", ErrInvalidDPoPProof)
+	}
+
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidDPoPProof, err.Error())
+	}
+	if subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(thumbprint)), []byte(jkt)) != 1 {
+		return ErrDPoPBindingMismatch
+	}
+
+	// the proof is only remembered once it is valid, so the forged ones can not burn a jti
+	if !v.replay.add(jkt+"|"+c.ID, now) {
+		return ErrDPoPProofReplayed
+	}
+	return nil
+}
+
+// VerifyCertificateBinding checks the SHA-256 thumbprint of the TLS client certificate against
+// the cnf.x5t#S256 claim of the token. The client certificates are only available when the
+// service requires them (enable_mtls).
+func VerifyCertificateBinding(r *http.Request, x5t string) error {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ErrCertificateMissing
+	}
+	if x5t == "" {
+		return fmt.Errorf("%w: the token has no cnf.x5t#S256 claim", ErrCertificateBindingMismatch)
+	}
+	sum := sha256.Sum256(r.TLS.PeerCertificates[0].Raw)
+	if subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(x5t)) != 1 {
+		return ErrCertificateBindingMismatch
+	}
+	return nil
+}
+
+// AccessToken returns the raw token of the request and the scheme used to send it, looking at
+// the Bearer and DPoP Authorization headers and then at the cookie
+func AccessToken(r *http.Request, cookieKey string) (string, string) {
+	if h := r.Header.Get("Authorization"); h != "" {
+		scheme, token, ok := strings.Cut(h, " ")
+		if ok && token != "" && (strings.EqualFold(scheme, "Bearer") || strings.EqualFold(scheme, DPoPScheme)) {
+			return token, scheme
+		}
+	}
+	if cookieKey == "" {
+		cookieKey = "access_token"
+	}
+	if c, err := r.Cookie(cookieKey); err == nil {
+		return c.Value, ""
+	}
+	return "", ""
+}
+
+// FromDPoPHeader extracts the token sent with the DPoP authorization scheme
+func FromDPoPHeader(r *http.Request) (*jwt.JSONWebToken, error) {
+	token, scheme := AccessToken(r, "")
+	if token == "" || !strings.EqualFold(scheme, DPoPScheme) {
+		return nil, auth0.ErrTokenNotFound
+	}
+	return jwt.ParseSigned(token)
+}
+
+// requestScheme returns the scheme the client used to send the request. The X-Forwarded-Proto
+// header is only honored when the request comes from a trusted proxy, so the clients can not
+// choose the scheme of the target URI.
+func (v *SenderConstraintValidator) requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	proto := r.Header.Get("X-Forwarded-Proto")
+	if proto == "" || !v.fromTrustedProxy(r) {
+		return "http"
+	}
+	return strings.ToLower(strings.TrimSpace(strings.Split(proto, ",")[0]))
+}
+
+func (v *SenderConstraintValidator) fromTrustedProxy(r *http.Request) bool {
+	if len(v.trustedProxies) == 0 {
+		return false
+	}
+	host, _, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr))
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range v.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseNetwork accepts a CIDR or a single IP address
+func parseNetwork(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, network, err := net.ParseCIDR(s)
+		return network, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %s", s)
+	}
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 8*net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// sameTargetURI compares the htu claim with the request URI, ignoring the query and the fragment
+func sameTargetURI(htu string, r *http.Request, scheme string) bool {
+	u, err := url.Parse(htu)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if !strings.EqualFold(u.Scheme, scheme) {
+		return false
+	}
+	if !strings.EqualFold(normalizeHost(u.Host, scheme), normalizeHost(r.Host, scheme)) {
+		return false
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	return path == r.URL.EscapedPath()
+}
+
+func normalizeHost(host, scheme string) string {
+	h, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return host
+	}
+	if (scheme == "https" && port == "443") || (scheme == "http" && port == "80") {
+		return h
+	}
+	return host
+}
+
+func equalHash(hash, value string) bool {
+	sum := sha256.Sum256([]byte(value))
+	return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(hash)) == 1
+}
+
+// jtiCache remembers the identifiers of the accepted proofs during their lifetime. It is local
+// to the instance, so the gateways behind a load balancer do not share it.
+type jtiCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	seen      map[string]time.Time
+	lastPurge time.Time
+}
+
+func newJTICache(ttl time.Duration) *jtiCache {
+	return &jtiCache{ttl: ttl, seen: map[string]time.Time{}, lastPurge: time.Now()}
+}
+
+// add registers the jti, returning false if it was already registered and not expired
+func (c *jtiCache) add(jti string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.lastPurge) >= c.ttl {
+		for k, exp := range c.seen {
+			if !now.Before(exp) {
+				delete(c.seen, k)
+			}
+		}
+		c.lastPurge = now
+	}
+
+	if exp, ok := c.seen[jti]; ok && now.Before(exp) {
+		return false
+	}
+	c.seen[jti] = now.Add(c.ttl)
+	return true
+}
@@ -0,0 +1,482 @@
+package jose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const testAccessToken = "the.access.token"
+
+var testNow = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+func TestNewSenderConstraintValidator(t *testing.T) {
+	if v, err := NewSenderConstraintValidator(nil, false); v != nil || err != nil {
+		t.Errorf("unexpected validator without constraints: %v, %v", v, err)
+	}
+	if v, err := NewSenderConstraintValidator(&DPoPConfig{}, false); v == nil || err != nil {
+		t.Errorf("unexpected result: %v, %v", v, err)
+	}
+
+	for name, cfg := range map[string]*DPoPConfig{
+		"symmetric algorithm":  {Algorithms: []string{"HS256"}},
+		"none algorithm":       {Algorithms: []string{"none"}},
+		"unknown algorithm":    {Algorithms: []string{"XX999"}},
+		"invalid iat window":   {IATWindow: "soon"},
+		"negative iat window":  {IATWindow: "-1m"},
+		"invalid proxy":        {TrustedProxies: []string{"proxy.local"}},
+		"invalid proxy subnet": {TrustedProxies: []string{"10.0.0.0/33"}},
+	} {
+		if _, err := NewSenderConstraintValidator(cfg, false); err == nil {
+			t.Errorf("%s: error expected", name)
+		}
+	}
+}
+
+func TestSenderConstraintValidator_dpop(t *testing.T) {
+	key := newECKey(t)
+	otherKey := newECKey(t)
+	jkt := thumbprint(t, key.Public())
+
+	validProof := func() dpopClaims {
+		return dpopClaims{
+			ID:     "proof-1",
+			Method: http.MethodPost,
+			URI:    "https://api.example.com/orders",
+			Issued: numericDate(testNow),
+			ATH:    hash(testAccessToken),
+		}
+	}
+
+	for _, tc := range []struct {
+		name    string
+		cfg     DPoPConfig
+		scheme  string
+		jkt     string
+		proofs  func() []string
+		request func(*http.Request)
+		err     error
+	}{
+		{
+			name:   "valid proof",
+			proofs: func() []string { return []string{signProof(t, key, jose.ES256, "dpop+jwt", validProof())} },
+		},
+		{
+			name: "default port in the htu",
+			proofs: func() []string {
+				c := validProof()
+				c.URI = "https://API.example.com:443/orders?page=2#top"
+				return []string{signProof(t, key, jose.ES256, "dpop+jwt", c)}
+			},
+		},
+		{
+			name:   "unbound token",
+			jkt:    "-",
+			proofs: func() []string { return nil },
+		},
+		{
+			name:   "unbound token with a required binding",
+			cfg:    DPoPConfig{Required: true},
+			jkt:    "-",
+			proofs: func() []string { return nil },
+			err:    ErrDPoPBindingMismatch,
+		},
+		{
+			name:   "bound token sent as a bearer one",
+			scheme: "Bearer",
+			proofs: func() []string { return []string{signProof(t, key, jose.ES256, "dpop+jwt", validProof())} },
+			err:    ErrDPoPBindingMismatch,
+		},
+		{
+			name:   "missing proof",
+			proofs: func() []string { return nil },
+			err:    ErrDPoPProofMissing,
+		},
+		{
+			name: "more than one proof",
+			proofs: func() []string {
+				p := signProof(t, key, jose.ES256, "dpop+jwt", validProof())
+				return []string{p, p}
+			},
+			err: ErrInvalidDPoPProof,
+		},
+		{
+			name:   "malformed proof",
+			proofs: func() []string { return []string{"not.a.proof"} },
+			err:    ErrInvalidDPoPProof,
+		},
+		{
+			name:   "unexpected typ",
+			proofs: func() []string { return []string{signProof(t, key, jose.ES256, "JWT", validProof())} },
+			err:    ErrInvalidDPoPProof,
+		},
+		{
+			name:   "algorithm not allowed",
+			cfg:    DPoPConfig{Algorithms: []string{"ES384"}},
+			proofs: func() []string { return []string{signProof(t, key, jose.ES256, "dpop+jwt", validProof())} },
+			err:    ErrInvalidDPoPProof,
+		},
+		{
+			name: "invalid signature",
+			proofs: func() []string {
+				return []string{signProofWithJWK(t, otherKey, key.Public(), jose.ES256, validProof())}
+			},
+			err: ErrInvalidDPoPProof,
+		},
+		{
+			name: "missing jti",
+			proofs: func() []string {
+				c := validProof()
+				c.ID = ""
+				return []string{signProof(t, key, jose.ES256, "dpop+jwt", c)}
+			},
+			err: ErrInvalidDPoPProof,
+		},
+		{
+			name: "missing iat",
+			proofs: func() []string {
+				c := validProof()
+				c.Issued = nil
+				return []string{signProof(t, key, jose.ES256, "dpop+jwt", c)}
+			},
+			err: ErrInvalidDPoPProof,
+		},
+		{
+			name: "htm mismatch",
+			proofs: func() []string {
+				c := validProof()
+				c.Method = http.MethodGet
+				return []string{signProof(t, key, jose.ES256, "dpop+jwt", c)}
+			},
+			err: ErrInvalidDPoPProof,
+		},
+		{
+			name: "htu path mismatch",
+			proofs: func() []string {
+				c := validProof()
+				c.URI = "https://api.example.com/orders/1"
+				return []string{signProof(t, key, jose.ES256, "dpop+jwt", c)}
+			},
+			err: ErrInvalidDPoPProof,
+		},
+		{
+			name: "htu host mismatch",
+			proofs: func() []string {
+				c := validProof()
+				c.URI = "https://evil.example.com/orders"
+				return []string{signProof(t, key, jose.ES256, "dpop+jwt", c)}
+			},
+			err: ErrInvalidDPoPProof,
+		},
+		{
+			name: "htu scheme mismatch",
+			proofs: func() []string {
+				c := validProof()
+				c.URI = "http://api.example.com/orders"
+				return []string{signProof(t, key, jose.ES256, "dpop+jwt", c)}
+			},
+			err: ErrInvalidDPoPProof,
+		},
+		{
+			name: "iat too old",
+			proofs: func() []string {
+				c := validProof()
+				c.Issued = numericDate(testNow.Add(-2 * time.Minute))
+				return []string{signProof(t, key, jose.ES256, "dpop+jwt", c)}
+			},
+			err: ErrInvalidDPoPProof,
+		},
+		{
+			name: "iat in the future",
+			proofs: func() []string {
+				c := validProof()
+				c.Issued = numericDate(testNow.Add(2 * time.Minute))
+				return []string{signProof(t, key, jose.ES256, "dpop+jwt", c)}
+			},
+			err: ErrInvalidDPoPProof,
+		},
+		{
+			name: "iat in a wider window",
+			cfg:  DPoPConfig{IATWindow: "5m"},
+			proofs: func() []string {
+				c := validProof()
+				c.Issued = numericDate(testNow.Add(-2 * time.Minute))
+				return []string{signProof(t, key, jose.ES256, "dpop+jwt", c)}
+			},
+		},
+		{
+			name: "ath mismatch",
+			proofs: func() []string {
+				c := validProof()
+				c.ATH = hash("another.access.token")
+				return []string{signProof(t, key, jose.ES256, "dpop+jwt", c)}
+			},
+			err: ErrInvalidDPoPProof,
+		},
+		{
+			name:   "proof of another key",
+			proofs: func() []string { return []string{signProof(t, otherKey, jose.ES256, "dpop+jwt", validProof())} },
+			err:    ErrDPoPBindingMismatch,
+		},
+		{
+			name:   "forwarded scheme of an untrusted client",
+			proofs: func() []string { return []string{signProof(t, key, jose.ES256, "dpop+jwt", validProof())} },
+			request: func(r *http.Request) {
+				r.TLS = nil
+				r.RemoteAddr = "192.0.2.1:1234"
+				r.Header.Set("X-Forwarded-Proto", "https")
+			},
+			err: ErrInvalidDPoPProof,
+		},
+		{
+			name:   "forwarded scheme of a trusted proxy",
+			cfg:    DPoPConfig{TrustedProxies: []string{"10.0.0.0/8"}},
+			proofs: func() []string { return []string{signProof(t, key, jose.ES256, "dpop+jwt", validProof())} },
+			request: func(r *http.Request) {
+				r.TLS = nil
+				r.RemoteAddr = "10.1.2.3:1234"
+				r.Header.Set("X-Forwarded-Proto", "HTTPS, http")
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			v, err := NewSenderConstraintValidator(&tc.cfg, false)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			v.now = func() time.Time { return testNow }
+
+			r, _ := http.NewRequest(http.MethodPost, "https://api.example.com/orders?page=1", http.NoBody)
+			r.TLS = &tls.ConnectionState{}
+			for _, p := range tc.proofs() {
+				r.Header.Add(DPoPHeader, p)
+			}
+			if tc.request != nil {
+				tc.request(r)
+			}
+			scheme := tc.scheme
+			if scheme == "" {
+				scheme = DPoPScheme
+			}
+			claims := map[string]interface{}{"cnf": map[string]interface{}{"jkt": jkt}}
+			if tc.jkt == "-" {
+				claims = map[string]interface{}{}
+			}
+
+			err = v.Validate(r, testAccessToken, scheme, claims)
+			if tc.err == nil && err != nil {
+				t.Errorf("unexpected error: %s", err.Error())
+			}
+			if tc.err != nil && !errors.Is(err, tc.err) {
+				t.Errorf("unexpected error. have: %v, want: %v", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestSenderConstraintValidator_dpopReplay(t *testing.T) {
+	key := newECKey(t)
+	claims := map[string]interface{}{"cnf": map[string]interface{}{"jkt": thumbprint(t, key.Public())}}
+	v, _ := NewSenderConstraintValidator(&DPoPConfig{}, false)
+	now := testNow
+	v.now = func() time.Time { return now }
+
+	proof := signProof(t, key, jose.ES256, "dpop+jwt", dpopClaims{
+		ID:     "proof-1",
+		Method: http.MethodGet,
+		URI:    "http://api.example.com/",
+		Issued: numericDate(testNow),
+		ATH:    hash(testAccessToken),
+	})
+	validate := func() error {
+		r, _ := http.NewRequest(http.MethodGet, "http://api.example.com/", http.NoBody)
+		r.Header.Set(DPoPHeader, proof)
+		return v.Validate(r, testAccessToken, DPoPScheme, claims)
+	}
+
+	if err := validate(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if err := validate(); !errors.Is(err, ErrDPoPProofReplayed) {
+		t.Errorf("the replayed proof was not rejected: %v", err)
+	}
+	// the jti is remembered while the proof can be accepted
+	now = testNow.Add(time.Minute)
+	if err := validate(); !errors.Is(err, ErrDPoPProofReplayed) {
+		t.Errorf("the replayed proof was not rejected: %v", err)
+	}
+	now = testNow.Add(time.Minute + time.Second)
+	if err := validate(); !errors.Is(err, ErrInvalidDPoPProof) {
+		t.Errorf("the expired proof was not rejected: %v", err)
+	}
+}
+
+func TestSenderConstraintValidator_certificateBound(t *testing.T) {
+	cert := newCertificate(t)
+	sum := sha256.Sum256(cert.Raw)
+	x5t := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	v, err := NewSenderConstraintValidator(nil, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	for _, tc := range []struct {
+		name  string
+		tls   *tls.ConnectionState
+		x5t   string
+		err   error
+		valid bool
+	}{
+		{name: "bound certificate", tls: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}, x5t: x5t, valid: true},
+		{name: "plain connection", x5t: x5t, err: ErrCertificateMissing},
+		{name: "no client certificate", tls: &tls.ConnectionState{}, x5t: x5t, err: ErrCertificateMissing},
+		{name: "unbound token", tls: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}, err: ErrCertificateBindingMismatch},
+		{name: "another certificate", tls: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}, x5t: hash("another"), err: ErrCertificateBindingMismatch},
+	} {
+		r, _ := http.NewRequest(http.MethodGet, "https://api.example.com/", http.NoBody)
+		r.TLS = tc.tls
+		claims := map[string]interface{}{}
+		if tc.x5t != "" {
+			claims["cnf"] = map[string]interface{}{"x5t#S256": tc.x5t}
+		}
+		err := v.Validate(r, testAccessToken, "Bearer", claims)
+		if tc.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.name, err.Error())
+		}
+		if !tc.valid && !errors.Is(err, tc.err) {
+			t.Errorf("%s: unexpected error. have: %v, want: %v", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestAccessToken(t *testing.T) {
+	for _, tc := range []struct {
+		header string
+		cookie string
+		token  string
+		scheme string
+	}{
+		{header: "Bearer abc", token: "abc", scheme: "Bearer"},
+		{header: "dpop abc", token: "abc", scheme: "dpop"},
+		{header: "Basic abc", cookie: "xyz", token: "xyz"},
+		{header: "Bearer", cookie: "xyz", token: "xyz"},
+		{},
+	} {
+		r, _ := http.NewRequest(http.MethodGet, "/", http.NoBody)
+		if tc.header != "" {
+			r.Header.Set("Authorization", tc.header)
+		}
+		if tc.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: "access_token", Value: tc.cookie})
+		}
+		if token, scheme := AccessToken(r, ""); token != tc.token || scheme != tc.scheme {
+			t.Errorf("unexpected token of %+v: %q %q", tc, token, scheme)
+		}
+	}
+}
+
+func newECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	return key
+}
+
+func thumbprint(t *testing.T, key crypto.PublicKey) string {
+	t.Helper()
+	b, err := (&jose.JSONWebKey{Key: key}).Thumbprint(crypto.SHA256)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func hash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func numericDate(t time.Time) *jwt.NumericDate {
+	return jwt.NewNumericDate(t)
+}
+
+// signProof returns a proof signed with the key, embedding its public jwk
+func signProof(t *testing.T, key crypto.Signer, alg jose.SignatureAlgorithm, typ string, c dpopClaims) string {
+	t.Helper()
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: key}, &jose.SignerOptions{
+		EmbedJWK:     true,
+		ExtraHeaders: map[jose.HeaderKey]interface{}{jose.HeaderType: typ},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	return sign(t, signer, c)
+}
+
+// signProofWithJWK returns a proof signed with the key, but embedding the received public key
+func signProofWithJWK(t *testing.T, key crypto.Signer, pub crypto.PublicKey, alg jose.SignatureAlgorithm, c dpopClaims) string {
+	t.Helper()
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: key}, &jose.SignerOptions{
+		ExtraHeaders: map[jose.HeaderKey]interface{}{
+			jose.HeaderType: "dpop+jwt",
+			"jwk":           &jose.JSONWebKey{Key: pub},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	return sign(t, signer, c)
+}
+
+func sign(t *testing.T, signer jose.Signer, c dpopClaims) string {
+	t.Helper()
+	payload, err := json.Marshal(c)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	s, err := jws.CompactSerialize()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	return s
+}
+
+func newCertificate(t *testing.T) *x509.Certificate {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: testNow, NotAfter: testNow.Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	return cert
+}
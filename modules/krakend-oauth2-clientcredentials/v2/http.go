@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"strings"
 
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/clientcredentials"
 
 	"api-gateway/v2/modules/lura/v2/config"
@@ -21,19 +22,18 @@ func NewHTTPClient(cfg *config.Backend) client.HTTPClientFactory {
 	if !ok || oauth.IsDisabled {
 		return client.NewHTTPClient
 	}
-	c := clientcredentials.Config{
-		ClientID:       oauth.ClientID,
-		ClientSecret:   oauth.ClientSecret,
-		TokenURL:       oauth.TokenURL,
-		Scopes:         strings.Split(oauth.Scopes, ","),
-		EndpointParams: oauth.EndpointParams,
-	}
-	cli := c.Client(context.Background())
+	cli := oauth.clientCredentials().Client(context.Background())
 	return func(_ context.Context) *http.Client {
 		return cli
 	}
 }
 
+// NewTokenSource returns a reusable token source dealing with all the logic related to the
+// oauth2 client credentials grant, so the tokens can be injected by other transports
+func NewTokenSource(ctx context.Context, cfg Config) oauth2.TokenSource {
+	return cfg.clientCredentials().TokenSource(ctx)
+}
+
 // Config is the custom config struct containing the params for the golang.org/x/oauth2/clientcredentials package
 type Config struct {
 	IsDisabled     bool
@@ -44,6 +44,16 @@ type Config struct {
 	EndpointParams map[string][]string
 }
 
+func (c Config) clientCredentials() *clientcredentials.Config {
+	return &clientcredentials.Config{
+		ClientID:       c.ClientID,
+		ClientSecret:   c.ClientSecret,
+		TokenURL:       c.TokenURL,
+		Scopes:         strings.Split(c.Scopes, ","),
+		EndpointParams: c.EndpointParams,
+	}
+}
+
 // ZeroCfg is the zero value for the Config struct
 var ZeroCfg = Config{}
 
@@ -56,6 +66,11 @@ func configGetter(e config.ExtraConfig) interface{} {
 	if !ok {
 		return nil
 	}
+	return ParseConfig(tmp)
+}
+
+// ParseConfig builds a Config with the params declared in the received map
+func ParseConfig(tmp map[string]interface{}) Config {
 	cfg := Config{}
 	if v, ok := tmp["is_disabled"]; ok {
 		cfg.IsDisabled = v.(bool)
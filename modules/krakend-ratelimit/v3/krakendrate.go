@@ -24,6 +24,21 @@ var (
 // Limiter defines a simple interface for a rate limiter
 type Limiter interface {
 	Allow() bool
+	// Take behaves as Allow, also reporting the state of the limiter after the decision
+	Take() Decision
+}
+
+// Decision is the result of taking a token from a limiter
+type Decision struct {
+	Allowed bool
+	// Limit is the capacity of the limiter. It is zero when the limiter can not report its state
+	Limit uint64
+	// Remaining is the number of tokens left after the decision
+	Remaining uint64
+	// RetryAfter is the time until the next token is available, if the request was rejected
+	RetryAfter time.Duration
+	// Reset is the time until the limiter is full again
+	Reset time.Duration
 }
 
 // LimiterStore defines the interface for a limiter lookup function
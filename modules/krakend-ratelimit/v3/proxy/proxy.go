@@ -37,6 +37,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"api-gateway/v2/modules/lura/v2/config"
@@ -53,17 +54,34 @@ const Namespace = "qos/ratelimit/proxy"
 type Config struct {
 	MaxRate  float64
 	Capacity uint64
+	// Redis is the store shared by the instances of the gateway. The limits are kept in memory,
+	// per instance, when it is not set
+	Redis *krakendrate.RedisConfig
+	// Quota is the maximum number of requests sent to the backend in a long period
+	Quota *krakendrate.QuotaConfig
 }
 
 // BackendFactory adds a ratelimiting middleware wrapping the internal factory
 func BackendFactory(logger logging.Logger, next proxy.BackendFactory) proxy.BackendFactory {
+	return BackendFactoryWithContext(context.Background(), logger, next)
+}
+
+// BackendFactoryWithContext adds a ratelimiting middleware wrapping the internal factory. The
+// quota backends are released once the context is cancelled.
+func BackendFactoryWithContext(ctx context.Context, logger logging.Logger, next proxy.BackendFactory) proxy.BackendFactory {
 	return func(cfg *config.Backend) proxy.Proxy {
-		return NewMiddleware(logger, cfg)(next(cfg))
+		return NewMiddlewareWithContext(ctx, logger, cfg)(next(cfg))
 	}
 }
 
 // NewMiddleware builds a middleware based on the extra config params or fallbacks to the next proxy
 func NewMiddleware(logger logging.Logger, remote *config.Backend) proxy.Middleware {
+	return NewMiddlewareWithContext(context.Background(), logger, remote)
+}
+
+// NewMiddlewareWithContext builds a middleware based on the extra config params or fallbacks to the
+// next proxy. The quota backends are released once the context is cancelled.
+func NewMiddlewareWithContext(ctx context.Context, logger logging.Logger, remote *config.Backend) proxy.Middleware {
 	logPrefix := "[BACKEND: " + remote.URLPattern + "][Ratelimit]"
 	cfg, err := ConfigGetter(remote.ExtraConfig)
 	if err != nil {
@@ -72,20 +90,47 @@ func NewMiddleware(logger logging.Logger, remote *config.Backend) proxy.Middlewa
 		}
 		return proxy.EmptyMiddleware
 	}
-	if cfg.MaxRate <= 0 {
+	if cfg.MaxRate <= 0 && cfg.Quota == nil {
 		return proxy.EmptyMiddleware
 	}
 
-	if cfg.Capacity == 0 {
-		if cfg.MaxRate < 1 {
-			cfg.Capacity = 1
+	scope := fmt.Sprintf("proxy:%s %s:%s %s%s", remote.ParentEndpointMethod, remote.ParentEndpoint, remote.Method, strings.Join(remote.Host, ","), remote.URLPattern)
+	onError := krakendrate.ThrottledErrorHandler(10*time.Second, func(err error) {
+		logger.Error(logPrefix, "Rate limit store failure:", err.Error())
+	})
+
+	var tb krakendrate.Limiter = krakendrate.FixedLimiter(true)
+	if cfg.MaxRate > 0 {
+		if cfg.Capacity == 0 {
+			if cfg.MaxRate < 1 {
+				cfg.Capacity = 1
+			} else {
+				cfg.Capacity = uint64(cfg.MaxRate)
+			}
+		}
+
+		tb = krakendrate.NewTokenBucket(cfg.MaxRate, cfg.Capacity)
+		if cfg.Redis != nil {
+			backend := krakendrate.NewRedisBackend(*cfg.Redis, scope, onError)
+			tb = krakendrate.NewLimiterStore(cfg.MaxRate, int(cfg.Capacity), backend)("backend")
+			logger.Debug(logPrefix, "Enabling the distributed rate limiter. Failure policy:", cfg.Redis.FailurePolicy)
+		} else {
+			logger.Debug(logPrefix, "Enabling the rate limiter")
+		}
+	}
+
+	// the quota counts all the requests sent to the backend. An invalid quota is skipped, but
+	// the rate limit is still applied.
+	var quota krakendrate.Limiter = krakendrate.FixedLimiter(true)
+	if cfg.Quota != nil {
+		if backend, err := krakendrate.NewQuotaBackend(ctx, *cfg.Quota, cfg.Redis); err != nil {
+			logger.Error(logPrefix, "Quota:", err.Error())
 		} else {
-			cfg.Capacity = uint64(cfg.MaxRate)
+			quota = krakendrate.NewQuota(*cfg.Quota, scope, backend, onError).Store()("backend")
+			logger.Debug(logPrefix, fmt.Sprintf("Enabling the quota. MaxRequests: %d, Period: %s, Window: %s", cfg.Quota.MaxRequests, cfg.Quota.Period, cfg.Quota.Window))
 		}
 	}
 
-	tb := krakendrate.NewTokenBucket(cfg.MaxRate, cfg.Capacity)
-	logger.Debug(logPrefix, "Enabling the rate limiter")
 	return func(next ...proxy.Proxy) proxy.Proxy {
 		if len(next) > 1 {
 			panic(proxy.ErrTooManyProxies)
@@ -94,6 +139,9 @@ func NewMiddleware(logger logging.Logger, remote *config.Backend) proxy.Middlewa
 			if !tb.Allow() {
 				return nil, krakendrate.ErrLimited
 			}
+			if !quota.Allow() {
+				return nil, krakendrate.ErrQuotaExceeded
+			}
 			return next[0](ctx, request)
 		}
 	}
@@ -140,6 +188,22 @@ func ConfigGetter(e config.ExtraConfig) (Config, error) {
 		}
 	}
 
+	if v, ok := tmp["redis"]; ok {
+		redisCfg, err := krakendrate.RedisConfigGetter(v)
+		if err != nil {
+			return ZeroCfg, err
+		}
+		cfg.Redis = redisCfg
+	}
+
+	if v, ok := tmp["quota"]; ok {
+		quotaCfg, err := krakendrate.QuotaConfigGetter(v)
+		if err != nil {
+			return ZeroCfg, err
+		}
+		cfg.Quota = quotaCfg
+	}
+
 	factor := 1.0
 	if v, ok := tmp["every"]; ok {
 		every, err := time.ParseDuration(fmt.Sprintf("%v", v))
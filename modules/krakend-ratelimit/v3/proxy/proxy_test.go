@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"api-gateway/v2/modules/lura/v2/config"
+	"api-gateway/v2/modules/lura/v2/logging"
+	"api-gateway/v2/modules/lura/v2/proxy"
+
+	krakendrate "api-gateway/v2/modules/krakend-ratelimit/v3"
+)
+
+func TestNewMiddlewareWithContext_invalidQuota(t *testing.T) {
+	// a corrupted snapshot can not be restored, so the quota backend fails
+	snapshot := filepath.Join(t.TempDir(), "quotas.json")
+	if err := os.WriteFile(snapshot, []byte("not a snapshot"), 0o600); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p := NewMiddlewareWithContext(ctx, logging.NoOp, &config.Backend{
+		URLPattern: "/",
+		ExtraConfig: config.ExtraConfig{Namespace: map[string]interface{}{
+			"max_rate": 1,
+			"quota": map[string]interface{}{
+				"max_requests":  100,
+				"period":        "day",
+				"snapshot_file": snapshot,
+			},
+		}},
+	})(proxy.NoopProxy)
+
+	if _, err := p(context.Background(), &proxy.Request{}); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if _, err := p(context.Background(), &proxy.Request{}); !errors.Is(err, krakendrate.ErrLimited) {
+		t.Errorf("the max_rate was not applied: %v", err)
+	}
+}
+
+func TestNewMiddlewareWithContext_quota(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p := NewMiddlewareWithContext(ctx, logging.NoOp, &config.Backend{
+		URLPattern: "/",
+		ExtraConfig: config.ExtraConfig{Namespace: map[string]interface{}{
+			"quota": map[string]interface{}{
+				"max_requests": 2,
+				"period":       "day",
+			},
+		}},
+	})(proxy.NoopProxy)
+
+	for i := 0; i < 2; i++ {
+		if _, err := p(context.Background(), &proxy.Request{}); err != nil {
+			t.Errorf("unexpected error of the request #%d: %s", i, err.Error())
+		}
+	}
+	if _, err := p(context.Background(), &proxy.Request{}); !errors.Is(err, krakendrate.ErrQuotaExceeded) {
+		t.Errorf("the quota was not applied: %v", err)
+	}
+}
@@ -0,0 +1,257 @@
+package krakendrate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrQuotaExceeded is the error returned when the quota of the period has been consumed
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// The kinds of quota windows
+const (
+	// WindowFixed counts the requests of the current period
+	WindowFixed = "fixed"
+	// WindowSliding weights the requests of the previous period by the part of it still inside
+	// the sliding window, so the quota is not fully restored at the start of every period
+	WindowSliding = "sliding"
+)
+
+// The calendar periods of the quotas. Any other period must be a duration, aligned to the epoch.
+const (
+	PeriodHour  = "hour"
+	PeriodDay   = "day"
+	PeriodWeek  = "week"
+	PeriodMonth = "month"
+)
+
+const defaultQuotaSnapshotInterval = time.Minute
+
+// QuotaConfig is the maximum number of requests of every client in a long period
+//
+//	"quota": {
+//		"max_requests": 10000,
+//		"period": "month",
+//		"time_zone": "Europe/Madrid",
+//		"snapshot_file": "/var/lib/krakend/quotas.json"
+//	}
+type QuotaConfig struct {
+	MaxRequests uint64 `json:"max_requests"`
+	// Period is a calendar period (hour, day, week or month) or a duration
+	Period string `json:"period"`
+	// Window is the kind of window counting the requests: fixed or sliding
+	Window string `json:"window"` // default value is "fixed"
+	// TimeZone is the location of the calendar periods
+	TimeZone string `json:"time_zone"` // default value is "UTC"
+	// SnapshotFile is the file persisting the counters of the memory backend across restarts
+	SnapshotFile string `json:"snapshot_file"`
+	// SnapshotInterval is the time between two snapshots of the counters
+	SnapshotInterval string `json:"snapshot_interval"` // default value is "1m"
+
+	// Every is the legacy name of the duration periods
+	Every string `json:"every"`
+
+	duration         time.Duration
+	location         *time.Location
+	snapshotInterval time.Duration
+}
+
+// QuotaConfigGetter parses the quota config of the rate limit namespaces
+func QuotaConfigGetter(v interface{}) (*QuotaConfig, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	cfg := new(QuotaConfig)
+	if err := json.Unmarshal(b, cfg); err != nil {
+		return nil, fmt.Errorf("invalid quota config: %w", err)
+	}
+
+	if cfg.MaxRequests == 0 {
+		return nil, errors.New("the quota requires a positive max_requests")
+	}
+	if cfg.Period == "" {
+		cfg.Period = cfg.Every
+	}
+	switch cfg.Period {
+	case PeriodHour, PeriodDay, PeriodWeek, PeriodMonth:
+	case "":
+		return nil, errors.New("the quota requires a period")
+	default:
+		if cfg.duration, err = parsePositiveDuration(cfg.Period, 0); err != nil {
+			return nil, fmt.Errorf("invalid quota period %q", cfg.Period)
+		}
+	}
+	switch cfg.Window {
+	case "":
+		cfg.Window = WindowFixed
+	case WindowFixed, WindowSliding:
+	default:
+		return nil, fmt.Errorf("unknown quota window %q", cfg.Window)
+	}
+	if cfg.location, err = time.LoadLocation(cfg.TimeZone); err != nil {
+		return nil, fmt.Errorf("invalid quota time_zone: %w", err)
+	}
+	if cfg.snapshotInterval, err = parsePositiveDuration(cfg.SnapshotInterval, defaultQuotaSnapshotInterval); err != nil {
+		return nil, fmt.Errorf("invalid quota snapshot_interval: %w", err)
+	}
+	return cfg, nil
+}
+
+// QuotaWindow is the window counting the requests at a given time
+type QuotaWindow struct {
+	Start time.Time
+	End   time.Time
+	// PreviousStart is the start of the previous period
+	PreviousStart time.Time
+	// PreviousWeight is the part of the requests of the previous period counted in the window. It
+	// is always zero for the fixed windows.
+	PreviousWeight float64
+}
+
+// window returns the window of the config containing the time
+func (cfg *QuotaConfig) window(now time.Time) QuotaWindow {
+	start, end := cfg.period(now)
+	w := QuotaWindow{Start: start, End: end}
+	w.PreviousStart, _ = cfg.period(start.Add(-time.Nanosecond))
+	if cfg.Window == WindowSliding {
+		w.PreviousWeight = 1 - float64(now.Sub(start))/float64(end.Sub(start))
+	}
+	return w
+}
+
+// period returns the limits of the period containing the time
+func (cfg *QuotaConfig) period(now time.Time) (time.Time, time.Time) {
+	if cfg.duration > 0 {
+		// Truncate aligns to the zero time, so the start is computed from the epoch instead
+		d := int64(cfg.duration)
+		start := time.Unix(0, now.UnixNano()/d*d).In(now.Location())
+		return start, start.Add(cfg.duration)
+	}
+
+	t := now.In(cfg.location)
+	y, m, d := t.Date()
+	switch cfg.Period {
+	case PeriodHour:
+		// the local hour is ambiguous when the clocks go back, so the minutes are subtracted
+		// from the time instead
+		start := t.Add(-time.Duration(t.Minute())*time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+		return start, start.Add(time.Hour)
+	case PeriodWeek:
+		// the weeks start on monday
+		start := time.Date(y, m, d-(int(t.Weekday())+6)%7, 0, 0, 0, 0, cfg.location)
+		return start, start.AddDate(0, 0, 7)
+	case PeriodMonth:
+		start := time.Date(y, m, 1, 0, 0, 0, 0, cfg.location)
+		return start, start.AddDate(0, 1, 0)
+	}
+	start := time.Date(y, m, d, 0, 0, 0, 0, cfg.location)
+	return start, start.AddDate(0, 0, 1)
+}
+
+// QuotaCount is the number of requests of a key counted in the periods of a window
+type QuotaCount struct {
+	// Current is the number of requests of the period of the window
+	Current uint64
+	// Previous is the number of requests of the previous period
+	Previous uint64
+}
+
+// Used returns the number of requests counted in the window
+func (c QuotaCount) Used(w QuotaWindow) uint64 {
+	return uint64(float64(c.Previous)*w.PreviousWeight) + c.Current
+}
+
+// QuotaBackend is the interface of the persistence layer of the quota counters
+type QuotaBackend interface {
+	// Take adds the request to the counter of the key in the window if the requests counted in
+	// the window are under the limit. It returns if the request was allowed and the counters of
+	// the window, including the allowed request. The backends failing return the decision of
+	// their failure policy along with the error.
+	Take(key string, w QuotaWindow, limit uint64) (bool, QuotaCount, error)
+}
+
+// NewQuotaBackend returns the backend of the quota: the redis store if it is configured, or the
+// memory, persisted in the snapshot file of the quota config if it has one
+func NewQuotaBackend(ctx context.Context, cfg QuotaConfig, redis *RedisConfig) (QuotaBackend, error) {
+	if redis != nil {
+		return NewRedisQuotaBackend(*redis), nil
+	}
+	if cfg.SnapshotFile != "" {
+		return SharedMemoryQuotaBackend(ctx, cfg.SnapshotFile, cfg.snapshotInterval)
+	}
+	return NewMemoryQuotaBackend(ctx), nil
+}
+
+// Quota limits the number of requests of every key in the windows of the config
+type Quota struct {
+	cfg     QuotaConfig
+	scope   string
+	backend QuotaBackend
+	onError func(error)
+}
+
+// NewQuota returns a Quota keeping its counters in the backend under the scope, that must be
+// unique for every quota sharing the backend. The errors of the backend are sent to the onError
+// function.
+func NewQuota(cfg QuotaConfig, scope string, backend QuotaBackend, onError func(error)) *Quota {
+	if onError == nil {
+		onError = func(error) {}
+	}
+	return &Quota{cfg: cfg, scope: scope, backend: backend, onError: onError}
+}
+
+// Take counts the request of the key, reporting the requests left in the window and the time
+// until the end of the period
+func (q *Quota) Take(key string) Decision {
+	n := now()
+	w := q.cfg.window(n)
+	allowed, count, err := q.backend.Take(q.scope+":"+key, w, q.cfg.MaxRequests)
+	if err != nil {
+		q.onError(err)
+		return Decision{Allowed: allowed}
+	}
+
+	d := Decision{Allowed: allowed, Limit: q.cfg.MaxRequests, Reset: w.End.Sub(n)}
+	if used := count.Used(w); used < q.cfg.MaxRequests {
+		d.Remaining = q.cfg.MaxRequests - used
+	}
+	if !allowed {
+		d.RetryAfter = retryAfter(w, count, q.cfg.MaxRequests, n)
+	}
+	return d
+}
+
+// retryAfter returns the time until the window has room for another request. The requests of the
+// previous period fade out of a sliding window as it moves, so a rejected request can be retried
+// once enough of them are not counted anymore. Otherwise, the client must wait until the end of
+// the period.
+func retryAfter(w QuotaWindow, count QuotaCount, limit uint64, n time.Time) time.Duration {
+	if w.PreviousWeight <= 0 || count.Previous == 0 || count.Current >= limit {
+		return w.End.Sub(n)
+	}
+	// the requests are allowed again once previous*weight < limit-current, and the weight
+	// decreases linearly until the end of the period
+	free := float64(limit-count.Current) / float64(count.Previous)
+	at := w.End.Add(-time.Duration(free * float64(w.End.Sub(w.Start))))
+	return max(at.Sub(n), 0)
+}
+
+// Store returns a LimiterStore with the limiters of the keys of the quota
+func (q *Quota) Store() LimiterStore {
+	return func(key string) Limiter {
+		return quotaLimiter{quota: q, key: key}
+	}
+}
+
+type quotaLimiter struct {
+	quota *Quota
+	key   string
+}
+
+func (l quotaLimiter) Allow() bool { return l.quota.Take(l.key).Allowed }
+
+func (l quotaLimiter) Take() Decision { return l.quota.Take(l.key) }
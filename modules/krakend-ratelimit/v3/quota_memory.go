@@ -0,0 +1,203 @@
+package krakendrate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// quotaEvictionInterval is the time between two purges of the expired quota counters
+const quotaEvictionInterval = time.Minute
+
+// quotaCounter is the number of requests of a key in the current and the previous periods
+type quotaCounter struct {
+	Start         time.Time `json:"start"`
+	Count         uint64    `json:"count"`
+	PreviousStart time.Time `json:"previous_start"`
+	PreviousCount uint64    `json:"previous_count"`
+	// Expires is the time the counter is not needed by any window anymore
+	Expires time.Time `json:"expires"`
+}
+
+// MemoryQuotaBackend is a QuotaBackend keeping the counters in memory, optionally persisted in a
+// snapshot file, so the quotas survive the restarts of the gateway
+type MemoryQuotaBackend struct {
+	counters map[string]*quotaCounter
+	mu       *sync.Mutex
+}
+
+// NewMemoryQuotaBackend returns a MemoryQuotaBackend purging the expired counters until the
+// context is cancelled
+func NewMemoryQuotaBackend(ctx context.Context) *MemoryQuotaBackend {
+	m := &MemoryQuotaBackend{
+		counters: map[string]*quotaCounter{},
+		mu:       new(sync.Mutex),
+	}
+	go m.manageEvictions(ctx)
+	return m
+}
+
+var (
+	quotaSnapshots   = map[string]*MemoryQuotaBackend{}
+	quotaSnapshotsMu = new(sync.Mutex)
+)
+
+// SharedMemoryQuotaBackend returns the MemoryQuotaBackend persisted in the snapshot file, so all
+// the quotas declaring the same file share it. The backend is restored from the file when it is
+// created and it is saved every interval and once the context is cancelled. The first config sets
+// the interval.
+func SharedMemoryQuotaBackend(ctx context.Context, path string, interval time.Duration) (*MemoryQuotaBackend, error) {
+	quotaSnapshotsMu.Lock()
+	defer quotaSnapshotsMu.Unlock()
+	if m, ok := quotaSnapshots[path]; ok {
+		return m, nil
+	}
+
+	m := NewMemoryQuotaBackend(ctx)
+	if err := m.restoreFile(path); err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		interval = defaultQuotaSnapshotInterval
+	}
+	go m.manageSnapshots(ctx, path, interval)
+	quotaSnapshots[path] = m
+	return m, nil
+}
+
+// Take implements the QuotaBackend interface
+func (m *MemoryQuotaBackend) Take(key string, w QuotaWindow, limit uint64) (bool, QuotaCount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.counters[key]
+	if !ok {
+		c = &quotaCounter{Start: w.Start}
+		m.counters[key] = c
+	}
+	if !c.Start.Equal(w.Start) {
+		c.PreviousStart, c.PreviousCount = time.Time{}, 0
+		if c.Start.Equal(w.PreviousStart) {
+			c.PreviousStart, c.PreviousCount = c.Start, c.Count
+		}
+		c.Start, c.Count = w.Start, 0
+	}
+	c.Expires = w.End
+	if w.PreviousWeight > 0 {
+		// the counter is the previous period of the next window
+		c.Expires = w.End.Add(w.End.Sub(w.Start))
+	}
+
+	count := QuotaCount{Current: c.Count}
+	if w.PreviousWeight > 0 {
+		count.Previous = c.PreviousCount
+	}
+	if count.Used(w) >= limit {
+		return false, count, nil
+	}
+	c.Count++
+	count.Current++
+	return true, count, nil
+}
+
+// Snapshot writes the counters as a JSON object
+func (m *MemoryQuotaBackend) Snapshot(w io.Writer) error {
+	m.mu.Lock()
+	b, err := json.Marshal(m.counters)
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// Restore adds the unexpired counters of a snapshot to the backend
+func (m *MemoryQuotaBackend) Restore(r io.Reader) error {
+	counters := map[string]*quotaCounter{}
+	if err := json.NewDecoder(r).Decode(&counters); err != nil {
+		return err
+	}
+	n := now()
+	m.mu.Lock()
+	for k, c := range counters {
+		if c != nil && c.Expires.After(n) {
+			m.counters[k] = c
+		}
+	}
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *MemoryQuotaBackend) restoreFile(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("opening the quota snapshot: %w", err)
+	}
+	defer f.Close()
+	if err := m.Restore(f); err != nil {
+		return fmt.Errorf("reading the quota snapshot %s: %w", path, err)
+	}
+	return nil
+}
+
+// saveFile replaces the snapshot file, writing a temporary file first so a crash never leaves a
+// truncated snapshot
+func (m *MemoryQuotaBackend) saveFile(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if err := m.Snapshot(f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (m *MemoryQuotaBackend) manageSnapshots(ctx context.Context, path string, interval time.Duration) {
+	t := time.NewTicker(interval)
+	for {
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			m.saveFile(path)
+			return
+		case <-t.C:
+			m.saveFile(path)
+		}
+	}
+}
+
+func (m *MemoryQuotaBackend) manageEvictions(ctx context.Context) {
+	t := time.NewTicker(quotaEvictionInterval)
+	for {
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case n := <-t.C:
+			m.mu.Lock()
+			for k, c := range m.counters {
+				if !c.Expires.After(n) {
+					delete(m.counters, k)
+				}
+			}
+			m.mu.Unlock()
+		}
+	}
+}
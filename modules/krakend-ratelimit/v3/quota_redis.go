@@ -0,0 +1,73 @@
+package krakendrate
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// quotaTakeScript counts the request in the counter of the current period if the requests of the
+// window are under the limit, atomically. The counters expire once no window needs them.
+var quotaTakeScript = newRedisScript(`
+local limit = tonumber(ARGV[1])
+local weight = tonumber(ARGV[2])
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+local previous = 0
+if weight > 0 then
+	previous = tonumber(redis.call('GET', KEYS[2]) or '0')
+end
+
+if math.floor(previous * weight) + current >= limit then
+	return {0, current, previous}
+end
+redis.call('INCR', KEYS[1])
+redis.call('PEXPIREAT', KEYS[1], ARGV[3])
+return {1, current + 1, previous}
+`)
+
+// RedisQuotaBackend is a QuotaBackend keeping the counters in a Redis-protocol compatible store,
+// so all the instances of the gateway share the same quotas. The periods are computed with the
+// clock of the gateways.
+type RedisQuotaBackend struct {
+	pool     *redisPool
+	prefix   string
+	failOpen bool
+}
+
+// NewRedisQuotaBackend returns a RedisQuotaBackend using the store of the config
+func NewRedisQuotaBackend(cfg RedisConfig) *RedisQuotaBackend {
+	return &RedisQuotaBackend{
+		pool:     sharedRedisPool(cfg),
+		prefix:   cfg.KeyPrefix + "quota:",
+		failOpen: cfg.FailurePolicy != FailClosed,
+	}
+}
+
+// Take implements the QuotaBackend interface
+func (b *RedisQuotaBackend) Take(key string, w QuotaWindow, limit uint64) (bool, QuotaCount, error) {
+	expires := w.End
+	if w.PreviousWeight > 0 {
+		expires = w.End.Add(w.End.Sub(w.Start))
+	}
+	keys := []string{
+		b.prefix + key + ":" + strconv.FormatInt(w.Start.Unix(), 10),
+		b.prefix + key + ":" + strconv.FormatInt(w.PreviousStart.Unix(), 10),
+	}
+	res, err := b.pool.eval(
+		quotaTakeScript,
+		keys,
+		strconv.FormatUint(limit, 10),
+		strconv.FormatFloat(w.PreviousWeight, 'f', 6, 64),
+		strconv.FormatInt(expires.UnixMilli(), 10),
+	)
+	if err != nil {
+		return b.failOpen, QuotaCount{}, err
+	}
+	values, ok := res.([]interface{})
+	if !ok || len(values) != 3 {
+		return b.failOpen, QuotaCount{}, fmt.Errorf("redis: unexpected reply of the quota script: %v", res)
+	}
+	allowed, _ := values[0].(int64)
+	current, _ := values[1].(int64)
+	previous, _ := values[2].(int64)
+	return allowed == 1, QuotaCount{Current: uint64(max(current, 0)), Previous: uint64(max(previous, 0))}, nil
+}
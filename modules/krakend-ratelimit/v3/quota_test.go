@@ -0,0 +1,368 @@
+package krakendrate
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestQuotaConfig_window(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		period   string
+		timeZone string
+		now      string
+		start    string
+		end      string
+		previous string
+	}{
+		{
+			name:   "duration aligned to the epoch",
+			period: "7h", now: "1970-01-01T08:00:00Z",
+			start: "1970-01-01T07:00:00Z", end: "1970-01-01T14:00:00Z", previous: "1970-01-01T00:00:00Z",
+		},
+		{
+			name:   "duration aligned to the epoch in a time zone",
+			period: "7h", timeZone: "Europe/Madrid", now: "2024-05-01T12:00:00Z",
+			start: "2024-05-01T10:00:00Z", end: "2024-05-01T17:00:00Z", previous: "2024-05-01T03:00:00Z",
+		},
+		{
+			name:   "hour",
+			period: PeriodHour, now: "2024-05-01T12:59:59.999Z",
+			start: "2024-05-01T12:00:00Z", end: "2024-05-01T13:00:00Z", previous: "2024-05-01T11:00:00Z",
+		},
+		{
+			name:   "hour in a half hour time zone",
+			period: PeriodHour, timeZone: "Asia/Kolkata", now: "2024-05-01T12:10:00Z",
+			start: "2024-05-01T11:30:00Z", end: "2024-05-01T12:30:00Z", previous: "2024-05-01T10:30:00Z",
+		},
+		{
+			name:   "hour after the clocks go forward",
+			period: PeriodHour, timeZone: "Europe/Madrid", now: "2024-03-31T01:30:00Z",
+			start: "2024-03-31T01:00:00Z", end: "2024-03-31T02:00:00Z", previous: "2024-03-31T00:00:00Z",
+		},
+		{
+			name:   "first repeated hour when the clocks go back",
+			period: PeriodHour, timeZone: "Europe/Madrid", now: "2024-10-27T00:30:00Z",
+			start: "2024-10-27T00:00:00Z", end: "2024-10-27T01:00:00Z", previous: "2024-10-26T23:00:00Z",
+		},
+		{
+			name:   "second repeated hour when the clocks go back",
+			period: PeriodHour, timeZone: "Europe/Madrid", now: "2024-10-27T01:30:00Z",
+			start: "2024-10-27T01:00:00Z", end: "2024-10-27T02:00:00Z", previous: "2024-10-27T00:00:00Z",
+		},
+		{
+			name:   "day",
+			period: PeriodDay, now: "2024-05-01T23:59:59Z",
+			start: "2024-05-01T00:00:00Z", end: "2024-05-02T00:00:00Z", previous: "2024-04-30T00:00:00Z",
+		},
+		{
+			name:   "day in a time zone",
+			period: PeriodDay, timeZone: "America/New_York", now: "2024-05-02T02:00:00Z",
+			start: "2024-05-01T04:00:00Z", end: "2024-05-02T04:00:00Z", previous: "2024-04-30T04:00:00Z",
+		},
+		{
+			name:   "23 hours day",
+			period: PeriodDay, timeZone: "Europe/Madrid", now: "2024-03-31T12:00:00Z",
+			start: "2024-03-30T23:00:00Z", end: "2024-03-31T22:00:00Z", previous: "2024-03-29T23:00:00Z",
+		},
+		{
+			name:   "25 hours day",
+			period: PeriodDay, timeZone: "Europe/Madrid", now: "2024-10-27T12:00:00Z",
+			start: "2024-10-26T22:00:00Z", end: "2024-10-27T23:00:00Z", previous: "2024-10-25T22:00:00Z",
+		},
+		{
+			name:   "week across the years",
+			period: PeriodWeek, now: "2025-01-01T10:00:00Z",
+			start: "2024-12-30T00:00:00Z", end: "2025-01-06T00:00:00Z", previous: "2024-12-23T00:00:00Z",
+		},
+		{
+			name:   "week starting on sunday night",
+			period: PeriodWeek, timeZone: "Europe/Madrid", now: "2024-05-05T22:30:00Z",
+			start: "2024-05-05T22:00:00Z", end: "2024-05-12T22:00:00Z", previous: "2024-04-28T22:00:00Z",
+		},
+		{
+			name:   "week across the DST change",
+			period: PeriodWeek, timeZone: "Europe/Madrid", now: "2024-03-31T12:00:00Z",
+			start: "2024-03-24T23:00:00Z", end: "2024-03-31T22:00:00Z", previous: "2024-03-17T23:00:00Z",
+		},
+		{
+			name:   "leap february",
+			period: PeriodMonth, now: "2024-02-29T23:00:00Z",
+			start: "2024-02-01T00:00:00Z", end: "2024-03-01T00:00:00Z", previous: "2024-01-01T00:00:00Z",
+		},
+		{
+			name:   "month across the years",
+			period: PeriodMonth, now: "2025-01-15T00:00:00Z",
+			start: "2025-01-01T00:00:00Z", end: "2025-02-01T00:00:00Z", previous: "2024-12-01T00:00:00Z",
+		},
+		{
+			name:   "month across the DST change",
+			period: PeriodMonth, timeZone: "Europe/Madrid", now: "2024-03-31T21:30:00Z",
+			start: "2024-02-29T23:00:00Z", end: "2024-03-31T22:00:00Z", previous: "2024-01-31T23:00:00Z",
+		},
+		{
+			name:   "first hour of the month in a time zone",
+			period: PeriodMonth, timeZone: "Europe/Madrid", now: "2024-03-31T22:00:00Z",
+			start: "2024-03-31T22:00:00Z", end: "2024-04-30T22:00:00Z", previous: "2024-02-29T23:00:00Z",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := newTestQuotaConfig(t, map[string]interface{}{"period": tc.period, "time_zone": tc.timeZone})
+			w := cfg.window(parseTime(t, tc.now))
+			if !w.Start.Equal(parseTime(t, tc.start)) {
+				t.Errorf("unexpected start. have: %s, want: %s", w.Start.UTC(), tc.start)
+			}
+			if !w.End.Equal(parseTime(t, tc.end)) {
+				t.Errorf("unexpected end. have: %s, want: %s", w.End.UTC(), tc.end)
+			}
+			if !w.PreviousStart.Equal(parseTime(t, tc.previous)) {
+				t.Errorf("unexpected previous start. have: %s, want: %s", w.PreviousStart.UTC(), tc.previous)
+			}
+			if w.PreviousWeight != 0 {
+				t.Errorf("unexpected weight of a fixed window: %f", w.PreviousWeight)
+			}
+		})
+	}
+}
+
+func TestQuotaConfig_window_sliding(t *testing.T) {
+	cfg := newTestQuotaConfig(t, map[string]interface{}{"period": PeriodDay, "window": WindowSliding, "time_zone": "Europe/Madrid"})
+
+	for now, want := range map[string]float64{
+		"2024-05-01T22:00:00Z": 1,
+		"2024-05-02T04:00:00Z": 0.75,
+		"2024-05-02T10:00:00Z": 0.5,
+		"2024-05-02T21:59:59Z": 1 - float64(24*time.Hour-time.Second)/float64(24*time.Hour),
+		// the weight follows the length of the 23 hours day
+		"2024-03-31T10:30:00Z": 0.5,
+	} {
+		if w := cfg.window(parseTime(t, now)); w.PreviousWeight != want {
+			t.Errorf("unexpected weight at %s. have: %f, want: %f", now, w.PreviousWeight, want)
+		}
+	}
+}
+
+func TestQuota_Take(t *testing.T) {
+	defer func() { now = time.Now }()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := newTestQuotaConfig(t, map[string]interface{}{"max_requests": 10, "period": "1h", "window": WindowSliding})
+	q := NewQuota(*cfg, "scope", NewMemoryQuotaBackend(ctx), nil)
+
+	setNow(t, "2024-05-01T10:30:00Z")
+	for i := 0; i < 8; i++ {
+		if d := q.Take("client"); !d.Allowed || d.Remaining != uint64(9-i) {
+			t.Errorf("unexpected decision #%d: %+v", i, d)
+		}
+	}
+
+	// 90% of the previous period is still in the window, so 7 of its requests are counted
+	setNow(t, "2024-05-01T11:06:00Z")
+	for i := 0; i < 3; i++ {
+		d := q.Take("client")
+		want := Decision{Allowed: true, Limit: 10, Remaining: uint64(2 - i), Reset: 54 * time.Minute}
+		if d != want {
+			t.Errorf("unexpected decision #%d. have: %+v, want: %+v", i, d, want)
+		}
+	}
+	// the window has room again once 87.5% of the previous period is in it, at 11:07:30
+	d := q.Take("client")
+	want := Decision{Limit: 10, RetryAfter: 90 * time.Second, Reset: 54 * time.Minute}
+	if d != want {
+		t.Errorf("unexpected decision. have: %+v, want: %+v", d, want)
+	}
+	setNow(t, "2024-05-01T11:07:31Z")
+	if d := q.Take("client"); !d.Allowed || d.Remaining != 0 {
+		t.Errorf("unexpected decision after the retry: %+v", d)
+	}
+	if !q.Take("other client").Allowed {
+		t.Error("the clients share the counters")
+	}
+
+	// the whole previous period is in the window at the start of the period
+	setNow(t, "2024-05-01T12:00:00Z")
+	if d := q.Take("client"); !d.Allowed || d.Remaining != 5 {
+		t.Errorf("unexpected decision: %+v", d)
+	}
+	// the requests older than the previous period are not counted
+	setNow(t, "2024-05-01T14:00:00Z")
+	if d := q.Take("client"); !d.Allowed || d.Remaining != 9 {
+		t.Errorf("unexpected decision: %+v", d)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	start := parseTime(t, "2024-05-01T10:00:00Z")
+	sliding := QuotaWindow{Start: start, End: start.Add(time.Hour), PreviousWeight: 0.5}
+	fixed := QuotaWindow{Start: start, End: start.Add(time.Hour)}
+	n := start.Add(30 * time.Minute)
+
+	for _, tc := range []struct {
+		name  string
+		w     QuotaWindow
+		count QuotaCount
+		want  time.Duration
+	}{
+		{name: "fixed window", w: fixed, count: QuotaCount{Current: 10}, want: 30 * time.Minute},
+		{name: "full current period", w: sliding, count: QuotaCount{Current: 10, Previous: 20}, want: 30 * time.Minute},
+		{name: "empty previous period", w: sliding, count: QuotaCount{Current: 9}, want: 30 * time.Minute},
+		// 10 of the previous requests are counted, and the window has room once they are less than 5
+		{name: "previous period", w: sliding, count: QuotaCount{Current: 5, Previous: 20}, want: 15 * time.Minute},
+		{name: "already expired", w: sliding, count: QuotaCount{Current: 5, Previous: 8}, want: 0},
+	} {
+		if d := retryAfter(tc.w, tc.count, 10, n); d != tc.want {
+			t.Errorf("%s: unexpected retry after. have: %s, want: %s", tc.name, d, tc.want)
+		}
+	}
+}
+
+func TestMemoryQuotaBackend_snapshot(t *testing.T) {
+	defer func() { now = time.Now }()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := newTestQuotaConfig(t, map[string]interface{}{"period": PeriodMonth, "window": WindowSliding})
+	setNow(t, "2024-05-20T10:00:00Z")
+	w := cfg.window(now())
+
+	m := NewMemoryQuotaBackend(ctx)
+	for i := 0; i < 3; i++ {
+		m.Take("a", w, 100)
+	}
+	m.Take("b", w, 100)
+	expired := cfg.window(parseTime(t, "2024-01-20T10:00:00Z"))
+	m.Take("expired", expired, 100)
+
+	buf := new(bytes.Buffer)
+	if err := m.Snapshot(buf); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	restored := NewMemoryQuotaBackend(ctx)
+	if err := restored.Restore(buf); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if len(restored.counters) != 2 {
+		t.Errorf("unexpected counters: %v", restored.counters)
+	}
+	for key, used := range map[string]uint64{"a": 4, "b": 2} {
+		if _, n, _ := restored.Take(key, w, 100); n.Used(w) != used {
+			t.Errorf("unexpected count of %s. have: %d, want: %d", key, n.Used(w), used)
+		}
+	}
+
+	// the counters of the restored backend move to the next period
+	next := cfg.window(parseTime(t, "2024-06-01T00:00:00Z"))
+	if _, n, _ := restored.Take("a", next, 100); n != (QuotaCount{Current: 1, Previous: 4}) {
+		t.Errorf("unexpected count in the next period: %+v", n)
+	}
+
+	if err := restored.Restore(bytes.NewBufferString("not json")); err == nil {
+		t.Error("error expected restoring an invalid snapshot")
+	}
+}
+
+func TestSharedMemoryQuotaBackend(t *testing.T) {
+	defer func() { now = time.Now }()
+	setNow(t, "2024-05-20T10:00:00Z")
+	cfg := newTestQuotaConfig(t, map[string]interface{}{"period": PeriodDay})
+	w := cfg.window(now())
+	path := filepath.Join(t.TempDir(), "quotas.json")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m, err := SharedMemoryQuotaBackend(ctx, path, time.Hour)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		cancel()
+		return
+	}
+	if shared, _ := SharedMemoryQuotaBackend(ctx, path, time.Hour); shared != m {
+		t.Error("the backends of the same file are not shared")
+	}
+	m.Take("a", w, 10)
+	m.Take("a", w, 10)
+
+	// the snapshot is saved once the context is cancelled
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Error("the snapshot was not saved")
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	restored := NewMemoryQuotaBackend(context.Background())
+	if err := restored.restoreFile(path); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, n, _ := restored.Take("a", w, 10); n.Current != 3 {
+		t.Errorf("unexpected count after the restore: %+v", n)
+	}
+
+	if err := restored.restoreFile(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Errorf("unexpected error restoring a missing file: %s", err.Error())
+	}
+}
+
+func TestQuotaConfigGetter(t *testing.T) {
+	for name, v := range map[string]map[string]interface{}{
+		"missing max_requests": {"period": PeriodDay},
+		"missing period":       {"max_requests": 1},
+		"invalid period":       {"max_requests": 1, "period": "fortnight"},
+		"negative period":      {"max_requests": 1, "period": "-1h"},
+		"unknown window":       {"max_requests": 1, "period": PeriodDay, "window": "rolling"},
+		"unknown time zone":    {"max_requests": 1, "period": PeriodDay, "time_zone": "Mars/Olympus"},
+	} {
+		if _, err := QuotaConfigGetter(v); err == nil {
+			t.Errorf("%s: error expected", name)
+		}
+	}
+
+	cfg, err := QuotaConfigGetter(map[string]interface{}{"max_requests": 1, "every": "10m"})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if cfg.Period != "10m" || cfg.duration != 10*time.Minute || cfg.Window != WindowFixed {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+}
+
+func newTestQuotaConfig(t *testing.T, v map[string]interface{}) *QuotaConfig {
+	t.Helper()
+	if _, ok := v["max_requests"]; !ok {
+		v["max_requests"] = 1
+	}
+	cfg, err := QuotaConfigGetter(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func parseTime(t *testing.T, s string) time.Time {
+	t.Helper()
+	n, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func setNow(t *testing.T, s string) {
+	n := parseTime(t, s)
+	now = func() time.Time { return n }
+}
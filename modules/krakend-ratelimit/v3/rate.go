@@ -15,7 +15,7 @@ func NewMemoryStore(maxRate float64, capacity int) LimiterStore {
 func NewLimiterStore(maxRate float64, capacity int, backend Backend) LimiterStore {
 	f := func() interface{} { return NewTokenBucket(maxRate, uint64(capacity)) }
 	return func(t string) Limiter {
-		return backend.Load(t, f).(*TokenBucket)
+		return backend.Load(t, f).(Limiter)
 	}
 }
 
@@ -82,6 +82,30 @@ func (t *TokenBucket) Allow() bool {
 	return r
 }
 
+// Take implements the Limiter interface
+func (t *TokenBucket) Take() Decision {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	d := Decision{Allowed: t.canConsume(), Limit: t.capacity}
+
+	// the refill is delayed until the bucket is empty, so the tokens generated since the last
+	// refill are still pending
+	elapsed := t.clock.Since(t.lastRefill)
+	pending := uint64(elapsed / t.fillInterval)
+	d.Remaining = t.tokens + pending
+	if d.Remaining >= t.capacity {
+		d.Remaining = t.capacity
+		return d
+	}
+
+	d.Reset = time.Duration(t.capacity-t.tokens)*t.fillInterval - elapsed
+	if !d.Allowed {
+		d.RetryAfter = t.fillInterval - elapsed
+	}
+	return d
+}
+
 func (t *TokenBucket) canConsume() bool {
 	if t.tokens > 0 {
 		// delay the refill until the bucket is empty
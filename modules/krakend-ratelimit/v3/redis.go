@@ -0,0 +1,286 @@
+package krakendrate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The policies applied when the store can not be reached
+const (
+	// FailOpen allows the requests while the store is unavailable
+	FailOpen = "open"
+	// FailClosed rejects the requests while the store is unavailable
+	FailClosed = "closed"
+)
+
+const (
+	defaultRedisPoolSize    = 10
+	defaultRedisDialTimeout = time.Second
+	defaultRedisTimeout     = 100 * time.Millisecond
+	defaultRedisKeyPrefix   = "krakend:ratelimit:"
+)
+
+// ErrRedisStoreUnsupported is returned when storing a bucket in the redis backend, as the
+// buckets are only updated by the store itself
+var ErrRedisStoreUnsupported = errors.New("the redis backend does not support storing buckets")
+
+// RedisConfig is the config of a Redis-protocol compatible store shared by all the instances
+// of the gateway
+//
+//	"redis": {
+//		"address": "redis:6379",
+//		"pool_size": 20,
+//		"failure_policy": "closed"
+//	}
+type RedisConfig struct {
+	Address  string `json:"address"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	DB       int    `json:"db"`
+	// PoolSize is the maximum number of connections to the store
+	PoolSize    int    `json:"pool_size"`    // default value is 10
+	DialTimeout string `json:"dial_timeout"` // default value is "1s"
+	// Timeout bounds every command, including the wait for a free connection
+	Timeout   string `json:"timeout"`    // default value is "100ms"
+	KeyPrefix string `json:"key_prefix"` // default value is "krakend:ratelimit:"
+	// FailurePolicy decides if the requests are allowed (open) or rejected (closed) when the
+	// store fails
+	FailurePolicy string `json:"failure_policy"` // default value is "open"
+
+	dialTimeout time.Duration
+	timeout     time.Duration
+}
+
+// RedisConfigGetter parses the redis config of the rate limit namespaces
+func RedisConfigGetter(v interface{}) (*RedisConfig, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	cfg := new(RedisConfig)
+	if err := json.Unmarshal(b, cfg); err != nil {
+		return nil, fmt.Errorf("invalid redis config: %w", err)
+	}
+
+	if cfg.Address == "" {
+		return nil, errors.New("the redis config requires an address")
+	}
+	if cfg.PoolSize <= 0 {
+		cfg.PoolSize = defaultRedisPoolSize
+	}
+	if cfg.KeyPrefix == "" {
+		cfg.KeyPrefix = defaultRedisKeyPrefix
+	}
+	switch cfg.FailurePolicy {
+	case "":
+		cfg.FailurePolicy = FailOpen
+	case FailOpen, FailClosed:
+	default:
+		return nil, fmt.Errorf("unknown redis failure_policy %q", cfg.FailurePolicy)
+	}
+	if cfg.dialTimeout, err = parsePositiveDuration(cfg.DialTimeout, defaultRedisDialTimeout); err != nil {
+		return nil, fmt.Errorf("invalid redis dial_timeout: %w", err)
+	}
+	if cfg.timeout, err = parsePositiveDuration(cfg.Timeout, defaultRedisTimeout); err != nil {
+		return nil, fmt.Errorf("invalid redis timeout: %w", err)
+	}
+	return cfg, nil
+}
+
+func parsePositiveDuration(v string, d time.Duration) (time.Duration, error) {
+	if v == "" {
+		return d, nil
+	}
+	res, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, err
+	}
+	if res <= 0 {
+		return 0, errors.New("the duration must be positive")
+	}
+	return res, nil
+}
+
+// takeScript refills the bucket with the tokens generated since the last refill and takes one,
+// atomically. It uses the clock of the store, so the gateways do not need synchronized clocks.
+// The absent buckets are full, so the keys expire once the bucket would be full again.
+var takeScript = newRedisScript(`
+local capacity = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens = tonumber(state[1])
+local last = tonumber(state[2])
+if tokens == nil or last == nil then
+	tokens = capacity
+	last = now
+end
+
+if now > last then
+	local added = math.floor((now - last) / interval)
+	if added > 0 then
+		tokens = math.min(capacity, tokens + added)
+		last = last + added * interval
+	end
+end
+if tokens >= capacity then
+	last = now
+end
+
+local allowed = 0
+local retry = 0
+if tokens > 0 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = interval - (now - last)
+end
+local reset = (capacity - tokens) * interval - (now - last)
+
+-- the timestamps are formatted as integers, as the default conversion loses precision
+redis.call('HSET', KEYS[1], 'tokens', tokens, 'last', string.format('%d', last))
+redis.call('PEXPIRE', KEYS[1], string.format('%d', math.ceil((capacity - tokens) * interval / 1000) + 1000))
+return {allowed, tokens, retry, reset}
+`)
+
+// RedisBackend is a Backend keeping the token buckets in a Redis-protocol compatible store, so
+// all the instances of the gateway share the same limits
+type RedisBackend struct {
+	pool     *redisPool
+	prefix   string
+	failOpen bool
+	onError  func(error)
+
+	// the rate and capacity of the buckets, taken from the first template received
+	once     *sync.Once
+	template *redisBucketTemplate
+}
+
+// redisBucketTemplate holds the params of the take script shared by all the buckets of a backend
+type redisBucketTemplate struct {
+	limit        uint64
+	capacity     string
+	fillInterval string
+	err          error
+}
+
+// NewRedisBackend returns a RedisBackend storing the buckets under the scope, that must be unique
+// for every limiter sharing the store. The errors of the store are sent to the onError function.
+func NewRedisBackend(cfg RedisConfig, scope string, onError func(error)) *RedisBackend {
+	if onError == nil {
+		onError = func(error) {}
+	}
+	return &RedisBackend{
+		pool:     sharedRedisPool(cfg),
+		prefix:   cfg.KeyPrefix + scope + ":",
+		failOpen: cfg.FailurePolicy != FailClosed,
+		onError:  onError,
+		once:     new(sync.Once),
+	}
+}
+
+// Load implements the Backend interface. The received function must return a *TokenBucket,
+// used as the template of the rate and capacity of the remote buckets. All the keys of a backend
+// share the same limits, so the template is only built once.
+func (b *RedisBackend) Load(key string, f func() interface{}) interface{} {
+	b.once.Do(func() { b.template = newRedisBucketTemplate(f()) })
+	if b.template.err != nil {
+		b.onError(b.template.err)
+		return FixedLimiter(b.failOpen)
+	}
+	return &RedisTokenBucket{
+		backend:      b,
+		key:          b.prefix + key,
+		limit:        b.template.limit,
+		capacity:     b.template.capacity,
+		fillInterval: b.template.fillInterval,
+	}
+}
+
+func newRedisBucketTemplate(v interface{}) *redisBucketTemplate {
+	tb, ok := v.(*TokenBucket)
+	if !ok {
+		return &redisBucketTemplate{err: fmt.Errorf("the redis backend can not store a %T", v)}
+	}
+	// the store measures the time in microseconds
+	interval := tb.fillInterval.Microseconds()
+	if interval < 1 {
+		interval = 1
+	}
+	return &redisBucketTemplate{
+		limit:        tb.capacity,
+		capacity:     strconv.FormatUint(tb.capacity, 10),
+		fillInterval: strconv.FormatInt(interval, 10),
+	}
+}
+
+// Store implements the Backend interface
+func (*RedisBackend) Store(_ string, _ interface{}) error {
+	return ErrRedisStoreUnsupported
+}
+
+// RedisTokenBucket is a token bucket living in the store of a RedisBackend
+type RedisTokenBucket struct {
+	backend      *RedisBackend
+	key          string
+	limit        uint64
+	capacity     string
+	fillInterval string
+}
+
+// Allow implements the Limiter interface
+func (t *RedisTokenBucket) Allow() bool {
+	return t.Take().Allowed
+}
+
+// Take implements the Limiter interface. If the store fails, the failure policy of the
+// backend decides and the state of the bucket is not reported.
+func (t *RedisTokenBucket) Take() Decision {
+	res, err := t.backend.pool.eval(takeScript, []string{t.key}, t.capacity, t.fillInterval)
+	if err != nil {
+		t.backend.onError(err)
+		return Decision{Allowed: t.backend.failOpen}
+	}
+	values, ok := res.([]interface{})
+	if !ok || len(values) != 4 {
+		t.backend.onError(fmt.Errorf("redis: unexpected reply of the take script: %v", res))
+		return Decision{Allowed: t.backend.failOpen}
+	}
+	allowed, _ := values[0].(int64)
+	remaining, _ := values[1].(int64)
+	retry, _ := values[2].(int64)
+	reset, _ := values[3].(int64)
+	return Decision{
+		Allowed:    allowed == 1,
+		Limit:      t.limit,
+		Remaining:  uint64(max(remaining, 0)),
+		RetryAfter: time.Duration(max(retry, 0)) * time.Microsecond,
+		Reset:      time.Duration(max(reset, 0)) * time.Microsecond,
+	}
+}
+
+// FixedLimiter is a Limiter always returning the same decision
+type FixedLimiter bool
+
+// Allow implements the Limiter interface
+func (l FixedLimiter) Allow() bool { return bool(l) }
+
+// Take implements the Limiter interface
+func (l FixedLimiter) Take() Decision { return Decision{Allowed: bool(l)} }
+
+// ThrottledErrorHandler wraps the error handler, so at most one error is handled every period
+// and the failures of the store do not flood the logs
+func ThrottledErrorHandler(period time.Duration, f func(error)) func(error) {
+	tb := NewTokenBucket(float64(time.Second)/float64(period), 1)
+	return func(err error) {
+		if tb.Allow() {
+			f(err)
+		}
+	}
+}
@@ -0,0 +1,411 @@
+package krakendrate
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRedisConn_readReply(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		reply string
+		want  interface{}
+		err   bool
+	}{
+		{name: "simple string", reply: "+OK\r\n", want: "OK"},
+		{name: "error", reply: "-NOSCRIPT No matching script\r\n", want: redisError("NOSCRIPT No matching script")},
+		{name: "integer", reply: ":-42\r\n", want: int64(-42)},
+		{name: "bulk string", reply: "$5\r\nhe\r\no\r\n", want: "he\r\no"},
+		{name: "empty bulk string", reply: "$0\r\n\r\n", want: ""},
+		{name: "null bulk string", reply: "$-1\r\n", want: nil},
+		{
+			name:  "nested array",
+			reply: "*3\r\n:1\r\n*2\r\n+a\r\n$1\r\nb\r\n-ERR nested\r\n",
+			want:  []interface{}{int64(1), []interface{}{"a", "b"}, redisError("ERR nested")},
+		},
+		{name: "empty array", reply: "*0\r\n", want: []interface{}{}},
+		{name: "missing carriage return", reply: "+OK\n", err: true},
+		{name: "unknown type", reply: "?OK\r\n", err: true},
+		{name: "invalid integer", reply: ":one\r\n", err: true},
+		{name: "truncated bulk string", reply: "$10\r\nabc\r\n", err: true},
+		{name: "truncated array", reply: "*2\r\n:1\r\n", err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &redisConn{r: bufio.NewReader(strings.NewReader(tc.reply))}
+			res, err := c.readReply()
+			if tc.err {
+				if err == nil {
+					t.Errorf("error expected, got %v", res)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %s", err.Error())
+				return
+			}
+			if !reflect.DeepEqual(res, tc.want) {
+				t.Errorf("unexpected reply. have: %#v, want: %#v", res, tc.want)
+			}
+		})
+	}
+}
+
+func TestRedisPool_evalFallback(t *testing.T) {
+	s := newFakeRedis(t, nil)
+	p := newRedisPool(fakeRedisConfig(t, s.address(), nil))
+	script := newRedisScript("return 1")
+
+	for i := 0; i < 2; i++ {
+		res, err := p.eval(script, []string{"key"}, "arg")
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if res != int64(1) {
+			t.Errorf("unexpected reply: %v", res)
+		}
+	}
+
+	want := []string{
+		"EVALSHA " + script.hash + " 1 key arg",
+		"EVAL return 1 1 key arg",
+		"EVALSHA " + script.hash + " 1 key arg",
+	}
+	if cmds := s.commands(); !reflect.DeepEqual(cmds, want) {
+		t.Errorf("unexpected commands. have: %q, want: %q", cmds, want)
+	}
+}
+
+func TestRedisPool_evalError(t *testing.T) {
+	s := newFakeRedis(t, func(args []string) string {
+		return "-ERR user_script:1: boom\r\n"
+	})
+	p := newRedisPool(fakeRedisConfig(t, s.address(), nil))
+
+	_, err := p.eval(newRedisScript("return 1"), []string{"key"})
+	var rerr redisError
+	if !errors.As(err, &rerr) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// the errors of the script are not retried
+	if cmds := s.commands(); len(cmds) != 2 {
+		t.Errorf("unexpected commands: %q", cmds)
+	}
+}
+
+func TestRedisPool_slots(t *testing.T) {
+	s := newFakeRedis(t, nil)
+	p := newRedisPool(fakeRedisConfig(t, s.address(), map[string]interface{}{
+		"pool_size": 1,
+		"timeout":   "20ms",
+	}))
+
+	c, err := p.get()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if _, err := p.get(); err != ErrPoolExhausted {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// the error replies do not break the connection, so it is reused
+	p.put(c, redisError("ERR"))
+	reused, err := p.get()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if reused != c {
+		t.Error("the idle connection was not reused")
+	}
+
+	// the network errors close the connection and release its slot
+	p.put(reused, io.EOF)
+	c, err = p.get()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if c == reused {
+		t.Error("the broken connection was reused")
+	}
+	_, err = c.do("PING")
+	p.put(c, err)
+
+	if n := s.connections(); n != 2 {
+		t.Errorf("unexpected number of connections: %d", n)
+	}
+	if len(p.slots) != 1 || len(p.idle) != 1 {
+		t.Errorf("unexpected pool state. slots: %d, idle: %d", len(p.slots), len(p.idle))
+	}
+}
+
+func TestRedisPool_dialError(t *testing.T) {
+	p := newRedisPool(fakeRedisConfig(t, closedAddress(t), map[string]interface{}{"pool_size": 1}))
+
+	for i := 0; i < 2; i++ {
+		if _, err := p.do("PING"); err == nil || err == ErrPoolExhausted {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if len(p.slots) != 0 {
+		t.Errorf("the slot of the failed dial was not released: %d", len(p.slots))
+	}
+}
+
+func TestRedisPool_auth(t *testing.T) {
+	s := newFakeRedis(t, nil)
+	p := newRedisPool(fakeRedisConfig(t, s.address(), map[string]interface{}{
+		"username": "user",
+		"password": "secret",
+		"db":       2,
+	}))
+
+	if _, err := p.do("PING"); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	want := []string{"AUTH user secret", "SELECT 2", "PING"}
+	if cmds := s.commands(); !reflect.DeepEqual(cmds, want) {
+		t.Errorf("unexpected commands. have: %q, want: %q", cmds, want)
+	}
+}
+
+func TestRedisBackend_Load(t *testing.T) {
+	s := newFakeRedis(t, func(args []string) string {
+		return "*4\r\n:1\r\n:4\r\n:0\r\n:200000\r\n"
+	})
+	b := NewRedisBackend(fakeRedisConfig(t, s.address(), map[string]interface{}{"key_prefix": "test:"}), "scope", nil)
+
+	calls := 0
+	f := func() interface{} {
+		calls++
+		return NewTokenBucket(10, 5)
+	}
+	for _, key := range []string{"a", "b", "a"} {
+		l, ok := b.Load(key, f).(*RedisTokenBucket)
+		if !ok {
+			t.Errorf("unexpected limiter for %s", key)
+			return
+		}
+		if l.key != "test:scope:"+key || l.capacity != "5" || l.fillInterval != "100000" {
+			t.Errorf("unexpected bucket: %+v", l)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("the template was built %d times", calls)
+	}
+
+	d := b.Load("a", f).(Limiter).Take()
+	want := Decision{Allowed: true, Limit: 5, Remaining: 4, Reset: 200 * time.Millisecond}
+	if d != want {
+		t.Errorf("unexpected decision. have: %+v, want: %+v", d, want)
+	}
+
+	args := s.commands()[0]
+	if !strings.HasSuffix(args, " 1 test:scope:a 5 100000") {
+		t.Errorf("unexpected command: %s", args)
+	}
+}
+
+func TestRedisBackend_invalidTemplate(t *testing.T) {
+	for _, policy := range []string{FailOpen, FailClosed} {
+		var errs []error
+		b := NewRedisBackend(fakeRedisConfig(t, closedAddress(t), map[string]interface{}{"failure_policy": policy}), "scope", func(err error) {
+			errs = append(errs, err)
+		})
+		l := b.Load("a", func() interface{} { return "not a bucket" }).(Limiter)
+		if l.Allow() != (policy == FailOpen) {
+			t.Errorf("unexpected decision of the %s policy", policy)
+		}
+		if len(errs) != 1 {
+			t.Errorf("unexpected errors: %v", errs)
+		}
+	}
+}
+
+func TestRedisBackend_failurePolicy(t *testing.T) {
+	unavailable := closedAddress(t)
+	invalidReply := newFakeRedis(t, func(args []string) string {
+		return ":1\r\n"
+	}).address()
+	scriptError := newFakeRedis(t, func(args []string) string {
+		return "-ERR boom\r\n"
+	}).address()
+
+	for _, address := range []string{unavailable, invalidReply, scriptError} {
+		for _, policy := range []string{FailOpen, FailClosed} {
+			t.Run(fmt.Sprintf("%s %s", address, policy), func(t *testing.T) {
+				var errs []error
+				b := NewRedisBackend(fakeRedisConfig(t, address, map[string]interface{}{"failure_policy": policy}), "scope", func(err error) {
+					errs = append(errs, err)
+				})
+				d := NewLimiterStore(1, 1, b)("key").Take()
+				if want := (Decision{Allowed: policy == FailOpen}); d != want {
+					t.Errorf("unexpected decision. have: %+v, want: %+v", d, want)
+				}
+				if len(errs) != 1 {
+					t.Errorf("unexpected errors: %v", errs)
+				}
+			})
+		}
+	}
+}
+
+func fakeRedisConfig(t *testing.T, address string, extra map[string]interface{}) RedisConfig {
+	v := map[string]interface{}{"address": address}
+	for k, e := range extra {
+		v[k] = e
+	}
+	cfg, err := RedisConfigGetter(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *cfg
+}
+
+// closedAddress returns an address nobody is listening on
+func closedAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+	return address
+}
+
+// fakeRedis is an in-process server of the Redis protocol, recording the received commands. The
+// scripts are unknown until they are sent with EVAL, and then they reply with the handler, if
+// any, or with the integer 1.
+type fakeRedis struct {
+	l       net.Listener
+	handler func(args []string) string
+
+	mu      *sync.Mutex
+	cmds    []string
+	conns   int
+	scripts map[string]struct{}
+}
+
+func newFakeRedis(t *testing.T, handler func(args []string) string) *fakeRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if handler == nil {
+		handler = func([]string) string { return ":1\r\n" }
+	}
+	s := &fakeRedis{
+		l:       l,
+		handler: handler,
+		mu:      new(sync.Mutex),
+		scripts: map[string]struct{}{},
+	}
+	go s.serve()
+	t.Cleanup(func() { l.Close() })
+	return s
+}
+
+func (s *fakeRedis) address() string {
+	return s.l.Addr().String()
+}
+
+func (s *fakeRedis) commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.cmds...)
+}
+
+func (s *fakeRedis) connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns
+}
+
+func (s *fakeRedis) serve() {
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns++
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		if _, err := io.WriteString(conn, s.reply(args)); err != nil {
+			return
+		}
+	}
+}
+
+func (s *fakeRedis) reply(args []string) string {
+	s.mu.Lock()
+	s.cmds = append(s.cmds, strings.Join(args, " "))
+	defer s.mu.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "AUTH", "SELECT":
+		return "+OK\r\n"
+	case "EVALSHA":
+		if _, ok := s.scripts[args[1]]; !ok {
+			return "-NOSCRIPT No matching script. Please use EVAL.\r\n"
+		}
+	case "EVAL":
+		s.scripts[newRedisScript(args[1]).hash] = struct{}{}
+	}
+	return s.handler(args)
+}
+
+// readCommand decodes a command sent as an array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	n, err := readHeader(r, '*')
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		size, err := readHeader(r, '$')
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+func readHeader(r *bufio.Reader, kind byte) (int, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return 0, err
+	}
+	if len(line) < 4 || line[0] != kind {
+		return 0, fmt.Errorf("unexpected line %q", line)
+	}
+	return strconv.Atoi(strings.TrimSuffix(line[1:], "\r\n"))
+}
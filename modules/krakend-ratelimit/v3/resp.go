@@ -0,0 +1,253 @@
+package krakendrate
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrPoolExhausted is the error returned when no connection to the store is available in time
+var ErrPoolExhausted = errors.New("redis: connection pool exhausted")
+
+// redisError is an error reply sent by the store. It does not break the connection.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// redisPool is a minimal client of the Redis protocol (RESP2), keeping a bounded set of
+// connections to a single server
+type redisPool struct {
+	address     string
+	username    string
+	password    string
+	db          int
+	dialTimeout time.Duration
+	timeout     time.Duration
+
+	idle  chan *redisConn
+	slots chan struct{}
+}
+
+func newRedisPool(cfg RedisConfig) *redisPool {
+	return &redisPool{
+		address:     cfg.Address,
+		username:    cfg.Username,
+		password:    cfg.Password,
+		db:          cfg.DB,
+		dialTimeout: cfg.dialTimeout,
+		timeout:     cfg.timeout,
+		idle:        make(chan *redisConn, cfg.PoolSize),
+		slots:       make(chan struct{}, cfg.PoolSize),
+	}
+}
+
+var (
+	redisPools   = map[string]*redisPool{}
+	redisPoolsMu = new(sync.Mutex)
+)
+
+// sharedRedisPool returns the pool of the server and database of the config, so all the
+// limiters using the same store share the connections. The first config sets the pool size.
+func sharedRedisPool(cfg RedisConfig) *redisPool {
+	key := fmt.Sprintf("%s|%s|%d", cfg.Address, cfg.Username, cfg.DB)
+	redisPoolsMu.Lock()
+	defer redisPoolsMu.Unlock()
+	p, ok := redisPools[key]
+	if !ok {
+		p = newRedisPool(cfg)
+		redisPools[key] = p
+	}
+	return p
+}
+
+func (p *redisPool) get() (*redisConn, error) {
+	select {
+	case c := <-p.idle:
+		return c, nil
+	default:
+	}
+
+	t := time.NewTimer(p.timeout)
+	defer t.Stop()
+	select {
+	case c := <-p.idle:
+		return c, nil
+	case p.slots <- struct{}{}:
+		c, err := p.dial()
+		if err != nil {
+			<-p.slots
+			return nil, err
+		}
+		return c, nil
+	case <-t.C:
+		return nil, ErrPoolExhausted
+	}
+}
+
+// put returns the connection to the pool, closing it if it failed with a network error
+func (p *redisPool) put(c *redisConn, err error) {
+	var rerr redisError
+	if err != nil && !errors.As(err, &rerr) {
+		c.Close()
+		<-p.slots
+		return
+	}
+	select {
+	case p.idle <- c:
+	default:
+		c.Close()
+		<-p.slots
+	}
+}
+
+func (p *redisPool) dial() (*redisConn, error) {
+	conn, err := net.DialTimeout("tcp", p.address, p.dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{Conn: conn, r: bufio.NewReader(conn), timeout: p.timeout}
+
+	if p.password != "" {
+		args := []string{"AUTH", p.password}
+		if p.username != "" {
+			args = []string{"AUTH", p.username, p.password}
+		}
+		if _, err := c.do(args...); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if p.db != 0 {
+		if _, err := c.do("SELECT", strconv.Itoa(p.db)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// do sends the command through a connection of the pool and returns the reply
+func (p *redisPool) do(args ...string) (interface{}, error) {
+	c, err := p.get()
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.do(args...)
+	p.put(c, err)
+	return res, err
+}
+
+// eval runs the script by its digest, loading it only when the store does not know it yet
+func (p *redisPool) eval(s *redisScript, keys []string, args ...string) (interface{}, error) {
+	params := make([]string, 0, 3+len(keys)+len(args))
+	params = append(params, "EVALSHA", s.hash, strconv.Itoa(len(keys)))
+	params = append(params, keys...)
+	params = append(params, args...)
+
+	res, err := p.do(params...)
+	var rerr redisError
+	if errors.As(err, &rerr) && strings.HasPrefix(string(rerr), "NOSCRIPT") {
+		params[0], params[1] = "EVAL", s.src
+		return p.do(params...)
+	}
+	return res, err
+}
+
+type redisScript struct {
+	src  string
+	hash string
+}
+
+func newRedisScript(src string) *redisScript {
+	sum := sha1.Sum([]byte(src))
+	return &redisScript{src: src, hash: hex.EncodeToString(sum[:])}
+}
+
+type redisConn struct {
+	net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+}
+
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	if err := c.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, a := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(a)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, a...)
+		buf = append(buf, '\r', '\n')
+	}
+	if _, err := c.Write(buf); err != nil {
+		return nil, err
+	}
+
+	res, err := c.readReply()
+	if err != nil {
+		return nil, err
+	}
+	if rerr, ok := res.(redisError); ok {
+		return nil, rerr
+	}
+	return res, nil
+}
+
+// readReply decodes a reply. The error replies are returned as redisError values, so the ones
+// nested in arrays do not leave the rest of the reply unread.
+func (c *redisConn) readReply() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: malformed reply")
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return redisError(body), nil
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		res := make([]interface{}, n)
+		for i := range res {
+			if res[i], err = c.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
@@ -0,0 +1,150 @@
+package gin
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	krakendjose "api-gateway/v2/modules/krakend-jose/v2"
+	"github.com/gin-gonic/gin"
+
+	"api-gateway/v2/modules/krakend-ratelimit/v3/router"
+)
+
+// The built-in strategies to identify the clients
+const (
+	StrategyIP        = "ip"
+	StrategyHeader    = "header"
+	StrategyClaim     = "claim"
+	StrategyParam     = "param"
+	StrategyQuery     = "query"
+	StrategyComposite = "composite"
+)
+
+// compositeSeparator joins the parts of the composite keys
+const compositeSeparator = "|"
+
+// TokenExtractorFactory returns the TokenExtractor of a strategy, configured with its key
+type TokenExtractorFactory func(key string) (TokenExtractor, error)
+
+var (
+	tokenExtractors = map[string]TokenExtractorFactory{
+		StrategyIP:     ipExtractorFactory,
+		StrategyHeader: requiredKey(StrategyHeader, HeaderTokenExtractor),
+		StrategyClaim:  requiredKey(StrategyClaim, ClaimTokenExtractor),
+		StrategyParam:  requiredKey(StrategyParam, ParamTokenExtractor),
+		StrategyQuery:  requiredKey(StrategyQuery, QueryTokenExtractor),
+	}
+	tokenExtractorsMu = new(sync.RWMutex)
+)
+
+// RegisterTokenExtractor adds the strategy to the registry, replacing the previous one with the
+// same name. The composite strategy can combine the registered strategies.
+func RegisterTokenExtractor(strategy string, f TokenExtractorFactory) {
+	tokenExtractorsMu.Lock()
+	tokenExtractors[strings.ToLower(strategy)] = f
+	tokenExtractorsMu.Unlock()
+}
+
+// NewTokenExtractor returns the TokenExtractor of the registered strategy
+func NewTokenExtractor(strategy, key string) (TokenExtractor, error) {
+	tokenExtractorsMu.RLock()
+	f, ok := tokenExtractors[strings.ToLower(strategy)]
+	tokenExtractorsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown strategy %s", strategy)
+	}
+	return f(key)
+}
+
+// NewTokenExtractorFromCfg returns the TokenExtractor of the strategy of the config
+func NewTokenExtractorFromCfg(cfg router.Config) (TokenExtractor, error) {
+	if strings.ToLower(cfg.Strategy) != StrategyComposite {
+		return NewTokenExtractor(cfg.Strategy, cfg.Key)
+	}
+	if len(cfg.Composite) == 0 {
+		return nil, fmt.Errorf("the %s strategy requires the list of the combined strategies", StrategyComposite)
+	}
+	parts := make([]TokenExtractor, len(cfg.Composite))
+	for i, k := range cfg.Composite {
+		te, err := NewTokenExtractor(k.Strategy, k.Key)
+		if err != nil {
+			return nil, err
+		}
+		parts[i] = te
+	}
+	return CompositeTokenExtractor(parts...), nil
+}
+
+// strategyName describes the strategy of the config, so the limiters using different keys do not
+// share their buckets
+func strategyName(cfg router.Config) string {
+	if strings.ToLower(cfg.Strategy) != StrategyComposite {
+		return strings.ToLower(cfg.Strategy) + ":" + cfg.Key
+	}
+	names := make([]string, len(cfg.Composite))
+	for i, k := range cfg.Composite {
+		names[i] = strings.ToLower(k.Strategy) + ":" + k.Key
+	}
+	return strings.Join(names, "+")
+}
+
+func ipExtractorFactory(key string) (TokenExtractor, error) {
+	if key == "" {
+		return IPTokenExtractor, nil
+	}
+	return NewIPTokenExtractor(key), nil
+}
+
+func requiredKey(strategy string, f func(string) TokenExtractor) TokenExtractorFactory {
+	return func(key string) (TokenExtractor, error) {
+		if key == "" {
+			return nil, fmt.Errorf("the %s strategy requires a key", strategy)
+		}
+		return f(key), nil
+	}
+}
+
+// ClaimTokenExtractor returns a TokenExtractor that looks for the value of the claim in the token
+// validated by the auth middlewares. The claims are read as krakend-jose propagates them to the
+// headers, so the names with dots are paths to nested claims.
+func ClaimTokenExtractor(claim string) TokenExtractor {
+	return func(c *gin.Context) string {
+		v, ok := c.Get(krakendjose.ClaimsContextKey)
+		if !ok {
+			return ""
+		}
+		claims, ok := v.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		value, _ := krakendjose.ClaimValue(claims, claim)
+		return value
+	}
+}
+
+// ParamTokenExtractor returns a TokenExtractor that looks for the value of the URL param. The
+// name can be written as in the endpoint pattern, with or without the braces.
+func ParamTokenExtractor(param string) TokenExtractor {
+	param = strings.TrimSuffix(strings.TrimPrefix(param, "{"), "}")
+	return func(c *gin.Context) string { return c.Param(param) }
+}
+
+// QueryTokenExtractor returns a TokenExtractor that looks for the value of the query string param
+func QueryTokenExtractor(name string) TokenExtractor {
+	return func(c *gin.Context) string { return c.Query(name) }
+}
+
+// CompositeTokenExtractor returns a TokenExtractor combining the keys of the received ones. The
+// key is empty if any of the parts is missing.
+func CompositeTokenExtractor(extractors ...TokenExtractor) TokenExtractor {
+	return func(c *gin.Context) string {
+		parts := make([]string, len(extractors))
+		for i, te := range extractors {
+			if parts[i] = te(c); parts[i] == "" {
+				return ""
+			}
+		}
+		return strings.Join(parts, compositeSeparator)
+	}
+}
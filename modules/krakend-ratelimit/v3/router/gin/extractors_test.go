@@ -0,0 +1,183 @@
+package gin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	krakendjose "api-gateway/v2/modules/krakend-jose/v2"
+	"github.com/gin-gonic/gin"
+
+	"api-gateway/v2/modules/krakend-ratelimit/v3/router"
+)
+
+func TestClaimTokenExtractor(t *testing.T) {
+	claims := map[string]interface{}{
+		"sub":                      "user-1",
+		"tenant":                   map[string]interface{}{"id": "acme"},
+		"org_id":                   float64(42),
+		"roles":                    []interface{}{"a", "b"},
+		"https://example.com/plan": "gold",
+	}
+	for claim, want := range map[string]string{
+		"sub":                      "user-1",
+		"tenant.id":                "acme",
+		"org_id":                   "42",
+		"roles":                    "a,b",
+		"https://example.com/plan": "gold",
+		"missing":                  "",
+		"tenant.missing":           "",
+	} {
+		c := newTestContext("/", nil)
+		c.Set(krakendjose.ClaimsContextKey, claims)
+		if v := ClaimTokenExtractor(claim)(c); v != want {
+			t.Errorf("unexpected value of the claim %s. have: %q, want: %q", claim, v, want)
+		}
+	}
+
+	if v := ClaimTokenExtractor("sub")(newTestContext("/", nil)); v != "" {
+		t.Errorf("unexpected value without claims: %q", v)
+	}
+	c := newTestContext("/", nil)
+	c.Set(krakendjose.ClaimsContextKey, "not the claims")
+	if v := ClaimTokenExtractor("sub")(c); v != "" {
+		t.Errorf("unexpected value with invalid claims: %q", v)
+	}
+}
+
+func TestNewTokenExtractorFromCfg_composite(t *testing.T) {
+	te, err := NewTokenExtractorFromCfg(router.Config{
+		Strategy: "Composite",
+		Composite: []router.KeyConfig{
+			{Strategy: StrategyClaim, Key: "sub"},
+			{Strategy: StrategyHeader, Key: "X-Device"},
+			{Strategy: StrategyParam, Key: "{id}"},
+			{Strategy: StrategyQuery, Key: "region"},
+		},
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	for _, tc := range []struct {
+		name    string
+		url     string
+		headers map[string]string
+		claims  map[string]interface{}
+		want    string
+	}{
+		{
+			name:    "all the parts",
+			url:     "/items/7?region=eu",
+			headers: map[string]string{"X-Device": "phone"},
+			claims:  map[string]interface{}{"sub": "user-1"},
+			want:    "user-1|phone|7|eu",
+		},
+		{
+			name:    "missing claim",
+			url:     "/items/7?region=eu",
+			headers: map[string]string{"X-Device": "phone"},
+			want:    "",
+		},
+		{
+			name:   "missing header",
+			url:    "/items/7?region=eu",
+			claims: map[string]interface{}{"sub": "user-1"},
+			want:   "",
+		},
+		{
+			name:    "missing query",
+			url:     "/items/7",
+			headers: map[string]string{"X-Device": "phone"},
+			claims:  map[string]interface{}{"sub": "user-1"},
+			want:    "",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var key string
+			gin.SetMode(gin.TestMode)
+			engine := gin.New()
+			engine.GET("/items/:id", func(c *gin.Context) {
+				if tc.claims != nil {
+					c.Set(krakendjose.ClaimsContextKey, tc.claims)
+				}
+				key = te(c)
+			})
+			req, _ := http.NewRequest(http.MethodGet, tc.url, http.NoBody)
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			engine.ServeHTTP(httptest.NewRecorder(), req)
+			if key != tc.want {
+				t.Errorf("unexpected key. have: %q, want: %q", key, tc.want)
+			}
+		})
+	}
+}
+
+func TestNewTokenExtractorFromCfg_errors(t *testing.T) {
+	for name, cfg := range map[string]router.Config{
+		"unknown strategy":     {Strategy: "cookie", Key: "session"},
+		"missing key":          {Strategy: StrategyHeader},
+		"empty composite":      {Strategy: StrategyComposite},
+		"invalid composite":    {Strategy: StrategyComposite, Composite: []router.KeyConfig{{Strategy: StrategyIP}, {Strategy: StrategyClaim}}},
+		"unknown in composite": {Strategy: StrategyComposite, Composite: []router.KeyConfig{{Strategy: "cookie", Key: "session"}}},
+	} {
+		if _, err := NewTokenExtractorFromCfg(cfg); err == nil {
+			t.Errorf("%s: error expected", name)
+		}
+	}
+}
+
+func TestRegisterTokenExtractor(t *testing.T) {
+	RegisterTokenExtractor("Test-Constant", func(key string) (TokenExtractor, error) {
+		return func(*gin.Context) string { return "constant:" + key }, nil
+	})
+
+	te, err := NewTokenExtractorFromCfg(router.Config{
+		Strategy:  StrategyComposite,
+		Composite: []router.KeyConfig{{Strategy: "test-constant", Key: "a"}, {Strategy: StrategyIP}},
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	c := newTestContext("/", nil)
+	c.Request.RemoteAddr = "10.0.0.1:1234"
+	if v := te(c); v != "constant:a|10.0.0.1" {
+		t.Errorf("unexpected key: %q", v)
+	}
+}
+
+func TestStrategyName(t *testing.T) {
+	for _, tc := range []struct {
+		cfg  router.Config
+		want string
+	}{
+		{cfg: router.Config{Strategy: "IP"}, want: "ip:"},
+		{cfg: router.Config{Strategy: StrategyHeader, Key: "X-Key"}, want: "header:X-Key"},
+		{
+			cfg: router.Config{Strategy: StrategyComposite, Composite: []router.KeyConfig{
+				{Strategy: StrategyClaim, Key: "sub"},
+				{Strategy: "Header", Key: "X-Device"},
+			}},
+			want: "claim:sub+header:X-Device",
+		},
+	} {
+		if name := strategyName(tc.cfg); name != tc.want {
+			t.Errorf("unexpected name. have: %q, want: %q", name, tc.want)
+		}
+	}
+}
+
+// newTestContext returns a gin context of a GET request to the url with the received headers
+func newTestContext(url string, headers map[string]string) *gin.Context {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request, _ = http.NewRequest(http.MethodGet, url, http.NoBody)
+	for k, v := range headers {
+		c.Request.Header.Set(k, v)
+	}
+	return c
+}
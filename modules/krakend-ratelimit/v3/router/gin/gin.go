@@ -4,8 +4,9 @@ import (
 	"context"
 	"fmt"
 	"net"
-	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"api-gateway/v2/modules/lura/v2/config"
 	"api-gateway/v2/modules/lura/v2/logging"
@@ -23,6 +24,12 @@ var HandlerFactory = NewRateLimiterMw(logging.NoOp, krakendgin.EndpointHandler)
 
 // NewRateLimiterMw builds a rate limiting wrapper over the received handler factory.
 func NewRateLimiterMw(logger logging.Logger, next krakendgin.HandlerFactory) krakendgin.HandlerFactory {
+	return NewRateLimiterMwWithContext(context.Background(), logger, next)
+}
+
+// NewRateLimiterMwWithContext builds a rate limiting wrapper over the received handler factory. The
+// memory backends and the quota snapshots are released once the context is cancelled.
+func NewRateLimiterMwWithContext(ctx context.Context, logger logging.Logger, next krakendgin.HandlerFactory) krakendgin.HandlerFactory {
 	return func(remote *config.EndpointConfig, p proxy.Proxy) gin.HandlerFunc {
 		logPrefix := "[ENDPOINT: " + remote.Endpoint + "][Ratelimit]"
 		handlerFunc := next(remote, p)
@@ -35,10 +42,45 @@ func NewRateLimiterMw(logger logging.Logger, next krakendgin.HandlerFactory) kra
 			return handlerFunc
 		}
 
-		if cfg.MaxRate <= 0 && cfg.ClientMaxRate <= 0 {
+		if cfg.MaxRate <= 0 && cfg.ClientMaxRate <= 0 && len(cfg.Tiers) == 0 && cfg.Quota == nil {
 			return handlerFunc
 		}
 
+		// the ttl of the memory buckets is extended for the limiters of longer periods
+		newBackend := func(_ string, ttl time.Duration) krakendrate.Backend {
+			return newMemoryBackendWithTTL(ctx, max(cfg.TTL, ttl))
+		}
+		onError := krakendrate.ThrottledErrorHandler(10*time.Second, func(err error) {
+			logger.Error(logPrefix, "Rate limit store failure:", err.Error())
+		})
+		if cfg.Redis != nil {
+			logger.Debug(logPrefix, "Distributed rate limit enabled. Failure policy:", cfg.Redis.FailurePolicy)
+			newBackend = func(scope string, _ time.Duration) krakendrate.Backend {
+				return krakendrate.NewRedisBackend(*cfg.Redis, "router:"+remote.Method+" "+remote.Endpoint+":"+scope, onError)
+			}
+		}
+		newQuota := func(scope string, quotaCfg krakendrate.QuotaConfig) (*krakendrate.Quota, error) {
+			backend, err := krakendrate.NewQuotaBackend(ctx, quotaCfg, cfg.Redis)
+			if err != nil {
+				return nil, err
+			}
+			return krakendrate.NewQuota(quotaCfg, "router:"+remote.Method+" "+remote.Endpoint+":"+scope, backend, onError), nil
+		}
+
+		// the quota is checked after the rate limits, so the throttled requests are not counted.
+		// An invalid quota is skipped, but the rest of the limits are still applied.
+		if cfg.Quota != nil {
+			strategy := strategyName(cfg)
+			if tokenExtractor, err := NewTokenExtractorFromCfg(cfg); err != nil {
+				logger.Warning(logPrefix, "Quota:", err.Error())
+			} else if quota, err := newQuota(strategy, *cfg.Quota); err != nil {
+				logger.Error(logPrefix, "Quota:", err.Error())
+			} else {
+				logger.Debug(logPrefix, fmt.Sprintf("Quota enabled with the strategy %s. MaxRequests: %d, Period: %s, Window: %s", strategy, cfg.Quota.MaxRequests, cfg.Quota.Period, cfg.Quota.Window))
+				handlerFunc = NewQuotaMw(tokenExtractor, quota, statusCode(cfg))(handlerFunc)
+			}
+		}
+
 		if cfg.MaxRate > 0 {
 			if cfg.Capacity == 0 {
 				if cfg.MaxRate < 1 {
@@ -48,7 +90,39 @@ func NewRateLimiterMw(logger logging.Logger, next krakendgin.HandlerFactory) kra
 				}
 			}
 			logger.Debug(logPrefix, fmt.Sprintf("Rate limit enabled. MaxRate: %f, Capacity: %d", cfg.MaxRate, cfg.Capacity))
-			handlerFunc = NewEndpointRateLimiterMw(krakendrate.NewTokenBucket(cfg.MaxRate, cfg.Capacity))(handlerFunc)
+			var limiter krakendrate.Limiter = krakendrate.NewTokenBucket(cfg.MaxRate, cfg.Capacity)
+			if cfg.Redis != nil {
+				limiter = krakendrate.NewLimiterStore(cfg.MaxRate, int(cfg.Capacity), newBackend("endpoint", 0))("")
+			}
+			handlerFunc = NewEndpointRateLimiterMwWithStatus(limiter, statusCode(cfg))(handlerFunc)
+		}
+
+		if len(cfg.Tiers) > 0 {
+			tokenExtractor, err := NewTokenExtractorFromCfg(cfg)
+			if err != nil {
+				logger.Warning(logPrefix, err.Error())
+				return handlerFunc
+			}
+			selector, err := NewTierSelector(*cfg.TierSelector)
+			if err != nil {
+				logger.Warning(logPrefix, "Tier selector:", err.Error())
+				return handlerFunc
+			}
+			if cfg.ClientMaxRate > 0 {
+				logger.Warning(logPrefix, "The client_max_rate is ignored, as the tiers define the client limits")
+			}
+			strategy := strategyName(cfg)
+			logger.Debug(logPrefix, fmt.Sprintf("Tiered rate limit enabled with the strategy %s. Tiers: %d, default tier: %q", strategy, len(cfg.Tiers), cfg.DefaultTier))
+			tiers, err := newTiers(cfg, func(scope string, ttl time.Duration) krakendrate.Backend {
+				return newBackend(strategy+":"+scope, ttl)
+			}, func(scope string, quotaCfg krakendrate.QuotaConfig) (*krakendrate.Quota, error) {
+				return newQuota(strategy+":"+scope, quotaCfg)
+			})
+			if err != nil {
+				logger.Error(logPrefix, "Tier quota:", err.Error())
+			}
+			registerView(logger, logPrefix)
+			return NewTieredLimiterMw(selector, tokenExtractor, tiers, cfg.DefaultTier, statusCode(cfg), remote.Endpoint)(handlerFunc)
 		}
 
 		if cfg.ClientMaxRate > 0 {
@@ -59,16 +133,15 @@ func NewRateLimiterMw(logger logging.Logger, next krakendgin.HandlerFactory) kra
 					cfg.ClientCapacity = uint64(cfg.ClientMaxRate)
 				}
 			}
-			switch strategy := strings.ToLower(cfg.Strategy); strategy {
-			case "ip":
-				logger.Debug(logPrefix, fmt.Sprintf("IP-based rate limit enabled. MaxRate: %f, Capacity: %d", cfg.ClientMaxRate, cfg.ClientCapacity))
-				handlerFunc = NewIpLimiterWithKeyMwFromCfg(cfg)(handlerFunc)
-			case "header":
-				logger.Debug(logPrefix, fmt.Sprintf("Header-based rate limit enabled. MaxRate: %f, Capacity: %d", cfg.ClientMaxRate, cfg.ClientCapacity))
-				handlerFunc = NewHeaderLimiterMwFromCfg(cfg)(handlerFunc)
-			default:
-				logger.Warning(logPrefix, "Unknown strategy", strategy)
+			tokenExtractor, err := NewTokenExtractorFromCfg(cfg)
+			if err != nil {
+				logger.Warning(logPrefix, err.Error())
+				return handlerFunc
 			}
+			strategy := strategyName(cfg)
+			logger.Debug(logPrefix, fmt.Sprintf("Client rate limit enabled with the strategy %s. MaxRate: %f, Capacity: %d", strategy, cfg.ClientMaxRate, cfg.ClientCapacity))
+			store := krakendrate.NewLimiterStore(cfg.ClientMaxRate, int(cfg.ClientCapacity), newBackend(strategy, 0))
+			handlerFunc = NewTokenLimiterMwWithStatus(tokenExtractor, store, statusCode(cfg))(handlerFunc)
 		}
 
 		return handlerFunc
@@ -79,11 +152,19 @@ func NewRateLimiterMw(logger logging.Logger, next krakendgin.HandlerFactory) kra
 type EndpointMw func(gin.HandlerFunc) gin.HandlerFunc
 
 // NewEndpointRateLimiterMw creates a simple ratelimiter for a given handlerFunc
-func NewEndpointRateLimiterMw(tb *krakendrate.TokenBucket) EndpointMw {
+func NewEndpointRateLimiterMw(tb krakendrate.Limiter) EndpointMw {
+	return NewEndpointRateLimiterMwWithStatus(tb, router.DefaultStatusCode)
+}
+
+// NewEndpointRateLimiterMwWithStatus creates a simple ratelimiter for a given handlerFunc, rejecting
+// the requests with the received status code
+func NewEndpointRateLimiterMwWithStatus(tb krakendrate.Limiter, status int) EndpointMw {
 	return func(next gin.HandlerFunc) gin.HandlerFunc {
 		return func(c *gin.Context) {
-			if !tb.Allow() {
-				c.AbortWithError(503, krakendrate.ErrLimited)
+			d := tb.Take()
+			setRateLimitHeaders(c, d)
+			if !d.Allowed {
+				c.AbortWithError(status, krakendrate.ErrLimited)
 				return
 			}
 			next(c)
@@ -100,17 +181,14 @@ func NewHeaderLimiterMw(header string, maxRate float64, capacity uint64) Endpoin
 
 // NewHeaderLimiterMwFromCfg creates a token ratelimiter using the value of a header as a token
 func NewHeaderLimiterMwFromCfg(cfg router.Config) EndpointMw {
-	store := krakendrate.NewLimiterStore(
-		cfg.ClientMaxRate,
-		int(cfg.ClientCapacity),
-		krakendrate.NewShardedMemoryBackend(
-			context.Background(),
-			krakendrate.DefaultShards,
-			cfg.TTL,
-			krakendrate.PseudoFNV64a,
-		),
-	)
-	return NewTokenLimiterMw(HeaderTokenExtractor(cfg.Key), store)
+	return NewHeaderLimiterMwWithBackend(cfg, newMemoryBackend(cfg))
+}
+
+// NewHeaderLimiterMwWithBackend creates a token ratelimiter using the value of a header as a token
+// and keeping the buckets in the received backend
+func NewHeaderLimiterMwWithBackend(cfg router.Config, backend krakendrate.Backend) EndpointMw {
+	store := krakendrate.NewLimiterStore(cfg.ClientMaxRate, int(cfg.ClientCapacity), backend)
+	return NewTokenLimiterMwWithStatus(HeaderTokenExtractor(cfg.Key), store, statusCode(cfg))
 }
 
 // NewIpLimiterMw creates a token ratelimiter using the IP of the request as a token
@@ -130,20 +208,30 @@ func NewIpLimiterWithKeyMw(header string, maxRate float64, capacity uint64) Endp
 
 // NewIpLimiterWithKeyMwFromCfg creates a token ratelimiter using the IP of the request as a token
 func NewIpLimiterWithKeyMwFromCfg(cfg router.Config) EndpointMw {
-	store := krakendrate.NewLimiterStore(
-		cfg.ClientMaxRate,
-		int(cfg.ClientCapacity),
-		krakendrate.NewShardedMemoryBackend(
-			context.Background(),
-			krakendrate.DefaultShards,
-			cfg.TTL,
-			krakendrate.PseudoFNV64a,
-		),
-	)
+	return NewIpLimiterWithKeyMwWithBackend(cfg, newMemoryBackend(cfg))
+}
+
+// NewIpLimiterWithKeyMwWithBackend creates a token ratelimiter using the IP of the request as a
+// token and keeping the buckets in the received backend
+func NewIpLimiterWithKeyMwWithBackend(cfg router.Config, backend krakendrate.Backend) EndpointMw {
+	store := krakendrate.NewLimiterStore(cfg.ClientMaxRate, int(cfg.ClientCapacity), backend)
 	if cfg.Key == "" {
-		return NewTokenLimiterMw(IPTokenExtractor, store)
+		return NewTokenLimiterMwWithStatus(IPTokenExtractor, store, statusCode(cfg))
 	}
-	return NewTokenLimiterMw(NewIPTokenExtractor(cfg.Key), store)
+	return NewTokenLimiterMwWithStatus(NewIPTokenExtractor(cfg.Key), store, statusCode(cfg))
+}
+
+func newMemoryBackend(cfg router.Config) krakendrate.Backend {
+	return newMemoryBackendWithTTL(context.Background(), cfg.TTL)
+}
+
+func newMemoryBackendWithTTL(ctx context.Context, ttl time.Duration) krakendrate.Backend {
+	return krakendrate.NewShardedMemoryBackend(
+		ctx,
+		krakendrate.DefaultShards,
+		ttl,
+		krakendrate.PseudoFNV64a,
+	)
 }
 
 // TokenExtractor defines the interface of the functions to use in order to extract a token for each request
@@ -173,18 +261,69 @@ func HeaderTokenExtractor(header string) TokenExtractor {
 
 // NewTokenLimiterMw returns a token based ratelimiting endpoint middleware with the received TokenExtractor and LimiterStore
 func NewTokenLimiterMw(tokenExtractor TokenExtractor, limiterStore krakendrate.LimiterStore) EndpointMw {
+	return NewTokenLimiterMwWithStatus(tokenExtractor, limiterStore, router.DefaultStatusCode)
+}
+
+// NewTokenLimiterMwWithStatus returns a token based ratelimiting endpoint middleware with the received
+// TokenExtractor and LimiterStore, rejecting the requests with the received status code
+func NewTokenLimiterMwWithStatus(tokenExtractor TokenExtractor, limiterStore krakendrate.LimiterStore, status int) EndpointMw {
 	return func(next gin.HandlerFunc) gin.HandlerFunc {
 		return func(c *gin.Context) {
 			tokenKey := tokenExtractor(c)
 			if tokenKey == "" {
-				c.AbortWithError(http.StatusTooManyRequests, krakendrate.ErrLimited)
+				c.AbortWithError(status, krakendrate.ErrLimited)
 				return
 			}
-			if !limiterStore(tokenKey).Allow() {
-				c.AbortWithError(http.StatusTooManyRequests, krakendrate.ErrLimited)
+			d := limiterStore(tokenKey).Take()
+			setRateLimitHeaders(c, d)
+			if !d.Allowed {
+				c.AbortWithError(status, krakendrate.ErrLimited)
 				return
 			}
 			next(c)
 		}
 	}
 }
+
+// The headers describing the state of the limiter, as defined by the IETF draft
+// "RateLimit header fields for HTTP"
+const (
+	HeaderRateLimitLimit     = "RateLimit-Limit"
+	HeaderRateLimitRemaining = "RateLimit-Remaining"
+	HeaderRateLimitReset     = "RateLimit-Reset"
+	HeaderRetryAfter         = "Retry-After"
+)
+
+// setRateLimitHeaders adds the state of the limiter to the response. When several limiters apply
+// to the request, the headers describe the most restrictive one.
+func setRateLimitHeaders(c *gin.Context, d krakendrate.Decision) {
+	if d.Limit == 0 {
+		return
+	}
+	h := c.Writer.Header()
+	if current, err := strconv.ParseUint(h.Get(HeaderRateLimitRemaining), 10, 64); err == nil && current < d.Remaining {
+		return
+	}
+	h.Set(HeaderRateLimitLimit, strconv.FormatUint(d.Limit, 10))
+	h.Set(HeaderRateLimitRemaining, strconv.FormatUint(d.Remaining, 10))
+	h.Set(HeaderRateLimitReset, strconv.FormatInt(seconds(d.Reset), 10))
+	if !d.Allowed {
+		retry := seconds(d.RetryAfter)
+		if retry < 1 {
+			retry = 1
+		}
+		h.Set(HeaderRetryAfter, strconv.FormatInt(retry, 10))
+	}
+}
+
+// seconds rounds the duration up to whole seconds, so the clients do not retry too early
+func seconds(d time.Duration) int64 {
+	return int64((d + time.Second - 1) / time.Second)
+}
+
+func statusCode(cfg router.Config) int {
+	if cfg.StatusCode == 0 {
+		return router.DefaultStatusCode
+	}
+	return cfg.StatusCode
+}
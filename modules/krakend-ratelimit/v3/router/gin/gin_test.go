@@ -0,0 +1,186 @@
+package gin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"api-gateway/v2/modules/lura/v2/config"
+	"api-gateway/v2/modules/lura/v2/logging"
+	"api-gateway/v2/modules/lura/v2/proxy"
+	"github.com/gin-gonic/gin"
+
+	krakendrate "api-gateway/v2/modules/krakend-ratelimit/v3"
+	"api-gateway/v2/modules/krakend-ratelimit/v3/router"
+)
+
+// fixedDecision is a Limiter always returning the same decision
+type fixedDecision krakendrate.Decision
+
+func (l fixedDecision) Allow() bool { return l.Allowed }
+
+func (l fixedDecision) Take() krakendrate.Decision { return krakendrate.Decision(l) }
+
+func TestSetRateLimitHeaders_mostRestrictive(t *testing.T) {
+	loose := krakendrate.Decision{Allowed: true, Limit: 100, Remaining: 80, Reset: 10 * time.Second}
+	strict := krakendrate.Decision{Allowed: true, Limit: 10, Remaining: 2, Reset: 1500 * time.Millisecond}
+
+	for _, tc := range []struct {
+		name      string
+		decisions []krakendrate.Decision
+	}{
+		{name: "strict first", decisions: []krakendrate.Decision{strict, loose}},
+		{name: "strict last", decisions: []krakendrate.Decision{loose, strict}},
+		{name: "unreported in between", decisions: []krakendrate.Decision{loose, {Allowed: true}, strict}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := serve(t, func(c *gin.Context) {
+				for _, d := range tc.decisions {
+					setRateLimitHeaders(c, d)
+				}
+			})
+			assertHeaders(t, h, map[string]string{
+				HeaderRateLimitLimit:     "10",
+				HeaderRateLimitRemaining: "2",
+				HeaderRateLimitReset:     "2",
+				HeaderRetryAfter:         "",
+			})
+		})
+	}
+}
+
+func TestSetRateLimitHeaders_retryAfter(t *testing.T) {
+	for _, tc := range []struct {
+		retry time.Duration
+		want  string
+	}{
+		{retry: 0, want: "1"},
+		{retry: time.Nanosecond, want: "1"},
+		{retry: time.Second, want: "1"},
+		{retry: time.Second + time.Millisecond, want: "2"},
+		{retry: 59 * time.Second, want: "59"},
+	} {
+		h := serve(t, func(c *gin.Context) {
+			setRateLimitHeaders(c, krakendrate.Decision{Limit: 1, RetryAfter: tc.retry, Reset: tc.retry})
+		})
+		if v := h.Get(HeaderRetryAfter); v != tc.want {
+			t.Errorf("unexpected Retry-After for %s. have: %q, want: %q", tc.retry, v, tc.want)
+		}
+	}
+}
+
+func TestSetRateLimitHeaders_unreported(t *testing.T) {
+	h := serve(t, func(c *gin.Context) {
+		setRateLimitHeaders(c, krakendrate.Decision{Allowed: false})
+	})
+	if len(h) != 0 {
+		t.Errorf("unexpected headers: %v", h)
+	}
+}
+
+func TestNewEndpointRateLimiterMwWithStatus(t *testing.T) {
+	endpoint := fixedDecision{Allowed: true, Limit: 10, Remaining: 9}
+	client := fixedDecision{Allowed: false, Limit: 2, Remaining: 0, RetryAfter: 300 * time.Millisecond, Reset: 2 * time.Second}
+
+	handler := NewEndpointRateLimiterMwWithStatus(endpoint, http.StatusTooManyRequests)(
+		NewTokenLimiterMwWithStatus(HeaderTokenExtractor("X-Client"), func(string) krakendrate.Limiter { return client }, http.StatusServiceUnavailable)(
+			func(c *gin.Context) { t.Error("the limited request reached the handler") },
+		),
+	)
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/", handler)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/", http.NoBody)
+	req.Header.Set("X-Client", "a")
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("unexpected status code: %d", w.Code)
+	}
+	assertHeaders(t, w.Header(), map[string]string{
+		HeaderRateLimitLimit:     "2",
+		HeaderRateLimitRemaining: "0",
+		HeaderRateLimitReset:     "2",
+		HeaderRetryAfter:         "1",
+	})
+}
+
+func TestNewRateLimiterMwWithContext_invalidQuota(t *testing.T) {
+	// a corrupted snapshot can not be restored, so the quota backend fails
+	snapshot := filepath.Join(t.TempDir(), "quotas.json")
+	if err := os.WriteFile(snapshot, []byte("not a snapshot"), 0o600); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hf := NewRateLimiterMwWithContext(ctx, logging.NoOp, func(*config.EndpointConfig, proxy.Proxy) gin.HandlerFunc {
+		return func(c *gin.Context) { c.Status(http.StatusOK) }
+	})
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/", hf(&config.EndpointConfig{
+		Endpoint: "/",
+		Method:   http.MethodGet,
+		ExtraConfig: config.ExtraConfig{router.Namespace: map[string]interface{}{
+			"max_rate": 1,
+			"strategy": "ip",
+			"quota": map[string]interface{}{
+				"max_requests":  100,
+				"period":        "day",
+				"snapshot_file": snapshot,
+			},
+		}},
+	}, proxy.NoopProxy))
+
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.RemoteAddr = "10.0.0.1:1234"
+		engine.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("unexpected status code of the request #%d. have: %d, want: %d", i, w.Code, want)
+		}
+	}
+}
+
+func TestSeconds(t *testing.T) {
+	for d, want := range map[time.Duration]int64{
+		0:                                0,
+		time.Millisecond:                 1,
+		time.Second:                      1,
+		time.Second + time.Nanosecond:    2,
+		90 * time.Minute:                 5400,
+		90*time.Minute - time.Nanosecond: 5400,
+	} {
+		if s := seconds(d); s != want {
+			t.Errorf("unexpected seconds for %s. have: %d, want: %d", d, s, want)
+		}
+	}
+}
+
+// serve runs the handler in a gin context and returns the headers of the response
+func serve(t *testing.T, handler gin.HandlerFunc) http.Header {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(http.MethodGet, "/", http.NoBody)
+	handler(c)
+	return w.Header()
+}
+
+func assertHeaders(t *testing.T, h http.Header, want map[string]string) {
+	t.Helper()
+	for k, v := range want {
+		if h.Get(k) != v {
+			t.Errorf("unexpected %s header. have: %q, want: %q", k, h.Get(k), v)
+		}
+	}
+}
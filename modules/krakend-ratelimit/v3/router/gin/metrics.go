@@ -0,0 +1,59 @@
+package gin
+
+import (
+	"sync"
+
+	"api-gateway/v2/modules/lura/v2/logging"
+	"github.com/gin-gonic/gin"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+// The outcomes of the rate limit decisions
+const (
+	OutcomeAllowed  = "allowed"
+	OutcomeRejected = "rejected"
+)
+
+var (
+	// RateLimitDecisions counts the decisions taken by the tiered limiters
+	RateLimitDecisions = stats.Int64("krakend.io/ratelimit/decisions", "Number of rate limit decisions", stats.UnitDimensionless)
+
+	KeyRateLimitEndpoint = tag.MustNewKey("ratelimit_endpoint")
+	KeyRateLimitTier     = tag.MustNewKey("ratelimit_tier")
+	KeyRateLimitOutcome  = tag.MustNewKey("ratelimit_outcome")
+
+	// RateLimitDecisionsView exports the number of decisions by endpoint, tier and outcome
+	RateLimitDecisionsView = &view.View{
+		Name:        "krakend.io/ratelimit/decisions",
+		Description: "Number of rate limit decisions by endpoint, tier and outcome",
+		Measure:     RateLimitDecisions,
+		TagKeys:     []tag.Key{KeyRateLimitEndpoint, KeyRateLimitTier, KeyRateLimitOutcome},
+		Aggregation: view.Count(),
+	}
+
+	registerViewOnce = new(sync.Once)
+)
+
+func registerView(l logging.Logger, logPrefix string) {
+	registerViewOnce.Do(func() {
+		if err := view.Register(RateLimitDecisionsView); err != nil {
+			l.Warning(logPrefix, "Registering the opencensus view:", err.Error())
+		}
+	})
+}
+
+// recordDecision counts the decision. The requests without a known tier are recorded with an
+// empty tier.
+func recordDecision(c *gin.Context, endpoint, tier string, allowed bool) {
+	outcome := OutcomeAllowed
+	if !allowed {
+		outcome = OutcomeRejected
+	}
+	stats.RecordWithTags(c.Request.Context(), []tag.Mutator{
+		tag.Upsert(KeyRateLimitEndpoint, endpoint),
+		tag.Upsert(KeyRateLimitTier, tier),
+		tag.Upsert(KeyRateLimitOutcome, outcome),
+	}, RateLimitDecisions.M(1))
+}
@@ -0,0 +1,55 @@
+package gin
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	krakendrate "api-gateway/v2/modules/krakend-ratelimit/v3"
+)
+
+// The headers describing the state of the quota of the client
+const (
+	HeaderQuotaLimit     = "X-Quota-Limit"
+	HeaderQuotaRemaining = "X-Quota-Remaining"
+	HeaderQuotaReset     = "X-Quota-Reset"
+)
+
+// NewQuotaMw returns an endpoint middleware counting the requests of every client in the quota,
+// rejecting them with the received status code once the quota of the period is consumed
+func NewQuotaMw(tokenExtractor TokenExtractor, quota *krakendrate.Quota, status int) EndpointMw {
+	return func(next gin.HandlerFunc) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			tokenKey := tokenExtractor(c)
+			if tokenKey == "" {
+				c.AbortWithError(status, krakendrate.ErrQuotaExceeded)
+				return
+			}
+			d := quota.Take(tokenKey)
+			setQuotaHeaders(c, d)
+			if !d.Allowed {
+				c.AbortWithError(status, krakendrate.ErrQuotaExceeded)
+				return
+			}
+			next(c)
+		}
+	}
+}
+
+// setQuotaHeaders adds the state of the quota to the response. The reset is the number of
+// seconds until the end of the current period.
+func setQuotaHeaders(c *gin.Context, d krakendrate.Decision) {
+	if d.Limit == 0 {
+		return
+	}
+	h := c.Writer.Header()
+	h.Set(HeaderQuotaLimit, strconv.FormatUint(d.Limit, 10))
+	h.Set(HeaderQuotaRemaining, strconv.FormatUint(d.Remaining, 10))
+	h.Set(HeaderQuotaReset, strconv.FormatInt(seconds(d.Reset), 10))
+	if !d.Allowed {
+		h.Set(HeaderRetryAfter, strconv.FormatInt(max(seconds(d.RetryAfter), 1), 10))
+	}
+}
+
+// quotaFactory returns the quota of the config, counting the requests under the scope
+type quotaFactory func(scope string, cfg krakendrate.QuotaConfig) (*krakendrate.Quota, error)
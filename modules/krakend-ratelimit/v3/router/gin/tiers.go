@@ -0,0 +1,123 @@
+package gin
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	krakendrate "api-gateway/v2/modules/krakend-ratelimit/v3"
+	"api-gateway/v2/modules/krakend-ratelimit/v3/router"
+)
+
+// TierContextKey is the key of the name of the tier applied to the request in the gin context
+const TierContextKey = "ratelimit.tier"
+
+// ErrUnknownTier is the error returned when the tier of the request is unknown and there is no
+// default tier
+var ErrUnknownTier = errors.New("unknown rate limit tier")
+
+// TierSelector returns the name of the tier of the request
+type TierSelector func(*gin.Context) string
+
+// NewTierSelector returns the TierSelector of the config. It uses the strategies of the key
+// extractor registry, and the lookup table of the config if there is one.
+func NewTierSelector(cfg router.TierSelectorConfig) (TierSelector, error) {
+	te, err := NewTokenExtractor(cfg.Strategy, cfg.Key)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Lookup == nil {
+		return TierSelector(te), nil
+	}
+	return func(c *gin.Context) string {
+		return cfg.Lookup[te(c)]
+	}, nil
+}
+
+// Tier is the set of limiters applied to the clients of a plan
+type Tier struct {
+	Name    string
+	Limiter krakendrate.LimiterStore
+	// Quota is the optional limit of the requests in a longer period
+	Quota *krakendrate.Quota
+}
+
+// NewTieredLimiterMw returns a ratelimiting endpoint middleware applying the limiters of the tier
+// of every request to its client. The requests of unknown tiers get the default tier, if any, or
+// are rejected with the configured status. The decisions are exported once the view is registered
+// by the HandlerFactory.
+func NewTieredLimiterMw(selector TierSelector, tokenExtractor TokenExtractor, tiers map[string]Tier, defaultTier string, status int, endpoint string) EndpointMw {
+	return func(next gin.HandlerFunc) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			name := selector(c)
+			tier, ok := tiers[name]
+			if !ok {
+				if defaultTier == "" {
+					recordDecision(c, endpoint, "", false)
+					c.AbortWithError(status, ErrUnknownTier)
+					return
+				}
+				tier = tiers[defaultTier]
+			}
+			c.Set(TierContextKey, tier.Name)
+
+			tokenKey := tokenExtractor(c)
+			if tokenKey == "" {
+				recordDecision(c, endpoint, tier.Name, false)
+				c.AbortWithError(status, krakendrate.ErrLimited)
+				return
+			}
+
+			d := tier.Limiter(tokenKey).Take()
+			setRateLimitHeaders(c, d)
+			if !d.Allowed {
+				recordDecision(c, endpoint, tier.Name, false)
+				c.AbortWithError(status, krakendrate.ErrLimited)
+				return
+			}
+			if tier.Quota != nil {
+				d = tier.Quota.Take(tokenKey)
+				setQuotaHeaders(c, d)
+				if !d.Allowed {
+					recordDecision(c, endpoint, tier.Name, false)
+					c.AbortWithError(status, krakendrate.ErrQuotaExceeded)
+					return
+				}
+			}
+			recordDecision(c, endpoint, tier.Name, true)
+			next(c)
+		}
+	}
+}
+
+// newTiers builds the limiters of the tiers of the config, keeping the buckets and the quotas in
+// the backends returned by the received functions. The tiers with an invalid quota keep their
+// rate limit, and the errors of their quotas are returned along with the tiers.
+func newTiers(cfg router.Config, newBackend func(scope string, ttl time.Duration) krakendrate.Backend, newQuota quotaFactory) (map[string]Tier, error) {
+	tiers := make(map[string]Tier, len(cfg.Tiers))
+	var errs []error
+	for name, t := range cfg.Tiers {
+		capacity := t.Capacity
+		if capacity == 0 {
+			capacity = 1
+			if t.MaxRate > 1 {
+				capacity = uint64(t.MaxRate)
+			}
+		}
+		tier := Tier{
+			Name:    name,
+			Limiter: krakendrate.NewLimiterStore(t.MaxRate, int(capacity), newBackend("tier:"+name, 0)),
+		}
+		if t.Quota != nil {
+			quota, err := newQuota("tier:"+name, *t.Quota)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("tier %s: %w", name, err))
+			}
+			tier.Quota = quota
+		}
+		tiers[name] = tier
+	}
+	return tiers, errors.Join(errs...)
+}
@@ -0,0 +1,183 @@
+package gin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	krakendrate "api-gateway/v2/modules/krakend-ratelimit/v3"
+	"api-gateway/v2/modules/krakend-ratelimit/v3/router"
+)
+
+func TestNewTierSelector(t *testing.T) {
+	selector, err := NewTierSelector(router.TierSelectorConfig{Strategy: StrategyHeader, Key: "X-Plan"})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if tier := selector(newTestContext("/", map[string]string{"X-Plan": "gold"})); tier != "gold" {
+		t.Errorf("unexpected tier: %q", tier)
+	}
+
+	selector, err = NewTierSelector(router.TierSelectorConfig{
+		Strategy: StrategyHeader,
+		Key:      "X-Api-Key",
+		Lookup:   map[string]string{"key-1": "gold", "key-2": "silver"},
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	for key, want := range map[string]string{"key-1": "gold", "key-2": "silver", "key-3": "", "": ""} {
+		if tier := selector(newTestContext("/", map[string]string{"X-Api-Key": key})); tier != want {
+			t.Errorf("unexpected tier of %q. have: %q, want: %q", key, tier, want)
+		}
+	}
+
+	if _, err := NewTierSelector(router.TierSelectorConfig{Strategy: "cookie", Key: "plan"}); err == nil {
+		t.Error("error expected for an unknown strategy")
+	}
+}
+
+func TestNewTieredLimiterMw(t *testing.T) {
+	tiers := map[string]Tier{
+		"gold": {
+			Name:    "gold",
+			Limiter: fixedStore(krakendrate.Decision{Allowed: true, Limit: 100, Remaining: 99}),
+		},
+		"free": {
+			Name:    "free",
+			Limiter: fixedStore(krakendrate.Decision{Allowed: true, Limit: 10, Remaining: 9}),
+		},
+		"blocked": {
+			Name:    "blocked",
+			Limiter: fixedStore(krakendrate.Decision{Limit: 1, RetryAfter: 2 * time.Second}),
+		},
+	}
+	selector, _ := NewTierSelector(router.TierSelectorConfig{Strategy: StrategyHeader, Key: "X-Plan"})
+	tokenExtractor := HeaderTokenExtractor("X-Client")
+
+	for _, tc := range []struct {
+		name        string
+		defaultTier string
+		plan        string
+		client      string
+		status      int
+		tier        string
+		limit       string
+	}{
+		{name: "known tier", defaultTier: "free", plan: "gold", client: "a", status: http.StatusOK, tier: "gold", limit: "100"},
+		{name: "unknown tier with default", defaultTier: "free", plan: "platinum", client: "a", status: http.StatusOK, tier: "free", limit: "10"},
+		{name: "missing tier with default", defaultTier: "free", client: "a", status: http.StatusOK, tier: "free", limit: "10"},
+		{name: "unknown tier without default", plan: "platinum", client: "a", status: http.StatusServiceUnavailable},
+		{name: "missing tier without default", client: "a", status: http.StatusServiceUnavailable},
+		{name: "limited tier", plan: "blocked", client: "a", status: http.StatusServiceUnavailable, limit: "1"},
+		{name: "missing client", plan: "gold", status: http.StatusServiceUnavailable},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var tier interface{}
+			mw := NewTieredLimiterMw(selector, tokenExtractor, tiers, tc.defaultTier, http.StatusServiceUnavailable, "/test")
+			gin.SetMode(gin.TestMode)
+			engine := gin.New()
+			engine.GET("/", mw(func(c *gin.Context) {
+				tier, _ = c.Get(TierContextKey)
+				c.Status(http.StatusOK)
+			}))
+
+			req, _ := http.NewRequest(http.MethodGet, "/", http.NoBody)
+			if tc.plan != "" {
+				req.Header.Set("X-Plan", tc.plan)
+			}
+			if tc.client != "" {
+				req.Header.Set("X-Client", tc.client)
+			}
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+
+			if w.Code != tc.status {
+				t.Errorf("unexpected status code: %d", w.Code)
+			}
+			if tc.tier != "" && tier != tc.tier {
+				t.Errorf("unexpected tier in the context: %v", tier)
+			}
+			if l := w.Header().Get(HeaderRateLimitLimit); l != tc.limit {
+				t.Errorf("unexpected limit. have: %q, want: %q", l, tc.limit)
+			}
+		})
+	}
+}
+
+func TestNewTiers(t *testing.T) {
+	tiers, err := newTiers(router.Config{
+		Tiers: map[string]router.TierConfig{
+			"slow":  {Name: "slow", MaxRate: 0.5},
+			"fast":  {Name: "fast", MaxRate: 3},
+			"burst": {Name: "burst", MaxRate: 1, Capacity: 2},
+		},
+	}, func(string, time.Duration) krakendrate.Backend {
+		return newMemoryBackendWithTTL(context.Background(), time.Minute)
+	}, nil)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	for name, capacity := range map[string]int{"slow": 1, "fast": 3, "burst": 2} {
+		tier, ok := tiers[name]
+		if !ok || tier.Name != name {
+			t.Errorf("the tier %s is missing", name)
+			continue
+		}
+		if tier.Quota != nil {
+			t.Errorf("unexpected quota in the tier %s", name)
+		}
+		l := tier.Limiter("client")
+		for i := 0; i < capacity; i++ {
+			if !l.Allow() {
+				t.Errorf("the request #%d of the tier %s was rejected", i, name)
+			}
+		}
+		if l.Allow() {
+			t.Errorf("the tier %s allowed more than %d requests", name, capacity)
+		}
+		if !tier.Limiter("other client").Allow() {
+			t.Errorf("the clients of the tier %s share the bucket", name)
+		}
+	}
+}
+
+func TestNewTiers_invalidQuota(t *testing.T) {
+	tiers, err := newTiers(router.Config{
+		Tiers: map[string]router.TierConfig{
+			"free": {Name: "free", MaxRate: 1, Quota: &krakendrate.QuotaConfig{MaxRequests: 10, Period: "day"}},
+		},
+	}, func(string, time.Duration) krakendrate.Backend {
+		return newMemoryBackendWithTTL(context.Background(), time.Minute)
+	}, func(string, krakendrate.QuotaConfig) (*krakendrate.Quota, error) {
+		return nil, errors.New("broken quota")
+	})
+	if err == nil {
+		t.Error("error expected")
+	}
+	tier, ok := tiers["free"]
+	if !ok {
+		t.Error("the tier with an invalid quota was dropped")
+		return
+	}
+	if tier.Quota != nil {
+		t.Error("unexpected quota")
+	}
+	if l := tier.Limiter("client"); !l.Allow() || l.Allow() {
+		t.Error("the rate limit of the tier was not applied")
+	}
+}
+
+// fixedStore returns a LimiterStore returning the same decision for every client
+func fixedStore(d krakendrate.Decision) krakendrate.LimiterStore {
+	return func(string) krakendrate.Limiter { return fixedDecision(d) }
+}
@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"net/http"
 	"time"
 
 	krakendrate "api-gateway/v2/modules/krakend-ratelimit/v3"
@@ -26,9 +27,33 @@ type Config struct {
 	ClientMaxRate  float64
 	ClientCapacity uint64
 	Key            string
-	TTL            time.Duration
+	// Composite lists the strategies combined in the key of the composite strategy
+	Composite []KeyConfig
+	TTL       time.Duration
+	// StatusCode is the status of the rejected requests
+	StatusCode int
+	// Redis is the store shared by the instances of the gateway. The limits are kept in memory,
+	// per instance, when it is not set
+	Redis *krakendrate.RedisConfig
+	// Tiers are the limits of the plans of the clients, replacing the client_max_rate
+	Tiers map[string]TierConfig
+	// TierSelector resolves the tier of every request
+	TierSelector *TierSelectorConfig
+	// DefaultTier is applied to the requests without a known tier. They are rejected if it is empty
+	DefaultTier string
+	// Quota is the maximum number of requests of every client in a long period
+	Quota *krakendrate.QuotaConfig
 }
 
+// KeyConfig is a strategy, and its key, used to identify the clients
+type KeyConfig struct {
+	Strategy string
+	Key      string
+}
+
+// DefaultStatusCode is the status of the rejected requests when the config does not declare it
+const DefaultStatusCode = http.StatusTooManyRequests
+
 // ZeroCfg is the zero value for the Config struct
 var ZeroCfg = Config{}
 
@@ -95,6 +120,60 @@ func ConfigGetter(e config.ExtraConfig) (Config, error) {
 	if v, ok := tmp["key"]; ok {
 		cfg.Key = fmt.Sprintf("%v", v)
 	}
+	if v, ok := tmp["composite"]; ok {
+		parts, ok := v.([]interface{})
+		if !ok {
+			return ZeroCfg, ErrWrongExtraCfg
+		}
+		for _, part := range parts {
+			p, ok := part.(map[string]interface{})
+			if !ok {
+				return ZeroCfg, ErrWrongExtraCfg
+			}
+			k := KeyConfig{}
+			if v, ok := p["strategy"]; ok {
+				k.Strategy = fmt.Sprintf("%v", v)
+			}
+			if v, ok := p["key"]; ok {
+				k.Key = fmt.Sprintf("%v", v)
+			}
+			cfg.Composite = append(cfg.Composite, k)
+		}
+	}
+	cfg.StatusCode = DefaultStatusCode
+	if v, ok := tmp["status_code"]; ok {
+		switch val := v.(type) {
+		case int64:
+			cfg.StatusCode = int(val)
+		case int:
+			cfg.StatusCode = val
+		case float64:
+			cfg.StatusCode = int(val)
+		}
+		if cfg.StatusCode < 400 || cfg.StatusCode > 599 {
+			return ZeroCfg, fmt.Errorf("invalid rate limit status_code %d", cfg.StatusCode)
+		}
+	}
+
+	if v, ok := tmp["redis"]; ok {
+		redisCfg, err := krakendrate.RedisConfigGetter(v)
+		if err != nil {
+			return ZeroCfg, err
+		}
+		cfg.Redis = redisCfg
+	}
+
+	if v, ok := tmp["quota"]; ok {
+		quotaCfg, err := krakendrate.QuotaConfigGetter(v)
+		if err != nil {
+			return ZeroCfg, err
+		}
+		cfg.Quota = quotaCfg
+	}
+
+	if err := parseTiers(tmp, &cfg); err != nil {
+		return ZeroCfg, err
+	}
 
 	cfg.TTL = krakendrate.DataTTL
 	if v, ok := tmp["every"]; ok {
@@ -0,0 +1,129 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	krakendrate "api-gateway/v2/modules/krakend-ratelimit/v3"
+)
+
+// TierConfig is the limit applied to the clients of a plan
+type TierConfig struct {
+	Name     string
+	MaxRate  float64
+	Capacity uint64
+	// Quota is the optional maximum number of requests of every client in a longer period
+	Quota *krakendrate.QuotaConfig
+}
+
+// TierSelectorConfig describes how the tier of a request is resolved: the value extracted with
+// the strategy and key is the name of the tier or, if there is a lookup file, the key of the
+// tier name in the JSON object of the file
+type TierSelectorConfig struct {
+	Strategy   string
+	Key        string
+	LookupFile string
+	// Lookup maps the extracted values to the tiers. It is loaded from the lookup file
+	Lookup map[string]string
+}
+
+// parseTiers adds the tiers of the extra config, their selector and the fallback tier to the config
+func parseTiers(tmp map[string]interface{}, cfg *Config) error {
+	v, ok := tmp["tiers"]
+	if !ok {
+		return nil
+	}
+	tiers, ok := v.(map[string]interface{})
+	if !ok || len(tiers) == 0 {
+		return ErrWrongExtraCfg
+	}
+
+	cfg.Tiers = make(map[string]TierConfig, len(tiers))
+	for name, v := range tiers {
+		t, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("wrong config of the tier %s", name)
+		}
+		tier := TierConfig{Name: name, MaxRate: toFloat(t["max_rate"]), Capacity: toUint(t["capacity"])}
+		if v, ok := t["every"]; ok {
+			every, err := time.ParseDuration(fmt.Sprintf("%v", v))
+			if err != nil || every <= 0 {
+				return fmt.Errorf("wrong every of the tier %s: %v", name, v)
+			}
+			tier.MaxRate = tier.MaxRate * float64(time.Second) / float64(every)
+		}
+		if tier.MaxRate <= 0 {
+			return fmt.Errorf("the tier %s requires a positive max_rate", name)
+		}
+
+		if v, ok := t["quota"]; ok {
+			quota, err := krakendrate.QuotaConfigGetter(v)
+			if err != nil {
+				return fmt.Errorf("wrong quota of the tier %s: %w", name, err)
+			}
+			tier.Quota = quota
+		}
+		cfg.Tiers[name] = tier
+	}
+
+	if v, ok := tmp["default_tier"]; ok {
+		cfg.DefaultTier = fmt.Sprintf("%v", v)
+		if _, ok := cfg.Tiers[cfg.DefaultTier]; !ok {
+			return fmt.Errorf("the default tier %s is not defined", cfg.DefaultTier)
+		}
+	}
+
+	v, ok = tmp["tier_selector"]
+	if !ok {
+		return fmt.Errorf("the tiers require a tier_selector")
+	}
+	s, ok := v.(map[string]interface{})
+	if !ok {
+		return ErrWrongExtraCfg
+	}
+	selector := &TierSelectorConfig{}
+	if v, ok := s["strategy"]; ok {
+		selector.Strategy = fmt.Sprintf("%v", v)
+	}
+	if v, ok := s["key"]; ok {
+		selector.Key = fmt.Sprintf("%v", v)
+	}
+	if v, ok := s["lookup_file"]; ok {
+		selector.LookupFile = fmt.Sprintf("%v", v)
+		b, err := os.ReadFile(selector.LookupFile)
+		if err != nil {
+			return fmt.Errorf("reading the tier lookup file: %w", err)
+		}
+		if err := json.Unmarshal(b, &selector.Lookup); err != nil {
+			return fmt.Errorf("parsing the tier lookup file: %w", err)
+		}
+	}
+	cfg.TierSelector = selector
+	return nil
+}
+
+func toFloat(v interface{}) float64 {
+	switch val := v.(type) {
+	case int64:
+		return float64(val)
+	case int:
+		return float64(val)
+	case float64:
+		return val
+	}
+	return 0
+}
+
+func toUint(v interface{}) uint64 {
+	switch val := v.(type) {
+	case int64:
+		return uint64(val)
+	case int:
+		return uint64(val)
+	case float64:
+		return uint64(val)
+	}
+	return 0
+}
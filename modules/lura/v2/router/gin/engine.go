@@ -3,6 +3,7 @@
 package gin
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -24,6 +25,9 @@ import (
 const Namespace = "github_com/davron112/lura/router/gin"
 
 type EngineOptions struct {
+	// Context is the context of the service. The resources of the engine extensions are released
+	// once it is done
+	Context   context.Context
 	Logger    logging.Logger
 	Writer    io.Writer
 	Formatter gin.LogFormatter
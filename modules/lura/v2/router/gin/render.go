@@ -22,6 +22,10 @@ const NEGOTIATE = "negotiate"
 const XML = "xml"
 const YAML = "yaml"
 
+// STREAM defines the value of the OutputEncoding for the render flushing the response Io
+// as it is received
+const STREAM = "stream"
+
 var (
 	mutex          = &sync.RWMutex{}
 	renderRegister = map[string]Render{
@@ -31,6 +35,7 @@ var (
 		"json-collection": jsonCollectionRender,
 		XML:               xmlRender,
 		YAML:              yamlRender,
+		STREAM:            streamRender,
 	}
 )
 
@@ -164,4 +169,52 @@ func noopRender(c *gin.Context, response *proxy.Response) {
 	io.Copy(c.Writer, response.Io)
 }
 
+// streamRender copies the response Io into the client connection, flushing every chunk as
+// soon as it is read. Closable readers are closed when the client goes away, so the backend
+// can cancel the upstream stream. Responses without Io are rendered as JSON.
+func streamRender(c *gin.Context, response *proxy.Response) {
+	if response == nil || response.Io == nil {
+		jsonRender(c, response)
+		return
+	}
+	for k, vs := range response.Metadata.Headers {
+		for _, v := range vs {
+			c.Writer.Header().Add(k, v)
+		}
+	}
+	if response.Metadata.StatusCode != 0 {
+		c.Status(response.Metadata.StatusCode)
+	}
+	c.Writer.WriteHeaderNow()
+	c.Writer.Flush()
+
+	closer, isCloser := response.Io.(io.Closer)
+	if isCloser {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-c.Request.Context().Done():
+				closer.Close()
+			case <-done:
+			}
+		}()
+		defer closer.Close()
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := response.Io.Read(buf)
+		if n > 0 {
+			if _, werr := c.Writer.Write(buf[:n]); werr != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
 var emptyResponse = gin.H{}
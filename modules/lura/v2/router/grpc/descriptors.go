@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	grpcproxy "api-gateway/v2/proxy/grpc"
+	"github.com/jhump/protoreflect/desc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// ErrNoDescriptors is the error returned when the router config does not declare any descriptor
+var ErrNoDescriptors = errors.New("grpc router: no protosets nor proto_files declared")
+
+// LoadDescriptors loads the protosets and the proto files declared in the config. They are
+// loaded as the ones of the gRPC backends, so both share the cached registry of the same files.
+func LoadDescriptors(cfg ServerConfig) (*grpcproxy.DescriptorRegistry, error) {
+	if len(cfg.Protosets) == 0 && len(cfg.ProtoFiles) == 0 {
+		return nil, ErrNoDescriptors
+	}
+	return grpcproxy.LoadDescriptors(grpcproxy.Config{
+		Protosets:   cfg.Protosets,
+		ProtoFiles:  cfg.ProtoFiles,
+		ImportPaths: cfg.ImportPaths,
+	})
+}
+
+// findMethod looks for the method in the descriptors. The name follows the /package.Service/Method format.
+func findMethod(descriptors *grpcproxy.DescriptorRegistry, fullMethod string) (*desc.MethodDescriptor, error) {
+	parts := strings.Split(strings.TrimPrefix(fullMethod, "/"), "/")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("grpc router: invalid method %s. Expected format: /package.Service/Method", fullMethod)
+	}
+	return descriptors.FindMethod(parts[0], parts[1])
+}
+
+// newFileResolver registers the files and all their dependencies, so the reflection service
+// can describe them
+func newFileResolver(files []*desc.FileDescriptor) *protoregistry.Files {
+	res := new(protoregistry.Files)
+	seen := map[string]struct{}{}
+	var register func(fd *desc.FileDescriptor)
+	register = func(fd *desc.FileDescriptor) {
+		if _, ok := seen[fd.GetName()]; ok {
+			return
+		}
+		seen[fd.GetName()] = struct{}{}
+		for _, dep := range fd.GetDependencies() {
+			register(dep)
+		}
+		res.RegisterFile(fd.UnwrapFile())
+	}
+	for _, fd := range files {
+		register(fd)
+	}
+	return res
+}
@@ -0,0 +1,283 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"api-gateway/v2/modules/lura/v2/config"
+	"api-gateway/v2/modules/lura/v2/core"
+	"api-gateway/v2/modules/lura/v2/proxy"
+	"api-gateway/v2/modules/lura/v2/transport/http/server"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const requestParamsAsterisk string = "*"
+
+// MethodHandler is the handler of a unary gRPC method, as expected by the grpc.MethodDesc
+type MethodHandler = func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error)
+
+// HandlerFactory creates a method handler adapting the gRPC server with the injected proxy
+type HandlerFactory func(*config.EndpointConfig, *desc.MethodDescriptor, proxy.Proxy) MethodHandler
+
+// EndpointHandler is the default HandlerFactory. The fields of the input message matching the
+// params of the endpoint are used as params, the ones declared in the input_query_strings are
+// used as query strings and the whole message is sent as the body of the request. The data of
+// the response is decoded into the output message of the method, ignoring the unknown fields.
+func EndpointHandler(cfg *config.EndpointConfig, md *desc.MethodDescriptor, prxy proxy.Proxy) MethodHandler {
+	params := endpointParams(cfg.Endpoint)
+	headersToSend := cfg.HeadersToPass
+	if len(headersToSend) == 0 {
+		headersToSend = server.HeadersToSend
+	}
+	fullMethod := fmt.Sprintf("/%s/%s", md.GetService().GetFullyQualifiedName(), md.GetName())
+	unmarshaler := &jsonpb.Unmarshaler{AllowUnknownFields: true}
+	marshaler := &jsonpb.Marshaler{OrigName: true}
+
+	return func(_ interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		in := dynamic.NewMessage(md.GetInputType())
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			in := req.(*dynamic.Message)
+			body, err := in.MarshalJSONPB(marshaler)
+			if err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+
+			requestCtx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+			defer cancel()
+
+			response, err := prxy(requestCtx, newRequest(ctx, cfg, in, body, params, headersToSend))
+			if err != nil {
+				return nil, toStatusError(err)
+			}
+			if response == nil {
+				return nil, status.Error(codes.Internal, server.ErrInternalError.Error())
+			}
+
+			grpc.SetHeader(ctx, responseMetadata(response))
+
+			out := dynamic.NewMessage(md.GetOutputType())
+			if len(response.Data) == 0 {
+				return out, nil
+			}
+			b, err := json.Marshal(response.Data)
+			if err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+			if err := out.UnmarshalJSONPB(unmarshaler, b); err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+			return out, nil
+		}
+
+		if interceptor == nil {
+			return handler(ctx, in)
+		}
+		return interceptor(ctx, in, &grpc.UnaryServerInfo{FullMethod: fullMethod}, handler)
+	}
+}
+
+// newRequest builds the request for the proxy as the http routers do, using the incoming
+// metadata as headers
+func newRequest(ctx context.Context, cfg *config.EndpointConfig, in *dynamic.Message, body []byte, params, headersToSend []string) *proxy.Request {
+	fields := messageValues(in)
+
+	reqParams := make(map[string]string, len(params))
+	for _, p := range params {
+		if v, ok := fields[p]; ok && len(v) > 0 {
+			reqParams[textproto.CanonicalMIMEHeaderKey(p[:1])+p[1:]] = v[0]
+		}
+	}
+
+	query := make(map[string][]string, len(cfg.QueryString))
+	for _, q := range cfg.QueryString {
+		if q == requestParamsAsterisk {
+			for k, v := range fields {
+				query[k] = v
+			}
+			break
+		}
+		if v, ok := fields[q]; ok && len(v) > 0 {
+			query[q] = v
+		}
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	headers := make(map[string][]string, 3+len(headersToSend))
+	for _, k := range headersToSend {
+		if k == requestParamsAsterisk {
+			for name, vs := range md {
+				headers[textproto.CanonicalMIMEHeaderKey(name)] = vs
+			}
+			break
+		}
+		if vs := md.Get(k); len(vs) > 0 {
+			headers[k] = vs
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		host := p.Addr.String()
+		if i := strings.LastIndex(host, ":"); i > 0 {
+			host = host[:i]
+		}
+		headers["X-Forwarded-For"] = []string{host}
+		if _, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			headers["X-Forwarded-Proto"] = []string{"https"}
+		}
+	}
+	if vs := md.Get(":authority"); len(vs) > 0 {
+		headers["X-Forwarded-Host"] = vs
+	}
+	if _, ok := headers["User-Agent"]; !ok {
+		headers["User-Agent"] = server.UserAgentHeaderValue
+	} else {
+		headers["X-Forwarded-Via"] = server.UserAgentHeaderValue
+	}
+
+	return &proxy.Request{
+		Path:    cfg.Endpoint,
+		Method:  cfg.Method,
+		Query:   query,
+		Body:    newBody(body),
+		Params:  reqParams,
+		Headers: headers,
+	}
+}
+
+func newBody(b []byte) io.ReadCloser {
+	return io.NopCloser(bytes.NewReader(b))
+}
+
+// messageValues returns the string representation of the scalar fields of the message,
+// indexed by their proto and JSON names
+func messageValues(in *dynamic.Message) map[string][]string {
+	res := map[string][]string{}
+	for _, fd := range in.GetKnownFields() {
+		if fd.GetMessageType() != nil || fd.IsMap() {
+			continue
+		}
+		var values []string
+		if fd.IsRepeated() {
+			for i := 0; i < in.FieldLength(fd); i++ {
+				values = append(values, fmt.Sprintf("%v", in.GetRepeatedField(fd, i)))
+			}
+		} else if in.HasField(fd) {
+			values = []string{fmt.Sprintf("%v", in.GetField(fd))}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		res[fd.GetName()] = values
+		res[fd.GetJSONName()] = values
+	}
+	return res
+}
+
+// endpointParams returns the names of the params of the endpoint path
+func endpointParams(path string) []string {
+	var params []string
+	for _, part := range strings.Split(path, "/") {
+		switch {
+		case strings.HasPrefix(part, ":"), strings.HasPrefix(part, "*"):
+			params = append(params, part[1:])
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}"):
+			params = append(params, part[1:len(part)-1])
+		}
+	}
+	return params
+}
+
+// responseMetadata returns the headers of the response as metadata, adding the gateway ones
+func responseMetadata(response *proxy.Response) metadata.MD {
+	md := metadata.MD{}
+	for k, vs := range response.Metadata.Headers {
+		key := strings.ToLower(k)
+		if key == "content-type" || key == "content-length" || strings.HasPrefix(key, "grpc-") {
+			continue
+		}
+		md.Append(key, vs...)
+	}
+	md.Set(strings.ToLower(core.KrakendHeaderName), core.KrakendHeaderValue)
+	complete := server.HeaderIncompleteResponseValue
+	if response.IsComplete {
+		complete = server.HeaderCompleteResponseValue
+	}
+	md.Set(strings.ToLower(server.CompleteResponseHeaderName), complete)
+	return md
+}
+
+// toStatusError converts the errors returned by the proxy into status errors. The errors
+// already carrying a gRPC status keep it, while the ones with an HTTP status code are
+// translated into the closest gRPC code.
+func toStatusError(err error) error {
+	if _, ok := err.(interface{ GRPCStatus() *status.Status }); ok {
+		return err
+	}
+	if t, ok := err.(responseError); ok {
+		return status.Error(CodeFromHTTPStatus(t.StatusCode()), err.Error())
+	}
+	if err == context.DeadlineExceeded {
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// CodeFromHTTPStatus returns the gRPC code matching the HTTP status code
+func CodeFromHTTPStatus(s int) codes.Code {
+	switch s {
+	case http.StatusOK, http.StatusCreated, http.StatusAccepted, http.StatusNoContent:
+		return codes.OK
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.Aborted
+	case http.StatusPreconditionFailed:
+		return codes.FailedPrecondition
+	case http.StatusRequestedRangeNotSatisfiable:
+		return codes.OutOfRange
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case 499:
+		return codes.Canceled
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	}
+	if s >= 400 && s < 500 {
+		return codes.FailedPrecondition
+	}
+	return codes.Internal
+}
+
+type responseError interface {
+	error
+	StatusCode() int
+}
@@ -0,0 +1,186 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"api-gateway/v2/modules/lura/v2/config"
+	"api-gateway/v2/modules/lura/v2/core"
+	"api-gateway/v2/modules/lura/v2/proxy"
+	"api-gateway/v2/modules/lura/v2/transport/http/server"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func testUsersMethod(t *testing.T) *desc.MethodDescriptor {
+	t.Helper()
+	p := protoparse.Parser{Accessor: protoparse.FileContentsFromMap(map[string]string{"users.proto": testUsersProto})}
+	fds, err := p.ParseFiles("users.proto")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	return fds[0].FindService("users.Users").FindMethodByName("GetUser")
+}
+
+func TestNewRequest(t *testing.T) {
+	md := testUsersMethod(t)
+	in := dynamic.NewMessage(md.GetInputType())
+	in.SetFieldByName("id", "42")
+	in.SetFieldByName("fields", []string{"name", "email"})
+
+	incoming := metadata.Pairs(
+		"x-name", "john",
+		"user-agent", "grpc-go",
+		":authority", "api.example.com",
+	)
+	ctx := metadata.NewIncomingContext(context.Background(), incoming)
+	ctx = peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}})
+
+	for _, tc := range []struct {
+		name    string
+		cfg     *config.EndpointConfig
+		headers []string
+		query   map[string][]string
+		want    map[string][]string
+	}{
+		{
+			name:    "allowed headers",
+			cfg:     &config.EndpointConfig{Endpoint: "/users/:id", Method: "GET", QueryString: []string{"fields", "page"}},
+			headers: []string{"X-Name"},
+			query:   map[string][]string{"fields": {"name", "email"}},
+			want: map[string][]string{
+				"X-Name":           {"john"},
+				"X-Forwarded-For":  {"10.0.0.1"},
+				"X-Forwarded-Host": {"api.example.com"},
+				"User-Agent":       server.UserAgentHeaderValue,
+			},
+		},
+		{
+			name:    "all the headers and query strings",
+			cfg:     &config.EndpointConfig{Endpoint: "/users/{id}", Method: "GET", QueryString: []string{"*"}},
+			headers: []string{"*"},
+			query:   map[string][]string{"id": {"42"}, "fields": {"name", "email"}},
+			want: map[string][]string{
+				"X-Name":           {"john"},
+				"User-Agent":       {"grpc-go"},
+				":authority":       {"api.example.com"},
+				"X-Forwarded-For":  {"10.0.0.1"},
+				"X-Forwarded-Host": {"api.example.com"},
+				"X-Forwarded-Via":  server.UserAgentHeaderValue,
+			},
+		},
+	} {
+		r := newRequest(ctx, tc.cfg, in, []byte(`{}`), endpointParams(tc.cfg.Endpoint), tc.headers)
+		if r.Path != tc.cfg.Endpoint || r.Method != "GET" {
+			t.Errorf("%s: unexpected request: %s %s", tc.name, r.Method, r.Path)
+		}
+		if !reflect.DeepEqual(r.Params, map[string]string{"Id": "42"}) {
+			t.Errorf("%s: unexpected params: %v", tc.name, r.Params)
+		}
+		if !reflect.DeepEqual(map[string][]string(r.Query), tc.query) {
+			t.Errorf("%s: unexpected query: %v", tc.name, r.Query)
+		}
+		for k, v := range tc.want {
+			if !reflect.DeepEqual(r.Headers[k], v) {
+				t.Errorf("%s: unexpected header %s: %v", tc.name, k, r.Headers[k])
+			}
+		}
+		if vs, ok := r.Headers["X-Forwarded-Proto"]; ok {
+			t.Errorf("%s: unexpected forwarded proto: %v", tc.name, vs)
+		}
+	}
+
+	// the requests received by the TLS listeners are forwarded as https ones
+	ctx = peer.NewContext(ctx, &peer.Peer{
+		Addr:     &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234},
+		AuthInfo: credentials.TLSInfo{},
+	})
+	r := newRequest(ctx, &config.EndpointConfig{Endpoint: "/users/:id", Method: "GET"}, in, []byte(`{}`), []string{"id"}, nil)
+	if vs := r.Headers["X-Forwarded-Proto"]; !reflect.DeepEqual(vs, []string{"https"}) {
+		t.Errorf("unexpected forwarded proto: %v", vs)
+	}
+}
+
+func TestEndpointParams(t *testing.T) {
+	for path, want := range map[string][]string{
+		"/users":                   nil,
+		"/users/:id":               {"id"},
+		"/users/{id}/orders/:page": {"id", "page"},
+		"/files/*path":             {"path"},
+	} {
+		if params := endpointParams(path); !reflect.DeepEqual(params, want) {
+			t.Errorf("unexpected params of %s: %v", path, params)
+		}
+	}
+}
+
+func TestResponseMetadata(t *testing.T) {
+	md := responseMetadata(&proxy.Response{
+		Metadata: proxy.Metadata{Headers: map[string][]string{
+			"X-Backend":      {"users"},
+			"Content-Type":   {"application/json"},
+			"Content-Length": {"10"},
+			"Grpc-Status":    {"0"},
+		}},
+	})
+	want := metadata.Pairs(
+		"x-backend", "users",
+		core.KrakendHeaderName, core.KrakendHeaderValue,
+		server.CompleteResponseHeaderName, server.HeaderIncompleteResponseValue,
+	)
+	if !reflect.DeepEqual(md, want) {
+		t.Errorf("unexpected metadata: %v", md)
+	}
+}
+
+func TestToStatusError(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		code codes.Code
+	}{
+		{err: status.Error(codes.AlreadyExists, "duplicated"), code: codes.AlreadyExists},
+		{err: testHTTPError{http: http.StatusTooManyRequests}, code: codes.ResourceExhausted},
+		{err: context.DeadlineExceeded, code: codes.DeadlineExceeded},
+		{err: errors.New("boom"), code: codes.Internal},
+	} {
+		if c := status.Code(toStatusError(tc.err)); c != tc.code {
+			t.Errorf("unexpected code of %v: %s", tc.err, c)
+		}
+	}
+}
+
+func TestCodeFromHTTPStatus(t *testing.T) {
+	for s, want := range map[int]codes.Code{
+		http.StatusOK:                  codes.OK,
+		http.StatusNoContent:           codes.OK,
+		http.StatusBadRequest:          codes.InvalidArgument,
+		http.StatusUnauthorized:        codes.Unauthenticated,
+		http.StatusForbidden:           codes.PermissionDenied,
+		http.StatusNotFound:            codes.NotFound,
+		http.StatusConflict:            codes.Aborted,
+		http.StatusTooManyRequests:     codes.ResourceExhausted,
+		499:                            codes.Canceled,
+		http.StatusTeapot:              codes.FailedPrecondition,
+		http.StatusNotImplemented:      codes.Unimplemented,
+		http.StatusBadGateway:          codes.Unavailable,
+		http.StatusServiceUnavailable:  codes.Unavailable,
+		http.StatusGatewayTimeout:      codes.DeadlineExceeded,
+		http.StatusInternalServerError: codes.Internal,
+	} {
+		if c := CodeFromHTTPStatus(s); c != want {
+			t.Errorf("unexpected code of %d: %s", s, c)
+		}
+	}
+}
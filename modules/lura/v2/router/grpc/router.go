@@ -0,0 +1,335 @@
+// SPDX-License-Identifier: Apache-2.0
+
+/*
+Package grpc provides a router exposing the endpoints of the gateway as gRPC methods
+
+The methods are declared in the proto files or protosets listed in the service extra config and
+every endpoint binds one of them with its own pipeline:
+
+	"extra_config": {
+		"router/grpc": {
+			"port": 9090,
+			"proto_files": ["users.proto"],
+			"import_paths": ["./protos"]
+		}
+	}
+
+	"endpoints": [{
+		"endpoint": "/users/{id}",
+		"extra_config": {
+			"router/grpc": {"method": "/users.Users/GetUser"}
+		},
+		...
+	}]
+*/
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+
+	"api-gateway/v2/modules/lura/v2/config"
+	"api-gateway/v2/modules/lura/v2/logging"
+	"api-gateway/v2/modules/lura/v2/proxy"
+	"api-gateway/v2/modules/lura/v2/router"
+	"api-gateway/v2/modules/lura/v2/transport/http/server"
+	grpcproxy "api-gateway/v2/proxy/grpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/reflection"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+)
+
+// Namespace is the key to use to store and access the custom config data for the router
+const Namespace = "github_com/davron112/lura/router/grpc"
+
+// DefaultPort is the port used when the service config does not declare one
+const DefaultPort = 9090
+
+const logPrefix = "[SERVICE: gRPC]"
+
+// ErrNoConfig is the error returned when the service has no gRPC router config
+var ErrNoConfig = errors.New("grpc router: no config")
+
+// RunServerFunc is a func that will run the gRPC server with the given params
+type RunServerFunc func(context.Context, ServerConfig, *grpc.Server) error
+
+// Config is the struct that collects the parts the router should be builded from
+type Config struct {
+	HandlerFactory HandlerFactory
+	ProxyFactory   proxy.Factory
+	Logger         logging.Logger
+	ServerOptions  []grpc.ServerOption
+	RunServer      RunServerFunc
+}
+
+// ServerConfig is the config of the gRPC server, parsed from the service extra config
+type ServerConfig struct {
+	Address     string
+	Port        int
+	Protosets   []string
+	ProtoFiles  []string
+	ImportPaths []string
+	Reflection  bool
+	TLS         *config.TLS
+}
+
+// DefaultFactory returns a gRPC router factory with the injected proxy factory and logger
+func DefaultFactory(pf proxy.Factory, logger logging.Logger) router.Factory {
+	return NewFactory(Config{
+		HandlerFactory: EndpointHandler,
+		ProxyFactory:   pf,
+		Logger:         logger,
+		RunServer:      RunServer,
+	})
+}
+
+// NewFactory returns a gRPC router factory with the injected configuration
+func NewFactory(cfg Config) router.Factory {
+	if cfg.HandlerFactory == nil {
+		cfg.HandlerFactory = EndpointHandler
+	}
+	if cfg.RunServer == nil {
+		cfg.RunServer = RunServer
+	}
+	return factory{cfg}
+}
+
+type factory struct {
+	cfg Config
+}
+
+// New implements the factory interface
+func (rf factory) New() router.Router {
+	return rf.NewWithContext(context.Background())
+}
+
+// NewWithContext implements the factory interface
+func (rf factory) NewWithContext(ctx context.Context) router.Router {
+	return grpcRouter{rf.cfg, ctx}
+}
+
+type grpcRouter struct {
+	cfg Config
+	ctx context.Context
+}
+
+// Run implements the router interface
+func (r grpcRouter) Run(cfg config.ServiceConfig) {
+	if err := Serve(r.ctx, r.cfg, cfg); err != nil {
+		r.cfg.Logger.Error(logPrefix, err.Error())
+	}
+
+	r.cfg.Logger.Info(logPrefix, "Router execution ended")
+}
+
+// Serve runs the gRPC router of the service until the context is done. It returns the errors
+// preventing the server from starting or serving.
+func Serve(ctx context.Context, rcfg Config, cfg config.ServiceConfig) error {
+	if rcfg.HandlerFactory == nil {
+		rcfg.HandlerFactory = EndpointHandler
+	}
+	if rcfg.RunServer == nil {
+		rcfg.RunServer = RunServer
+	}
+	r := grpcRouter{rcfg, ctx}
+
+	serverCfg, err := ConfigGetter(cfg)
+	if err != nil {
+		return err
+	}
+
+	descriptors, err := LoadDescriptors(serverCfg)
+	if err != nil {
+		return err
+	}
+
+	opts := r.cfg.ServerOptions
+	if serverCfg.TLS != nil {
+		tlsConfig, err := parseTLSConfig(serverCfg.TLS, r.cfg.Logger)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	s := grpc.NewServer(opts...)
+
+	r.registerKrakendEndpoints(s, descriptors, cfg.Endpoints)
+
+	if serverCfg.Reflection {
+		rpb.RegisterServerReflectionServer(s, reflection.NewServer(reflection.ServerOptions{
+			Services:           s,
+			DescriptorResolver: newFileResolver(descriptors.Files()),
+		}))
+	}
+
+	return r.cfg.RunServer(ctx, serverCfg, s)
+}
+
+func (r grpcRouter) registerKrakendEndpoints(s *grpc.Server, descriptors *grpcproxy.DescriptorRegistry, endpoints []*config.EndpointConfig) {
+	services := map[string]*grpc.ServiceDesc{}
+	var order []string
+
+	for _, e := range endpoints {
+		fullMethod, ok := endpointMethod(e)
+		if !ok {
+			continue
+		}
+		md, err := findMethod(descriptors, fullMethod)
+		if err != nil {
+			r.cfg.Logger.Error(logPrefix, "Endpoint", e.Endpoint, err.Error())
+			continue
+		}
+		if md.IsClientStreaming() || md.IsServerStreaming() {
+			r.cfg.Logger.Error(logPrefix, "Streaming methods are not supported. Ignoring", fullMethod)
+			continue
+		}
+
+		proxyStack, err := r.cfg.ProxyFactory.New(e)
+		if err != nil {
+			r.cfg.Logger.Error(logPrefix, "Calling the ProxyFactory", err.Error())
+			continue
+		}
+
+		serviceName := md.GetService().GetFullyQualifiedName()
+		sd, ok := services[serviceName]
+		if !ok {
+			sd = &grpc.ServiceDesc{
+				ServiceName: serviceName,
+				HandlerType: (*interface{})(nil),
+				Metadata:    md.GetFile().GetName(),
+			}
+			services[serviceName] = sd
+			order = append(order, serviceName)
+		}
+		if hasMethod(sd, md.GetName()) {
+			r.cfg.Logger.Error(logPrefix, "Method already bound to another endpoint. Ignoring", fullMethod, e.Endpoint)
+			continue
+		}
+
+		r.cfg.Logger.Debug(logPrefix, "Registering the method", fullMethod, "for the endpoint", e.Endpoint)
+		sd.Methods = append(sd.Methods, grpc.MethodDesc{
+			MethodName: md.GetName(),
+			Handler:    r.cfg.HandlerFactory(e, md, proxyStack),
+		})
+	}
+
+	for _, name := range order {
+		s.RegisterService(services[name], struct{}{})
+	}
+}
+
+// RunServer runs the gRPC server until the context is done, stopping it gracefully
+func RunServer(ctx context.Context, cfg ServerConfig, s *grpc.Server) error {
+	l, err := net.Listen("tcp", net.JoinHostPort(cfg.Address, fmt.Sprintf("%d", cfg.Port)))
+	if err != nil {
+		return err
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- s.Serve(l)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		s.GracefulStop()
+		return nil
+	}
+}
+
+// ConfigGetter parses the gRPC router section of the service extra config
+func ConfigGetter(cfg config.ServiceConfig) (ServerConfig, error) {
+	res := ServerConfig{Port: DefaultPort}
+	v, ok := cfg.ExtraConfig[Namespace]
+	if !ok {
+		return res, ErrNoConfig
+	}
+	tmp, ok := v.(map[string]interface{})
+	if !ok {
+		return res, ErrNoConfig
+	}
+
+	if v, ok := tmp["address"].(string); ok {
+		res.Address = v
+	}
+	switch v := tmp["port"].(type) {
+	case int:
+		res.Port = v
+	case int64:
+		res.Port = int(v)
+	case float64:
+		res.Port = int(v)
+	}
+	if res.Port == cfg.Port && res.Address == cfg.Address {
+		return res, fmt.Errorf("grpc router: the port %d is already used by the http router", res.Port)
+	}
+	res.Protosets = stringList(tmp["protosets"])
+	res.ProtoFiles = stringList(tmp["proto_files"])
+	res.ImportPaths = stringList(tmp["import_paths"])
+	if v, ok := tmp["reflection"].(bool); ok {
+		res.Reflection = v
+	}
+	// the TLS section of the service is shared with the http router
+	if v, ok := tmp["tls"].(bool); ok && v && cfg.TLS != nil && !cfg.TLS.IsDisabled {
+		res.TLS = cfg.TLS
+	}
+	return res, nil
+}
+
+func hasMethod(sd *grpc.ServiceDesc, name string) bool {
+	for _, m := range sd.Methods {
+		if m.MethodName == name {
+			return true
+		}
+	}
+	return false
+}
+
+func endpointMethod(e *config.EndpointConfig) (string, bool) {
+	v, ok := e.ExtraConfig[Namespace]
+	if !ok {
+		return "", false
+	}
+	tmp, ok := v.(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	m, ok := tmp["method"].(string)
+	return m, ok && m != ""
+}
+
+func parseTLSConfig(cfg *config.TLS, logger logging.Logger) (*tls.Config, error) {
+	if cfg.PublicKey == "" {
+		return nil, server.ErrPublicKey
+	}
+	if cfg.PrivateKey == "" {
+		return nil, server.ErrPrivateKey
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.PublicKey, cfg.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := server.ParseTLSConfigWithLogger(cfg, logger)
+	tlsConfig.Certificates = []tls.Certificate{cert}
+	return tlsConfig, nil
+}
+
+func stringList(v interface{}) []string {
+	vs, ok := v.([]interface{})
+	if !ok {
+		return nil
+	}
+	res := make([]string, 0, len(vs))
+	for _, v := range vs {
+		if s, ok := v.(string); ok {
+			res = append(res, s)
+		}
+	}
+	return res
+}
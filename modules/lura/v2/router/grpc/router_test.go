@@ -0,0 +1,312 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"api-gateway/v2/modules/lura/v2/config"
+	"api-gateway/v2/modules/lura/v2/logging"
+	"api-gateway/v2/modules/lura/v2/proxy"
+	"api-gateway/v2/modules/lura/v2/transport/http/server"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/jhump/protoreflect/dynamic/grpcdynamic"
+	"github.com/jhump/protoreflect/grpcreflect"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const testUsersProto = `syntax = "proto3";
+
+package users;
+
+message GetUserRequest {
+  string id = 1;
+  repeated string fields = 2;
+  int32 page = 3;
+}
+
+message User {
+  string id = 1;
+  string name = 2;
+  repeated string fields = 3;
+}
+
+service Users {
+  rpc GetUser(GetUserRequest) returns (User);
+  rpc ListUsers(GetUserRequest) returns (User);
+  rpc WatchUser(GetUserRequest) returns (stream User);
+}
+`
+
+// testUsersFiles writes the users proto into a temporary folder and returns the folder
+func testUsersFiles(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "users.proto"), []byte(testUsersProto), 0o600); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	return dir
+}
+
+// testProxyFactory returns proxies echoing the received params, query strings and headers
+var testProxyFactory = proxy.FactoryFunc(func(*config.EndpointConfig) (proxy.Proxy, error) {
+	return func(_ context.Context, r *proxy.Request) (*proxy.Response, error) {
+		switch r.Params["Id"] {
+		case "missing":
+			return nil, testHTTPError{http: 404}
+		case "exists":
+			return nil, status.Error(codes.AlreadyExists, "duplicated")
+		}
+		var name string
+		if vs := r.Headers["X-Name"]; len(vs) > 0 {
+			name = vs[0]
+		}
+		return &proxy.Response{
+			Data: map[string]interface{}{
+				"id":      r.Params["Id"],
+				"name":    name,
+				"fields":  r.Query["fields"],
+				"unknown": true,
+			},
+			IsComplete: true,
+			Metadata:   proxy.Metadata{Headers: map[string][]string{"X-Backend": {"users"}, "Content-Type": {"application/json"}}},
+		}, nil
+	}, nil
+})
+
+type testHTTPError struct {
+	http int
+}
+
+func (e testHTTPError) Error() string   { return "not found" }
+func (e testHTTPError) StatusCode() int { return e.http }
+
+// serveTestRouter runs the router with the received endpoints and returns a connection to it
+func serveTestRouter(t *testing.T, extra map[string]interface{}, endpoints ...*config.EndpointConfig) *grpc.ClientConn {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	addr := make(chan string, 1)
+	runServer := func(ctx context.Context, _ ServerConfig, s *grpc.Server) error {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		addr <- l.Addr().String()
+		go func() {
+			<-ctx.Done()
+			s.Stop()
+		}()
+		return s.Serve(l)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- Serve(ctx, Config{ProxyFactory: testProxyFactory, Logger: logging.NoOp, RunServer: runServer}, config.ServiceConfig{
+			Port:        8080,
+			ExtraConfig: config.ExtraConfig{Namespace: extra},
+			Endpoints:   endpoints,
+		})
+	}()
+
+	var target string
+	select {
+	case target = <-addr:
+	case err := <-errs:
+		t.Fatalf("unexpected error: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the router was not started")
+	}
+
+	cc, err := grpc.Dial(target, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	t.Cleanup(func() { cc.Close() })
+	return cc
+}
+
+func testUsersEndpoint(path, method string) *config.EndpointConfig {
+	return &config.EndpointConfig{
+		Endpoint:      path,
+		Method:        "GET",
+		Timeout:       time.Second,
+		QueryString:   []string{"fields"},
+		HeadersToPass: []string{"X-Name"},
+		ExtraConfig:   config.ExtraConfig{Namespace: map[string]interface{}{"method": method}},
+	}
+}
+
+func TestServe(t *testing.T) {
+	dir := testUsersFiles(t)
+	cc := serveTestRouter(t,
+		map[string]interface{}{
+			"port":         float64(0),
+			"proto_files":  []interface{}{"users.proto"},
+			"import_paths": []interface{}{dir},
+			"reflection":   true,
+		},
+		testUsersEndpoint("/users/:id", "/users.Users/GetUser"),
+		// the methods bound twice, the streaming ones and the unknown ones are ignored
+		testUsersEndpoint("/accounts/:id", "/users.Users/GetUser"),
+		testUsersEndpoint("/watch/:id", "/users.Users/WatchUser"),
+		testUsersEndpoint("/unknown", "/users.Users/Unknown"),
+		&config.EndpointConfig{Endpoint: "/http"},
+	)
+
+	rc := grpcreflect.NewClientAuto(context.Background(), cc)
+	defer rc.Reset()
+	sd, err := rc.ResolveService("users.Users")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	md := sd.FindMethodByName("GetUser")
+	stub := grpcdynamic.NewStub(cc)
+
+	for _, tc := range []struct {
+		name  string
+		id    string
+		code  codes.Code
+		want  string
+		check func(metadata.MD) bool
+	}{
+		{
+			name: "success",
+			id:   "1",
+			want: `{"id":"1","name":"john","fields":["name","email"]}`,
+			check: func(header metadata.MD) bool {
+				return len(header.Get("x-backend")) == 1 && header.Get(server.CompleteResponseHeaderName)[0] == server.HeaderCompleteResponseValue && len(header.Get("content-type")) == 1
+			},
+		},
+		{name: "http error", id: "missing", code: codes.NotFound},
+		{name: "grpc error", id: "exists", code: codes.AlreadyExists},
+	} {
+		in := dynamic.NewMessage(md.GetInputType())
+		in.SetFieldByName("id", tc.id)
+		in.SetFieldByName("fields", []string{"name", "email"})
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "x-name", "john")
+
+		var header metadata.MD
+		out, err := stub.InvokeRpc(ctx, md, in, grpc.Header(&header))
+		if tc.code != codes.OK {
+			if status.Code(err) != tc.code {
+				t.Errorf("%s: unexpected error: %v", tc.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.name, err.Error())
+			continue
+		}
+		res := dynamic.NewMessage(md.GetOutputType())
+		res.ConvertFrom(out)
+		if b, _ := res.MarshalJSON(); string(b) != tc.want {
+			t.Errorf("%s: unexpected response: %s", tc.name, b)
+		}
+		if !tc.check(header) {
+			t.Errorf("%s: unexpected header: %v", tc.name, header)
+		}
+	}
+
+	if _, err := stub.InvokeRpc(context.Background(), sd.FindMethodByName("ListUsers"), dynamic.NewMessage(md.GetInputType())); status.Code(err) != codes.Unimplemented {
+		t.Errorf("unexpected error of the unbound method: %v", err)
+	}
+	stream, err := stub.InvokeRpcServerStream(context.Background(), sd.FindMethodByName("WatchUser"), dynamic.NewMessage(md.GetInputType()))
+	if err == nil {
+		_, err = stream.RecvMsg()
+	}
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("unexpected error of the streaming method: %v", err)
+	}
+}
+
+func TestServe_errors(t *testing.T) {
+	dir := testUsersFiles(t)
+	for name, extra := range map[string]map[string]interface{}{
+		"port of the http router": {"port": float64(8080), "proto_files": []interface{}{"users.proto"}, "import_paths": []interface{}{dir}},
+		"missing descriptors":     {"port": float64(0)},
+		"unknown proto file":      {"port": float64(0), "proto_files": []interface{}{"unknown.proto"}, "import_paths": []interface{}{dir}},
+		"unknown protoset":        {"port": float64(0), "protosets": []interface{}{filepath.Join(dir, "unknown.pb")}},
+		"invalid protoset":        {"port": float64(0), "protosets": []interface{}{filepath.Join(dir, "users.proto")}},
+	} {
+		err := Serve(context.Background(), Config{ProxyFactory: testProxyFactory, Logger: logging.NoOp}, config.ServiceConfig{
+			Port:        8080,
+			ExtraConfig: config.ExtraConfig{Namespace: extra},
+		})
+		if err == nil {
+			t.Errorf("%s: error expected", name)
+		}
+	}
+
+	if err := Serve(context.Background(), Config{Logger: logging.NoOp}, config.ServiceConfig{}); !errors.Is(err, ErrNoConfig) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestConfigGetter(t *testing.T) {
+	tlsCfg := &config.TLS{PublicKey: "cert.pem", PrivateKey: "key.pem"}
+	cfg, err := ConfigGetter(config.ServiceConfig{
+		Port: 8080,
+		TLS:  tlsCfg,
+		ExtraConfig: config.ExtraConfig{Namespace: map[string]interface{}{
+			"address":      "127.0.0.1",
+			"port":         float64(8081),
+			"protosets":    []interface{}{"users.pb", 1},
+			"proto_files":  []interface{}{"users.proto"},
+			"import_paths": []interface{}{"./protos"},
+			"reflection":   true,
+			"tls":          true,
+		}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if cfg.Address != "127.0.0.1" || cfg.Port != 8081 || !cfg.Reflection || cfg.TLS != tlsCfg {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+	if len(cfg.Protosets) != 1 || len(cfg.ProtoFiles) != 1 || len(cfg.ImportPaths) != 1 {
+		t.Errorf("unexpected descriptors: %+v", cfg)
+	}
+
+	cfg, err = ConfigGetter(config.ServiceConfig{ExtraConfig: config.ExtraConfig{Namespace: map[string]interface{}{}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if cfg.Port != DefaultPort || cfg.TLS != nil {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+
+	if _, err := ConfigGetter(config.ServiceConfig{ExtraConfig: config.ExtraConfig{Namespace: true}}); err != ErrNoConfig {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestFindMethod(t *testing.T) {
+	dir := testUsersFiles(t)
+	descriptors, err := LoadDescriptors(ServerConfig{ProtoFiles: []string{"users.proto"}, ImportPaths: []string{dir}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	var md *desc.MethodDescriptor
+	if md, err = findMethod(descriptors, "/users.Users/GetUser"); err != nil || md.GetName() != "GetUser" {
+		t.Errorf("unexpected result: %v %v", md, err)
+	}
+	for _, name := range []string{"users.Users", "/users.Users/GetUser/v2", "/users.Users/Unknown", "/users.Unknown/GetUser"} {
+		if _, err := findMethod(descriptors, name); err == nil {
+			t.Errorf("%s: error expected", name)
+		}
+	}
+}
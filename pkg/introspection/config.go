@@ -0,0 +1,118 @@
+package introspection
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"api-gateway/v2/modules/lura/v2/config"
+	"api-gateway/v2/pkg/jwtvalidator"
+)
+
+const Namespace = "github_com/davron112/introspection"
+
+// The ways to authenticate the gateway against the introspection endpoint
+const (
+	AuthMethodBasic = "client_secret_basic"
+	AuthMethodPost  = "client_secret_post"
+)
+
+type Config struct {
+	// Endpoint is the URL of the RFC 7662 introspection endpoint
+	Endpoint     string `json:"endpoint"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	AuthMethod   string `json:"auth_method"` // default value is "client_secret_basic"
+	// TokenTypeHint is sent to the introspection endpoint to speed up the token lookup
+	TokenTypeHint string `json:"token_type_hint"` // default value is "access_token"
+	// Timeout bounds the calls to the introspection endpoint
+	Timeout string `json:"timeout"` // default value is "5s"
+	// CacheTTL is the lifetime of the active results. It is bounded by the exp of the token
+	CacheTTL string `json:"cache_ttl"` // default value is "1m"
+	// NegativeCacheTTL is the lifetime of the inactive results
+	NegativeCacheTTL string `json:"negative_cache_ttl"` // default value is "10s"
+
+	AccessTokenHeaderKey string                     `json:"access_token_header_key"` // default value is "Authorization"
+	TokenSources         []jwtvalidator.TokenSource `json:"token_sources"`
+	Roles                []string                   `json:"roles"`
+	// RolesClaim is the path of the claim with the roles. The space-delimited strings, as the
+	// scope claim, are split into several roles
+	RolesClaim      string                     `json:"roles_claim"`      // default value is "scope"
+	PropagateClaims []jwtvalidator.ClaimHeader `json:"propagate_claims"` // default value maps sub and jti to X-User-Id and X-Session-Id
+
+	timeout          time.Duration
+	cacheTTL         time.Duration
+	negativeCacheTTL time.Duration
+}
+
+var ErrNoConfig = errors.New("no introspection config")
+
+func ParseConfig(extraConfig config.ExtraConfig) (*Config, error) {
+	res := Config{}
+	e, ok := extraConfig[Namespace]
+	if !ok {
+		return nil, ErrNoConfig
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &res); err != nil {
+		return nil, err
+	}
+
+	if res.Endpoint == "" {
+		return nil, errors.New("the introspection endpoint is required")
+	}
+	switch res.AuthMethod {
+	case "":
+		res.AuthMethod = AuthMethodBasic
+	case AuthMethodBasic, AuthMethodPost:
+	default:
+		return nil, fmt.Errorf("unsupported auth_method %q", res.AuthMethod)
+	}
+	if res.TokenTypeHint == "" {
+		res.TokenTypeHint = "access_token"
+	}
+	if res.RolesClaim == "" {
+		res.RolesClaim = "scope"
+	}
+
+	if res.timeout, err = parseDuration(res.Timeout, 5*time.Second); err != nil {
+		return nil, fmt.Errorf("invalid timeout: %w", err)
+	}
+	if res.cacheTTL, err = parseDuration(res.CacheTTL, time.Minute); err != nil {
+		return nil, fmt.Errorf("invalid cache_ttl: %w", err)
+	}
+	if res.negativeCacheTTL, err = parseDuration(res.NegativeCacheTTL, 10*time.Second); err != nil {
+		return nil, fmt.Errorf("invalid negative_cache_ttl: %w", err)
+	}
+
+	if len(res.TokenSources) == 0 {
+		res.TokenSources = jwtvalidator.DefaultTokenSources(res.AccessTokenHeaderKey)
+	}
+	for _, src := range res.TokenSources {
+		if err := src.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	if res.PropagateClaims, err = jwtvalidator.NormalizeClaimHeaders(res.PropagateClaims); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func parseDuration(v string, d time.Duration) (time.Duration, error) {
+	if v == "" {
+		return d, nil
+	}
+	res, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, err
+	}
+	if res <= 0 {
+		return 0, errors.New("the duration must be positive")
+	}
+	return res, nil
+}
@@ -0,0 +1,39 @@
+package introspection
+
+import (
+	"testing"
+
+	"api-gateway/v2/modules/lura/v2/config"
+	"api-gateway/v2/pkg/jwtvalidator"
+)
+
+func TestParseConfig(t *testing.T) {
+	for name, cfg := range map[string]map[string]interface{}{
+		"missing endpoint":     {},
+		"unknown auth method":  {"endpoint": "http://localhost", "auth_method": "tls_client_auth"},
+		"invalid timeout":      {"endpoint": "http://localhost", "timeout": "soon"},
+		"negative cache ttl":   {"endpoint": "http://localhost", "cache_ttl": "-1m"},
+		"invalid negative ttl": {"endpoint": "http://localhost", "negative_cache_ttl": "0s"},
+		"unknown token source": {"endpoint": "http://localhost", "token_sources": []map[string]string{{"type": "body", "name": "token"}}},
+		"invalid propagation":  {"endpoint": "http://localhost", "propagate_claims": []map[string]string{{"claim": "sub"}}},
+	} {
+		if _, err := ParseConfig(config.ExtraConfig{Namespace: cfg}); err == nil {
+			t.Errorf("%s: error expected", name)
+		}
+	}
+
+	if _, err := ParseConfig(config.ExtraConfig{}); err != ErrNoConfig {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	cfg, err := ParseConfig(config.ExtraConfig{Namespace: map[string]interface{}{"endpoint": "http://localhost"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if cfg.AuthMethod != AuthMethodBasic || cfg.TokenTypeHint != "access_token" || cfg.RolesClaim != "scope" {
+		t.Errorf("unexpected defaults: %+v", cfg)
+	}
+	if len(cfg.TokenSources) != 1 || cfg.TokenSources[0].Type != jwtvalidator.TokenSourceHeader || cfg.TokenSources[0].Name != "Authorization" {
+		t.Errorf("unexpected token sources: %+v", cfg.TokenSources)
+	}
+}
@@ -0,0 +1,169 @@
+package introspection
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Result is the response of the introspection endpoint
+type Result struct {
+	Active bool
+	Claims map[string]interface{}
+}
+
+// Introspector resolves the opaque tokens against the introspection endpoint, caching the
+// results by the hash of the token so the tokens are never kept in memory
+type Introspector struct {
+	cfg    *Config
+	client *http.Client
+	group  singleflight.Group
+
+	mu        sync.Mutex
+	cache     map[string]cacheEntry
+	lastPurge time.Time
+}
+
+type cacheEntry struct {
+	result     Result
+	expiration time.Time
+}
+
+func NewIntrospector(cfg *Config) *Introspector {
+	return &Introspector{
+		cfg:       cfg,
+		client:    &http.Client{Timeout: cfg.timeout},
+		cache:     map[string]cacheEntry{},
+		lastPurge: time.Now(),
+	}
+}
+
+// Introspect returns the cached result of the token or, if there is none, asks the
+// introspection endpoint. The concurrent calls for the same token share the request.
+func (i *Introspector) Introspect(ctx context.Context, token string) (Result, error) {
+	key := tokenHash(token)
+
+	i.mu.Lock()
+	entry, ok := i.cache[key]
+	i.mu.Unlock()
+	if ok && time.Now().Before(entry.expiration) {
+		return entry.result, nil
+	}
+
+	v, err, _ := i.group.Do(key, func() (interface{}, error) {
+		// the request is shared, so it must not be canceled by the first caller leaving
+		res, err := i.fetch(context.WithoutCancel(ctx), token)
+		if err != nil {
+			return Result{}, err
+		}
+		i.store(key, res)
+		return res, nil
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	return v.(Result), nil
+}
+
+func (i *Introspector) fetch(ctx context.Context, token string) (Result, error) {
+	form := url.Values{
+		"token":           {token},
+		"token_type_hint": {i.cfg.TokenTypeHint},
+	}
+	if i.cfg.AuthMethod == AuthMethodPost {
+		form.Set("client_id", i.cfg.ClientID)
+		form.Set("client_secret", i.cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.cfg.Endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Result{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if i.cfg.AuthMethod == AuthMethodBasic && i.cfg.ClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(i.cfg.ClientID), url.QueryEscape(i.cfg.ClientSecret))
+	}
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return Result{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return Result{}, fmt.Errorf("introspection endpoint returned the status code %d", resp.StatusCode)
+	}
+
+	claims := map[string]interface{}{}
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return Result{}, fmt.Errorf("decoding the introspection response: %w", err)
+	}
+	active, _ := claims["active"].(bool)
+	if active {
+		if exp, ok := expiration(claims); ok && !time.Now().Before(exp) {
+			active = false
+		}
+	}
+	if !active {
+		// the inactive responses must not carry any other information about the token
+		return Result{Claims: map[string]interface{}{"active": false}}, nil
+	}
+	return Result{Active: true, Claims: claims}, nil
+}
+
+// store caches the result. The active results never outlive the exp of the token.
+func (i *Introspector) store(key string, res Result) {
+	now := time.Now()
+	ttl := i.cfg.negativeCacheTTL
+	if res.Active {
+		ttl = i.cfg.cacheTTL
+		if exp, ok := expiration(res.Claims); ok && exp.Sub(now) < ttl {
+			ttl = exp.Sub(now)
+		}
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.cache[key] = cacheEntry{result: res, expiration: now.Add(ttl)}
+
+	if now.Sub(i.lastPurge) < i.cfg.cacheTTL {
+		return
+	}
+	for k, e := range i.cache {
+		if !now.Before(e.expiration) {
+			delete(i.cache, k)
+		}
+	}
+	i.lastPurge = now
+}
+
+func expiration(claims map[string]interface{}) (time.Time, bool) {
+	switch v := claims["exp"].(type) {
+	case float64:
+		return time.Unix(int64(v), 0), true
+	case json.Number:
+		n, err := v.Int64()
+		return time.Unix(n, 0), err == nil
+	}
+	return time.Time{}, false
+}
+
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
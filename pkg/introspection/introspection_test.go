@@ -0,0 +1,290 @@
+package introspection
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"api-gateway/v2/modules/lura/v2/config"
+)
+
+// testEndpoint is an introspection endpoint answering with the claims registered for each token
+type testEndpoint struct {
+	url    string
+	calls  int64
+	tokens map[string]map[string]interface{}
+	// check validates the request, returning the status code to send when it is not accepted
+	check func(*http.Request) int
+}
+
+func newTestEndpoint(t *testing.T, tokens map[string]map[string]interface{}) *testEndpoint {
+	t.Helper()
+	e := &testEndpoint{tokens: tokens}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&e.calls, 1)
+		if e.check != nil {
+			if status := e.check(r); status != http.StatusOK {
+				w.WriteHeader(status)
+				return
+			}
+		}
+		claims, ok := e.tokens[r.PostFormValue("token")]
+		if !ok {
+			claims = map[string]interface{}{"active": false}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(claims)
+	}))
+	t.Cleanup(ts.Close)
+	e.url = ts.URL
+	return e
+}
+
+func (e *testEndpoint) Calls() int64 {
+	return atomic.LoadInt64(&e.calls)
+}
+
+func newTestConfig(t *testing.T, cfg map[string]interface{}) *Config {
+	t.Helper()
+	res, err := ParseConfig(config.ExtraConfig{Namespace: cfg})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	return res
+}
+
+func TestIntrospector_Introspect(t *testing.T) {
+	exp := float64(time.Now().Add(time.Hour).Unix())
+	endpoint := newTestEndpoint(t, map[string]map[string]interface{}{
+		"active":  {"active": true, "sub": "user-1", "exp": exp},
+		"expired": {"active": true, "sub": "user-1", "exp": float64(time.Now().Add(-time.Minute).Unix())},
+		"leaky":   {"active": false, "sub": "user-1"},
+	})
+
+	for _, tc := range []struct {
+		token  string
+		active bool
+		claims map[string]interface{}
+	}{
+		{token: "active", active: true, claims: map[string]interface{}{"active": true, "sub": "user-1", "exp": exp}},
+		{token: "expired", claims: map[string]interface{}{"active": false}},
+		{token: "leaky", claims: map[string]interface{}{"active": false}},
+		{token: "unknown", claims: map[string]interface{}{"active": false}},
+	} {
+		i := NewIntrospector(newTestConfig(t, map[string]interface{}{"endpoint": endpoint.url}))
+		res, err := i.Introspect(context.Background(), tc.token)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.token, err.Error())
+			continue
+		}
+		if res.Active != tc.active {
+			t.Errorf("%s: unexpected active flag: %v", tc.token, res.Active)
+		}
+		b1, _ := json.Marshal(res.Claims)
+		b2, _ := json.Marshal(tc.claims)
+		if string(b1) != string(b2) {
+			t.Errorf("%s: unexpected claims. have: %s, want: %s", tc.token, b1, b2)
+		}
+	}
+}
+
+func TestIntrospector_Introspect_cache(t *testing.T) {
+	endpoint := newTestEndpoint(t, map[string]map[string]interface{}{
+		"active": {"active": true, "sub": "user-1"},
+	})
+	i := NewIntrospector(newTestConfig(t, map[string]interface{}{"endpoint": endpoint.url}))
+
+	for _, token := range []string{"active", "active", "inactive", "inactive"} {
+		if _, err := i.Introspect(context.Background(), token); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+		}
+	}
+	if calls := endpoint.Calls(); calls != 2 {
+		t.Errorf("unexpected number of introspection calls: %d", calls)
+	}
+
+	i.mu.Lock()
+	for k := range i.cache {
+		if k == "active" || k == "inactive" {
+			t.Error("the cache is keyed by the raw token")
+		}
+	}
+	i.mu.Unlock()
+}
+
+func TestIntrospector_Introspect_concurrent(t *testing.T) {
+	endpoint := newTestEndpoint(t, map[string]map[string]interface{}{
+		"active": {"active": true, "sub": "user-1"},
+	})
+	release := make(chan struct{})
+	endpoint.check = func(*http.Request) int {
+		<-release
+		return http.StatusOK
+	}
+	i := NewIntrospector(newTestConfig(t, map[string]interface{}{"endpoint": endpoint.url}))
+
+	var wg sync.WaitGroup
+	for n := 0; n < 10; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if res, err := i.Introspect(context.Background(), "active"); err != nil || !res.Active {
+				t.Errorf("unexpected result: %v %v", res, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls := endpoint.Calls(); calls != 1 {
+		t.Errorf("unexpected number of introspection calls: %d", calls)
+	}
+}
+
+func TestIntrospector_Introspect_authMethods(t *testing.T) {
+	for _, tc := range []struct {
+		method string
+		check  func(*http.Request) bool
+	}{
+		{
+			method: AuthMethodBasic,
+			check: func(r *http.Request) bool {
+				user, pass, ok := r.BasicAuth()
+				return ok && user == "gateway%3A1" && pass == "s3cr%2Ft" && r.PostFormValue("client_secret") == ""
+			},
+		},
+		{
+			method: AuthMethodPost,
+			check: func(r *http.Request) bool {
+				_, _, ok := r.BasicAuth()
+				return !ok && r.PostFormValue("client_id") == "gateway:1" && r.PostFormValue("client_secret") == "s3cr/t"
+			},
+		},
+	} {
+		endpoint := newTestEndpoint(t, map[string]map[string]interface{}{
+			"active": {"active": true},
+		})
+		endpoint.check = func(r *http.Request) int {
+			if r.Method != http.MethodPost || r.PostFormValue("token_type_hint") != "access_token" || !tc.check(r) {
+				return http.StatusUnauthorized
+			}
+			return http.StatusOK
+		}
+		i := NewIntrospector(newTestConfig(t, map[string]interface{}{
+			"endpoint":      endpoint.url,
+			"client_id":     "gateway:1",
+			"client_secret": "s3cr/t",
+			"auth_method":   tc.method,
+		}))
+		if res, err := i.Introspect(context.Background(), "active"); err != nil || !res.Active {
+			t.Errorf("%s: unexpected result: %v %v", tc.method, res, err)
+		}
+	}
+}
+
+func TestIntrospector_Introspect_errors(t *testing.T) {
+	for name, handler := range map[string]http.HandlerFunc{
+		"unexpected status": func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		},
+		"invalid body": func(w http.ResponseWriter, _ *http.Request) {
+			w.Write([]byte("active"))
+		},
+		"timeout": func(w http.ResponseWriter, _ *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+			w.Write([]byte(`{"active":true}`))
+		},
+	} {
+		var calls int64
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&calls, 1)
+			handler(w, r)
+		}))
+		i := NewIntrospector(newTestConfig(t, map[string]interface{}{"endpoint": ts.URL, "timeout": "50ms"}))
+		for n := 0; n < 2; n++ {
+			if _, err := i.Introspect(context.Background(), "active"); err == nil {
+				t.Errorf("%s: error expected", name)
+			}
+		}
+		// the failures are not cached
+		if n := atomic.LoadInt64(&calls); n != 2 {
+			t.Errorf("%s: unexpected number of introspection calls: %d", name, n)
+		}
+		ts.Close()
+	}
+}
+
+func TestIntrospector_store(t *testing.T) {
+	now := time.Now()
+	for _, tc := range []struct {
+		name   string
+		result Result
+		ttl    time.Duration
+	}{
+		{
+			name:   "active without exp",
+			result: Result{Active: true, Claims: map[string]interface{}{"active": true}},
+			ttl:    time.Minute,
+		},
+		{
+			name:   "active with a later exp",
+			result: Result{Active: true, Claims: map[string]interface{}{"active": true, "exp": float64(now.Add(time.Hour).Unix())}},
+			ttl:    time.Minute,
+		},
+		{
+			name:   "active with a sooner exp",
+			result: Result{Active: true, Claims: map[string]interface{}{"active": true, "exp": json.Number(strconv.FormatInt(now.Add(20*time.Second).Unix(), 10))}},
+			ttl:    20 * time.Second,
+		},
+		{
+			name:   "active with a past exp",
+			result: Result{Active: true, Claims: map[string]interface{}{"active": true, "exp": float64(now.Add(-time.Second).Unix())}},
+		},
+		{
+			name:   "inactive",
+			result: Result{Claims: map[string]interface{}{"active": false}},
+			ttl:    10 * time.Second,
+		},
+	} {
+		i := NewIntrospector(newTestConfig(t, map[string]interface{}{"endpoint": "http://localhost"}))
+		i.store("key", tc.result)
+
+		entry, ok := i.cache["key"]
+		if tc.ttl == 0 {
+			if ok {
+				t.Errorf("%s: the result was cached", tc.name)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("%s: the result was not cached", tc.name)
+			continue
+		}
+		// the exp claims have a precision of seconds
+		if ttl := entry.expiration.Sub(now); ttl > tc.ttl+time.Second || ttl < tc.ttl-time.Second {
+			t.Errorf("%s: unexpected ttl. have: %s, want: %s", tc.name, ttl, tc.ttl)
+		}
+	}
+}
+
+func TestIntrospector_store_purge(t *testing.T) {
+	i := NewIntrospector(newTestConfig(t, map[string]interface{}{"endpoint": "http://localhost", "cache_ttl": "1s"}))
+	i.cache["expired"] = cacheEntry{expiration: time.Now().Add(-time.Second)}
+	i.lastPurge = time.Now().Add(-time.Minute)
+
+	i.store("key", Result{Active: true, Claims: map[string]interface{}{"active": true}})
+
+	if _, ok := i.cache["expired"]; ok {
+		t.Error("the expired entries were not purged")
+	}
+	if _, ok := i.cache["key"]; !ok {
+		t.Error("the result was not cached")
+	}
+}
@@ -0,0 +1,136 @@
+package introspection
+
+import (
+	"net/http"
+	"strings"
+
+	jose "api-gateway/v2/modules/krakend-jose/v2"
+	"api-gateway/v2/modules/lura/v2/config"
+	"api-gateway/v2/modules/lura/v2/logging"
+	"api-gateway/v2/modules/lura/v2/proxy"
+	krakendgin "api-gateway/v2/modules/lura/v2/router/gin"
+	"api-gateway/v2/pkg/jwtvalidator"
+	"github.com/gin-gonic/gin"
+)
+
+// The codes of the introspection errors, sent in the JSON error body as the JWT validator does
+const (
+	ErrCodeInactiveToken       = "inactive_token"
+	ErrCodeIntrospectionFailed = "introspection_failed"
+	ErrCodeInvalidConfig       = "invalid_introspection_config"
+)
+
+// New returns a HandlerFactory validating the opaque tokens of the endpoints with the
+// introspection config. The introspected claims go through the rejecters, so the CEL JWT
+// expressions apply to them, and are used for the role checks and the header propagation.
+func New(hf krakendgin.HandlerFactory, l logging.Logger, rejecterF jose.RejecterFactory) krakendgin.HandlerFactory {
+	if rejecterF == nil {
+		rejecterF = new(jose.NopRejecterFactory)
+	}
+	return func(cfg *config.EndpointConfig, p proxy.Proxy) gin.HandlerFunc {
+		next := hf(cfg, p)
+		logPrefix := "[ENDPOINT: " + cfg.Endpoint + "][Introspection]"
+
+		introspectionCfg, err := ParseConfig(cfg.ExtraConfig)
+		if err == ErrNoConfig {
+			return next
+		}
+		if err != nil {
+			// fail closed, so the endpoint is never served without the introspection
+			l.Error(logPrefix, err.Error())
+			return erroredHandler
+		}
+
+		l.Debug(logPrefix, "The token introspection has been registered successfully")
+		return handler(introspectionCfg, NewIntrospector(introspectionCfg), rejecterF.New(l, cfg), next, l, logPrefix)
+	}
+}
+
+// erroredHandler rejects the requests of the endpoints with an invalid introspection config
+func erroredHandler(c *gin.Context) {
+	(&jwtvalidator.ValidationError{
+		Status: http.StatusInternalServerError,
+		Code:   ErrCodeInvalidConfig,
+		Msg:    "the token introspection is misconfigured",
+	}).Abort(c)
+}
+
+func handler(cfg *Config, introspector *Introspector, rejecter jose.Rejecter, next gin.HandlerFunc, l logging.Logger, logPrefix string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, err := validate(cfg, introspector, rejecter, c.Request, l, logPrefix)
+		if err != nil {
+			err.Abort(c)
+			return
+		}
+		c.Set(jose.ClaimsContextKey, claims)
+
+		next(c)
+	}
+}
+
+// validate returns the claims of the introspected token if the request is accepted
+func validate(cfg *Config, introspector *Introspector, rejecter jose.Rejecter, req *http.Request, l logging.Logger, logPrefix string) (map[string]interface{}, *jwtvalidator.ValidationError) {
+	if verr := jwtvalidator.RejectSpoofedHeaders(cfg.PropagateClaims, req.Header); verr != nil {
+		return nil, verr
+	}
+
+	token, verr := jwtvalidator.ExtractToken(cfg.TokenSources, req)
+	if verr != nil {
+		return nil, verr
+	}
+
+	res, err := introspector.Introspect(req.Context(), token)
+	if err != nil {
+		l.Error(logPrefix, "Introspecting the token:", err.Error())
+		return nil, &jwtvalidator.ValidationError{
+			Status: http.StatusServiceUnavailable,
+			Code:   ErrCodeIntrospectionFailed,
+			Msg:    "Service Unavailable: The token can not be introspected.",
+		}
+	}
+	if !res.Active {
+		return nil, &jwtvalidator.ValidationError{
+			Status: http.StatusUnauthorized,
+			Code:   ErrCodeInactiveToken,
+			Msg:    "Unauthorized: The token is not active.",
+		}
+	}
+
+	if rejecter.Reject(res.Claims) {
+		return nil, &jwtvalidator.ValidationError{
+			Status: http.StatusUnauthorized,
+			Code:   jwtvalidator.ErrCodeTokenRejected,
+			Msg:    "Unauthorized: The token has been rejected.",
+		}
+	}
+
+	if len(cfg.Roles) > 0 && !hasAnyRole(cfg, res.Claims) {
+		return nil, &jwtvalidator.ValidationError{
+			Status: http.StatusForbidden,
+			Code:   jwtvalidator.ErrCodeInsufficientRole,
+			Msg:    "Forbidden: You do not have permission to access this resource with your current role.",
+		}
+	}
+
+	jwtvalidator.PropagateClaims(cfg.PropagateClaims, res.Claims, req.Header)
+	return res.Claims, nil
+}
+
+func hasAnyRole(cfg *Config, claims map[string]interface{}) bool {
+	v, ok := jwtvalidator.ClaimValue(claims, cfg.RolesClaim)
+	if !ok {
+		return false
+	}
+	var roles []string
+	for _, r := range jwtvalidator.ClaimValues(v) {
+		roles = append(roles, strings.Fields(r)...)
+	}
+	for _, role := range roles {
+		for _, expected := range cfg.Roles {
+			if role == expected {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,198 @@
+package introspection
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	jose "api-gateway/v2/modules/krakend-jose/v2"
+	"api-gateway/v2/modules/lura/v2/config"
+	"api-gateway/v2/modules/lura/v2/logging"
+	"api-gateway/v2/modules/lura/v2/proxy"
+	"api-gateway/v2/pkg/jwtvalidator"
+	"github.com/gin-gonic/gin"
+)
+
+func TestNew(t *testing.T) {
+	endpoint := newTestEndpoint(t, map[string]map[string]interface{}{
+		"reader":  {"active": true, "sub": "user-1", "scope": "orders:read profile"},
+		"admin":   {"active": true, "sub": "user-1", "roles": []interface{}{"admin"}},
+		"revoked": {"active": true, "sub": "revoked", "scope": "orders:read"},
+	})
+
+	for _, tc := range []struct {
+		name    string
+		cfg     map[string]interface{}
+		url     string
+		headers map[string]string
+		cookie  *http.Cookie
+		status  int
+		code    string
+	}{
+		{
+			name:    "active token",
+			headers: map[string]string{"Authorization": "Bearer reader"},
+			status:  http.StatusOK,
+		},
+		{
+			name:   "missing token",
+			status: http.StatusUnauthorized,
+			code:   jwtvalidator.ErrCodeMissingToken,
+		},
+		{
+			name:    "unknown scheme",
+			headers: map[string]string{"Authorization": "Basic reader"},
+			status:  http.StatusUnauthorized,
+			code:    jwtvalidator.ErrCodeMissingToken,
+		},
+		{
+			name:    "inactive token",
+			headers: map[string]string{"Authorization": "Bearer unknown"},
+			status:  http.StatusUnauthorized,
+			code:    ErrCodeInactiveToken,
+		},
+		{
+			name:    "rejected token",
+			headers: map[string]string{"Authorization": "Bearer revoked"},
+			status:  http.StatusUnauthorized,
+			code:    jwtvalidator.ErrCodeTokenRejected,
+		},
+		{
+			name:    "spoofed claim header",
+			headers: map[string]string{"Authorization": "Bearer reader", "X-User-Id": "admin"},
+			status:  http.StatusBadRequest,
+			code:    jwtvalidator.ErrCodeInvalidHeaders,
+		},
+		{
+			name:    "scope role",
+			cfg:     map[string]interface{}{"roles": []string{"orders:read"}},
+			headers: map[string]string{"Authorization": "Bearer reader"},
+			status:  http.StatusOK,
+		},
+		{
+			name:    "missing role",
+			cfg:     map[string]interface{}{"roles": []string{"orders:write"}},
+			headers: map[string]string{"Authorization": "Bearer reader"},
+			status:  http.StatusForbidden,
+			code:    jwtvalidator.ErrCodeInsufficientRole,
+		},
+		{
+			name:    "missing roles claim",
+			cfg:     map[string]interface{}{"roles": []string{"admin"}, "roles_claim": "roles"},
+			headers: map[string]string{"Authorization": "Bearer reader"},
+			status:  http.StatusForbidden,
+			code:    jwtvalidator.ErrCodeInsufficientRole,
+		},
+		{
+			name:    "roles claim",
+			cfg:     map[string]interface{}{"roles": []string{"admin"}, "roles_claim": "roles"},
+			headers: map[string]string{"Authorization": "Bearer admin"},
+			status:  http.StatusOK,
+		},
+		{
+			name:   "cookie token",
+			cfg:    map[string]interface{}{"token_sources": []map[string]string{{"type": "cookie", "name": "session"}}},
+			cookie: &http.Cookie{Name: "session", Value: "reader"},
+			status: http.StatusOK,
+		},
+		{
+			name:   "query token",
+			cfg:    map[string]interface{}{"token_sources": []map[string]string{{"type": "query", "name": "access_token"}}},
+			url:    "/orders/1?access_token=reader",
+			status: http.StatusOK,
+		},
+		{
+			name:    "unreachable endpoint",
+			cfg:     map[string]interface{}{"endpoint": "http://127.0.0.1:0", "timeout": "100ms"},
+			url:     "/orders/1",
+			status:  http.StatusServiceUnavailable,
+			headers: map[string]string{"Authorization": "Bearer reader"},
+			code:    ErrCodeIntrospectionFailed,
+		},
+		{
+			name:    "invalid config",
+			cfg:     map[string]interface{}{"auth_method": "private_key_jwt"},
+			headers: map[string]string{"Authorization": "Bearer reader"},
+			status:  http.StatusInternalServerError,
+			code:    ErrCodeInvalidConfig,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := map[string]interface{}{"endpoint": endpoint.url}
+			for k, v := range tc.cfg {
+				cfg[k] = v
+			}
+			url := tc.url
+			if url == "" {
+				url = "/orders/1"
+			}
+			req, _ := http.NewRequest(http.MethodGet, url, http.NoBody)
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			if tc.cookie != nil {
+				req.AddCookie(tc.cookie)
+			}
+
+			w := serveIntrospection(cfg, req)
+			if w.Code != tc.status {
+				t.Errorf("unexpected status code: %d. body: %s", w.Code, w.Body.String())
+			}
+			if tc.status == http.StatusOK {
+				if body := w.Body.String(); body != "user-1" {
+					t.Errorf("unexpected propagated subject: %q", body)
+				}
+				return
+			}
+			var body struct {
+				Error struct {
+					Code string `json:"code"`
+				} `json:"error"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &body)
+			if body.Error.Code != tc.code {
+				t.Errorf("unexpected error code. have: %q, want: %q", body.Error.Code, tc.code)
+			}
+		})
+	}
+}
+
+func TestNew_noConfig(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "/orders/1", http.NoBody)
+	w := httptest.NewRecorder()
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/orders/:id", New(testHandlerFactory, logging.NoOp, nil)(&config.EndpointConfig{Endpoint: "/orders/:id"}, proxy.NoopProxy))
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected status code: %d", w.Code)
+	}
+}
+
+func serveIntrospection(cfg map[string]interface{}, req *http.Request) *httptest.ResponseRecorder {
+	hf := New(testHandlerFactory, logging.NoOp, testRejecterFactory)
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/orders/:id", hf(&config.EndpointConfig{
+		Endpoint:    "/orders/:id",
+		ExtraConfig: config.ExtraConfig{Namespace: cfg},
+	}, proxy.NoopProxy))
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	return w
+}
+
+func testHandlerFactory(*config.EndpointConfig, proxy.Proxy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.String(http.StatusOK, c.Request.Header.Get("X-User-Id"))
+	}
+}
+
+// testRejecterFactory rejects the tokens of the revoked subject
+var testRejecterFactory = jose.RejecterFactoryFunc(func(logging.Logger, *config.EndpointConfig) jose.Rejecter {
+	return jose.RejecterFunc(func(claims map[string]interface{}) bool {
+		return claims["sub"] == "revoked"
+	})
+})
@@ -0,0 +1,123 @@
+package jwtvalidator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	krakendmetrics "api-gateway/v2/modules/krakend-metrics/v2"
+	"api-gateway/v2/modules/lura/v2/logging"
+	gometrics "github.com/rcrowley/go-metrics"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+// The outcomes of the auth decisions
+const (
+	OutcomeAccepted  = "accepted"
+	OutcomeRejected  = "rejected"
+	OutcomeForbidden = "forbidden"
+)
+
+var (
+	// AuthDecisions counts the auth decisions taken by the validator
+	AuthDecisions = stats.Int64("krakend.io/auth/decisions", "Number of auth decisions", stats.UnitDimensionless)
+
+	KeyAuthEndpoint = tag.MustNewKey("auth_endpoint")
+	KeyAuthOutcome  = tag.MustNewKey("auth_outcome")
+	KeyAuthReason   = tag.MustNewKey("auth_reason")
+
+	// AuthDecisionsView exports the number of auth decisions by endpoint, outcome and reason
+	AuthDecisionsView = &view.View{
+		Name:        "krakend.io/auth/decisions",
+		Description: "Number of auth decisions by endpoint, outcome and reason",
+		Measure:     AuthDecisions,
+		TagKeys:     []tag.Key{KeyAuthEndpoint, KeyAuthOutcome, KeyAuthReason},
+		Aggregation: view.Count(),
+	}
+
+	registerViewOnce = new(sync.Once)
+)
+
+// auditor emits every auth decision as a log event and updates the counters of the decisions
+type auditor struct {
+	logger   logging.Logger
+	prefix   string
+	endpoint string
+	registry gometrics.Registry
+}
+
+func newAuditor(l logging.Logger, prefix, endpoint string, m *krakendmetrics.Metrics) *auditor {
+	registerViewOnce.Do(func() {
+		if err := view.Register(AuthDecisionsView); err != nil {
+			l.Warning(logPrefix, "Registering the opencensus view:", err.Error())
+		}
+	})
+	a := &auditor{logger: l, prefix: prefix, endpoint: endpoint}
+	if m != nil && m.Registry != nil {
+		a.registry = *m.Registry
+	}
+	return a
+}
+
+// authEvent collects the details of a validation. It never holds the token, just a fingerprint.
+type authEvent struct {
+	start time.Time
+	// subject is the subject of a token with a verified signature
+	subject string
+	// unverifiedSubject is the subject claimed by a token rejected before verifying its signature
+	unverifiedSubject string
+	kid               string
+	token             string
+	detail            string
+	// claims are the claims of the accepted token
+	claims map[string]interface{}
+}
+
+func newAuthEvent() *authEvent {
+	return &authEvent{start: time.Now()}
+}
+
+func (e *authEvent) setToken(token string) {
+	sum := sha256.Sum256([]byte(token))
+	e.token = "sha256:" + hex.EncodeToString(sum[:6])
+}
+
+func (a *auditor) record(ctx context.Context, ev *authEvent, verr *ValidationError) {
+	outcome, reason := OutcomeAccepted, "ok"
+	if verr != nil {
+		reason = verr.Code
+		outcome = OutcomeRejected
+		if verr.Status == http.StatusForbidden {
+			outcome = OutcomeForbidden
+		}
+	}
+
+	msg := fmt.Sprintf("auth_decision endpoint=%q subject=%q kid=%q token=%q outcome=%s reason=%s latency=%s",
+		a.endpoint, ev.subject, ev.kid, ev.token, outcome, reason, time.Since(ev.start))
+	if ev.unverifiedSubject != "" {
+		msg += fmt.Sprintf(" unverified_subject=%q", ev.unverifiedSubject)
+	}
+	if outcome == OutcomeAccepted {
+		a.logger.Debug(a.prefix, msg)
+	} else if ev.detail != "" {
+		a.logger.Info(a.prefix, msg, fmt.Sprintf("detail=%q", ev.detail))
+	} else {
+		a.logger.Info(a.prefix, msg)
+	}
+
+	if a.registry != nil {
+		gometrics.GetOrRegisterCounter("auth.jwtvalidator."+outcome, a.registry).Inc(1)
+		gometrics.GetOrRegisterCounter("auth.jwtvalidator.reason."+reason, a.registry).Inc(1)
+	}
+	stats.RecordWithTags(ctx, []tag.Mutator{
+		tag.Upsert(KeyAuthEndpoint, a.endpoint),
+		tag.Upsert(KeyAuthOutcome, outcome),
+		tag.Upsert(KeyAuthReason, reason),
+	}, AuthDecisions.M(1))
+}
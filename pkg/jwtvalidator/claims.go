@@ -0,0 +1,211 @@
+package jwtvalidator
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ClaimConstraint restricts the values accepted for a claim. When the claim is an array, the
+// constraint is met if any of its values meets it. The Matches pattern must match the whole
+// value, as if it was enclosed between ^ and $.
+type ClaimConstraint struct {
+	Claim   string        `json:"claim"`
+	Equals  interface{}   `json:"equals"`
+	Matches string        `json:"matches"`
+	OneOf   []interface{} `json:"one_of"`
+
+	re *regexp.Regexp
+}
+
+func (c *ClaimConstraint) compile() error {
+	if c.Claim == "" {
+		return fmt.Errorf("claim constraint without claim name")
+	}
+	if c.Equals == nil && c.Matches == "" && len(c.OneOf) == 0 {
+		return fmt.Errorf("claim constraint for %s without equals, matches or one_of", c.Claim)
+	}
+	if c.Matches == "" {
+		return nil
+	}
+	// anchored, so a pattern like "admin" does not accept "not-admin"
+	re, err := regexp.Compile("^(?:" + c.Matches + ")$")
+	if err != nil {
+		return fmt.Errorf("claim constraint for %s: %w", c.Claim, err)
+	}
+	c.re = re
+	return nil
+}
+
+// ClaimHeader propagates the value of a claim to the backends as a request header. The values
+// of the array claims are joined with the separator.
+type ClaimHeader struct {
+	Claim     string `json:"claim"`
+	Header    string `json:"header"`
+	Separator string `json:"separator"` // default value is ","
+}
+
+// defaultClaimHeaders are the headers propagated when the config does not declare any
+var defaultClaimHeaders = []ClaimHeader{
+	{Claim: "sub", Header: "X-User-Id"},
+	{Claim: "jti", Header: "X-Session-Id"},
+}
+
+// NormalizeClaimHeaders validates the claim propagation and fills its defaults
+func NormalizeClaimHeaders(hs []ClaimHeader) ([]ClaimHeader, error) {
+	if len(hs) == 0 {
+		hs = defaultClaimHeaders
+	}
+	res := make([]ClaimHeader, len(hs))
+	for i, h := range hs {
+		if h.Claim == "" || h.Header == "" {
+			return nil, fmt.Errorf("invalid claim propagation: %+v", h)
+		}
+		if h.Separator == "" {
+			h.Separator = ","
+		}
+		res[i] = h
+	}
+	return res, nil
+}
+
+// ClaimValue returns the claim at the path. The nested claims are accessed with dots, as in
+// realm_access.roles, unless a top-level claim has the exact name of the path.
+func ClaimValue(claims map[string]interface{}, path string) (interface{}, bool) {
+	if v, ok := claims[path]; ok {
+		return v, true
+	}
+	parts := strings.SplitN(path, ".", 2)
+	if len(parts) != 2 {
+		return nil, false
+	}
+	nested, ok := claims[parts[0]].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	return ClaimValue(nested, parts[1])
+}
+
+// RejectSpoofedHeaders fails when the request already carries any of the headers filled with
+// the claims, so the clients can not impersonate other users
+func RejectSpoofedHeaders(propagate []ClaimHeader, h http.Header) *ValidationError {
+	for _, ch := range propagate {
+		if _, ok := h[http.CanonicalHeaderKey(ch.Header)]; ok {
+			return &ValidationError{Status: http.StatusBadRequest, Code: ErrCodeInvalidHeaders, Msg: "Invalid request headers"}
+		}
+	}
+	return nil
+}
+
+// PropagateClaims sets the headers with the values of the claims present in the token
+func PropagateClaims(propagate []ClaimHeader, claims map[string]interface{}, h http.Header) {
+	for _, ch := range propagate {
+		if v, ok := ClaimValue(claims, ch.Claim); ok {
+			h.Set(ch.Header, strings.Join(ClaimValues(v), ch.Separator))
+		}
+	}
+}
+
+// check returns the error of the first condition of the constraint not met by the claims
+func (c *ClaimConstraint) check(claims map[string]interface{}) *ValidationError {
+	v, ok := ClaimValue(claims, c.Claim)
+	if !ok {
+		return unauthorized(ErrCodeMissingClaim, fmt.Sprintf("Unauthorized: The claim %s is required.", c.Claim))
+	}
+	values := ClaimValues(v)
+
+	if c.Equals != nil && !anyValue(values, func(s string) bool { return s == claimString(c.Equals) }) {
+		return unauthorized(ErrCodeClaimMismatch, fmt.Sprintf("Unauthorized: The claim %s has an unexpected value.", c.Claim))
+	}
+	if c.re != nil && !anyValue(values, c.re.MatchString) {
+		return unauthorized(ErrCodeClaimPatternMismatch, fmt.Sprintf("Unauthorized: The claim %s does not match the expected pattern.", c.Claim))
+	}
+	if len(c.OneOf) > 0 && !anyValue(values, func(s string) bool {
+		for _, allowed := range c.OneOf {
+			if s == claimString(allowed) {
+				return true
+			}
+		}
+		return false
+	}) {
+		return unauthorized(ErrCodeClaimNotAllowed, fmt.Sprintf("Unauthorized: The value of the claim %s is not allowed.", c.Claim))
+	}
+	return nil
+}
+
+// validateClaims checks the audience, the required claims and the claim constraints of the config
+func validateClaims(cfg *Config, claims jwt.MapClaims) *ValidationError {
+	if len(cfg.Audience) > 0 {
+		aud, err := claims.GetAudience()
+		if err != nil || !containsAny(aud, cfg.Audience) {
+			return unauthorized(ErrCodeInvalidAudience, "Unauthorized: The token audience is not accepted.")
+		}
+	}
+
+	for _, name := range cfg.RequiredClaims {
+		if _, ok := ClaimValue(claims, name); !ok {
+			return unauthorized(ErrCodeMissingClaim, fmt.Sprintf("Unauthorized: The claim %s is required.", name))
+		}
+	}
+
+	for _, c := range cfg.ClaimConstraints {
+		if verr := c.check(claims); verr != nil {
+			return verr
+		}
+	}
+	return nil
+}
+
+// ClaimValues returns the string representation of the values of a claim
+func ClaimValues(v interface{}) []string {
+	if v == nil {
+		return nil
+	}
+	if vs, ok := v.([]interface{}); ok {
+		res := make([]string, 0, len(vs))
+		for _, v := range vs {
+			res = append(res, claimString(v))
+		}
+		return res
+	}
+	return []string{claimString(v)}
+}
+
+// claimString formats the claim values the same way whether they come from the JSON of the token
+// or from the config, so 1 and 1.0 are equal
+func claimString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return fmt.Sprintf("%v", v)
+	case int:
+		return fmt.Sprintf("%v", float64(v))
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func anyValue(values []string, f func(string) bool) bool {
+	for _, v := range values {
+		if f(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(values, expected []string) bool {
+	for _, v := range values {
+		for _, e := range expected {
+			if v == e {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -1,10 +1,16 @@
 package jwtvalidator
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 
+	jose "api-gateway/v2/modules/krakend-jose/v2"
 	"api-gateway/v2/modules/lura/v2/config"
+	"github.com/golang-jwt/jwt/v5"
 )
 
 const Namespace = "github_com/davron112/jwtvalidator"
@@ -12,11 +18,66 @@ const Namespace = "github_com/davron112/jwtvalidator"
 type Config struct {
 	JWKSURL              string   `json:"jwks_url"`
 	JwtPublicKey         string   `json:"jwt_public_key"`
-	AccessTokenHeaderKey string   `json:"access_token_header_key"`
+	AccessTokenHeaderKey string   `json:"access_token_header_key"` // default value is "Authorization"
 	Roles                []string `json:"roles"`
 	TokenTypeField       string   `json:"token_type_field"` // default value is "access-token"
+	// TokenSources lists the places of the request to look for the token, in order of precedence
+	TokenSources []TokenSource `json:"token_sources"` // default value is the access_token_header_key header
+	// RolesClaim is the path of the claim with the roles of the user
+	RolesClaim string `json:"roles_claim"` // default value is "roles"
+	// TokenTypeClaim is the path of the claim compared with the TokenTypeField
+	TokenTypeClaim string `json:"token_type_claim"` // default value is "authenticationType"
+	// ScopesClaim is the path of the claim with the scopes evaluated by the policy
+	ScopesClaim string `json:"scopes_claim"` // default value is "scope"
+	// Policy is the authorization policy evaluated once the token is valid
+	Policy *Rule `json:"policy"`
+	// PropagateClaims maps the claims to the headers sent to the backends. The requests already
+	// carrying any of these headers are rejected, so the clients can not spoof them.
+	PropagateClaims []ClaimHeader `json:"propagate_claims"` // default value maps sub and jti to X-User-Id and X-Session-Id
+	// Algorithms is the allow-list of the signing algorithms accepted in the tokens
+	Algorithms []string `json:"algorithms"` // default value is every asymmetric algorithm
+	// Issuer is the expected iss claim of the tokens
+	Issuer string `json:"issuer"`
+	// Audience lists the accepted aud claims. The token must contain at least one of them
+	Audience []string `json:"audience"`
+	// Leeway is the clock skew tolerated when validating the exp, nbf and iat claims
+	Leeway string `json:"leeway"` // default value is "0s"
+	// RequiredClaims lists the claims every token must contain
+	RequiredClaims []string `json:"required_claims"`
+	// ClaimConstraints restricts the values of the claims
+	ClaimConstraints []*ClaimConstraint `json:"claim_constraints"`
+	// JWKSCacheTTL is the lifetime of the fetched keys when the JWKS response has no max-age
+	JWKSCacheTTL string `json:"jwks_cache_ttl"` // default value is "15m"
+	// JWKSRefetchInterval is the minimum time between two fetches triggered by an unknown kid
+	JWKSRefetchInterval string `json:"jwks_refetch_interval"` // default value is "30s"
+	// DPoP enables the validation of the DPoP proofs (RFC 9449) of the sender-constrained tokens.
+	// The header token sources with the Bearer scheme also accept the DPoP one.
+	DPoP *jose.DPoPConfig `json:"dpop"`
+	// CertificateBound requires the tokens to be bound to the TLS client certificate (RFC 8705)
+	CertificateBound bool `json:"mtls_bound_tokens"`
+
+	cacheTTL         time.Duration
+	refetchInterval  time.Duration
+	leeway           time.Duration
+	senderConstraint *jose.SenderConstraintValidator
+	// ctx bounds the life of the background refresh of the JWKS
+	ctx context.Context
+}
+
+// defaultAlgorithms are the algorithms accepted when the config does not declare them. The
+// symmetric ones are never accepted by default, as the keys of the JWKS are public.
+var defaultAlgorithms = []string{
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
 }
 
+const (
+	defaultJWKSCacheTTL        = 15 * time.Minute
+	defaultJWKSRefetchInterval = 30 * time.Second
+)
+
 var ErrNoConfig = errors.New("no JWT validator config")
 
 func ParseConfig(extraConfig config.ExtraConfig) (*Config, error) {
@@ -29,6 +90,95 @@ func ParseConfig(extraConfig config.ExtraConfig) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
-	err = json.Unmarshal(b, &res)
-	return &res, err
+	if err := json.Unmarshal(b, &res); err != nil {
+		return nil, err
+	}
+
+	if res.cacheTTL, err = parseDuration(res.JWKSCacheTTL, defaultJWKSCacheTTL); err != nil {
+		return nil, fmt.Errorf("invalid jwks_cache_ttl: %w", err)
+	}
+	if res.refetchInterval, err = parseDuration(res.JWKSRefetchInterval, defaultJWKSRefetchInterval); err != nil {
+		return nil, fmt.Errorf("invalid jwks_refetch_interval: %w", err)
+	}
+	if res.Leeway != "" {
+		if res.leeway, err = time.ParseDuration(res.Leeway); err != nil || res.leeway < 0 {
+			return nil, fmt.Errorf("invalid leeway: %s", res.Leeway)
+		}
+	}
+	if len(res.TokenSources) == 0 {
+		res.TokenSources = DefaultTokenSources(res.AccessTokenHeaderKey)
+	}
+	for i, src := range res.TokenSources {
+		if err := src.Validate(); err != nil {
+			return nil, err
+		}
+		res.TokenSources[i].dpop = res.DPoP != nil && strings.EqualFold(src.Scheme, "Bearer")
+	}
+	if res.senderConstraint, err = jose.NewSenderConstraintValidator(res.DPoP, res.CertificateBound); err != nil {
+		return nil, err
+	}
+	if res.RolesClaim == "" {
+		res.RolesClaim = "roles"
+	}
+	if res.ScopesClaim == "" {
+		res.ScopesClaim = "scope"
+	}
+	if res.Policy != nil {
+		if err := res.Policy.compile("policy"); err != nil {
+			return nil, err
+		}
+	}
+	if res.TokenTypeClaim == "" {
+		res.TokenTypeClaim = "authenticationType"
+	}
+	if res.PropagateClaims, err = NormalizeClaimHeaders(res.PropagateClaims); err != nil {
+		return nil, err
+	}
+	for _, c := range res.ClaimConstraints {
+		if c == nil {
+			return nil, errors.New("invalid claim constraint")
+		}
+		if err := c.compile(); err != nil {
+			return nil, err
+		}
+	}
+	for _, alg := range res.Algorithms {
+		if jwt.GetSigningMethod(alg) == nil {
+			return nil, fmt.Errorf("unsupported algorithm %q", alg)
+		}
+	}
+	return &res, nil
+}
+
+// parserOptions returns the options of the JWT parser enforcing the config
+func (c *Config) parserOptions() []jwt.ParserOption {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(c.algorithms()),
+		jwt.WithLeeway(c.leeway),
+	}
+	if c.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(c.Issuer))
+	}
+	return opts
+}
+
+func (c *Config) algorithms() []string {
+	if len(c.Algorithms) > 0 {
+		return c.Algorithms
+	}
+	return defaultAlgorithms
+}
+
+func parseDuration(v string, d time.Duration) (time.Duration, error) {
+	if v == "" {
+		return d, nil
+	}
+	res, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, err
+	}
+	if res <= 0 {
+		return 0, errors.New("the duration must be positive")
+	}
+	return res, nil
 }
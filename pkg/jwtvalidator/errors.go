@@ -0,0 +1,114 @@
+package jwtvalidator
+
+import (
+	"errors"
+	"net/http"
+
+	jose "api-gateway/v2/modules/krakend-jose/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// The codes of the validation errors, sent in the JSON error body so the clients can tell the
+// failures apart without parsing the messages
+const (
+	ErrCodeInvalidHeaders       = "invalid_request_headers"
+	ErrCodeMissingToken         = "missing_token"
+	ErrCodeMalformedToken       = "malformed_token"
+	ErrCodeUnverifiableToken    = "unverifiable_token"
+	ErrCodeInvalidSignature     = "invalid_signature"
+	ErrCodeTokenExpired         = "token_expired"
+	ErrCodeTokenNotYetValid     = "token_not_yet_valid"
+	ErrCodeTokenUsedBeforeIssue = "token_used_before_issued"
+	ErrCodeInvalidIssuer        = "invalid_issuer"
+	ErrCodeInvalidAudience      = "invalid_audience"
+	ErrCodeMissingClaim         = "missing_claim"
+	ErrCodeClaimMismatch        = "claim_value_mismatch"
+	ErrCodeClaimPatternMismatch = "claim_pattern_mismatch"
+	ErrCodeClaimNotAllowed      = "claim_value_not_allowed"
+	ErrCodeInvalidTokenType     = "invalid_token_type"
+	ErrCodeInsufficientRole     = "insufficient_role"
+	ErrCodeTokenRejected        = "token_rejected"
+	ErrCodePolicyDenied         = "policy_denied"
+	ErrCodeMissingDPoPProof     = "missing_dpop_proof"
+	ErrCodeInvalidDPoPProof     = "invalid_dpop_proof"
+	ErrCodeDPoPProofReplayed    = "dpop_proof_replayed"
+	ErrCodeMissingCertificate   = "client_certificate_required"
+	ErrCodeTokenNotBound        = "token_binding_mismatch"
+	ErrCodeInvalidToken         = "invalid_token"
+	ErrCodeInvalidConfig        = "invalid_validator_config"
+)
+
+// ValidationError is a rejected request, with the status code and the machine-readable code
+// of the failure
+type ValidationError struct {
+	Status int
+	Code   string
+	Msg    string
+	// Rule is the name of the policy rule denying the access, if any
+	Rule string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Msg
+}
+
+// Abort stops the request, rendering the error as the JSON body of the response
+func (e *ValidationError) Abort(c *gin.Context) {
+	body := gin.H{
+		"code": e.Code,
+		"msg":  e.Msg,
+	}
+	if e.Rule != "" {
+		body["rule"] = e.Rule
+	}
+	c.AbortWithStatusJSON(e.Status, gin.H{"error": body})
+}
+
+// errConfig is the error of the endpoints with an invalid validator config
+var errConfig = &ValidationError{
+	Status: http.StatusInternalServerError,
+	Code:   ErrCodeInvalidConfig,
+	Msg:    "the token validation is misconfigured",
+}
+
+func unauthorized(code, msg string) *ValidationError {
+	return &ValidationError{Status: http.StatusUnauthorized, Code: code, Msg: msg}
+}
+
+// parseError translates the errors returned by the JWT parser into validation errors
+func parseError(err error) *ValidationError {
+	switch {
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return unauthorized(ErrCodeMalformedToken, "Unauthorized: The token is malformed.")
+	case errors.Is(err, jwt.ErrTokenUnverifiable):
+		return unauthorized(ErrCodeUnverifiableToken, "Unauthorized: The token can not be verified.")
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		return unauthorized(ErrCodeInvalidSignature, "Unauthorized: The token signature is invalid.")
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return unauthorized(ErrCodeTokenExpired, "Unauthorized: The token has expired.")
+	case errors.Is(err, jwt.ErrTokenNotValidYet):
+		return unauthorized(ErrCodeTokenNotYetValid, "Unauthorized: The token is not valid yet.")
+	case errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return unauthorized(ErrCodeTokenUsedBeforeIssue, "Unauthorized: The token was used before being issued.")
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		return unauthorized(ErrCodeInvalidIssuer, "Unauthorized: The token issuer is not accepted.")
+	}
+	return unauthorized(ErrCodeInvalidToken, "Unauthorized: Authentication is required and has failed or has not yet been provided.")
+}
+
+// senderConstraintError translates the errors of the DPoP and certificate binding checks into
+// validation errors
+func senderConstraintError(err error) *ValidationError {
+	switch {
+	case errors.Is(err, jose.ErrDPoPProofMissing):
+		return unauthorized(ErrCodeMissingDPoPProof, "Unauthorized: The request does not contain a DPoP proof.")
+	case errors.Is(err, jose.ErrInvalidDPoPProof):
+		return unauthorized(ErrCodeInvalidDPoPProof, "Unauthorized: The DPoP proof is invalid.")
+	case errors.Is(err, jose.ErrDPoPProofReplayed):
+		return unauthorized(ErrCodeDPoPProofReplayed, "Unauthorized: The DPoP proof has already been used.")
+	case errors.Is(err, jose.ErrCertificateMissing):
+		return unauthorized(ErrCodeMissingCertificate, "Unauthorized: The request does not contain a client certificate.")
+	}
+	return unauthorized(ErrCodeTokenNotBound, "Unauthorized: The token is not bound to the sender of the request.")
+}
@@ -0,0 +1,289 @@
+package jwtvalidator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"api-gateway/v2/modules/lura/v2/logging"
+)
+
+// ErrKeyNotFound is returned when the kid of the token is not in the JWKS, even after refetching it
+var ErrKeyNotFound = errors.New("key not found in JWKS")
+
+// jwksCaches holds a key set per JWKS URL, so endpoints trusting different issuers never share keys
+var jwksCaches = struct {
+	sync.Mutex
+	sets map[string]*keySet
+}{sets: map[string]*keySet{}}
+
+var jwksClient = &http.Client{Timeout: 10 * time.Second}
+
+type JWKS struct {
+	Keys []json.RawMessage `json:"keys"`
+}
+
+type JWK struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+
+	KeyOps []string `json:"key_ops"`
+}
+
+// keySet caches the keys of a JWKS URL. The keys are refreshed in the background when they
+// expire and refetched on demand, at most once per refetch interval, when a kid is unknown.
+type keySet struct {
+	url             string
+	ttl             time.Duration
+	refetchInterval time.Duration
+	logger          logging.Logger
+	// ctx bounds the life of the background refresh
+	ctx context.Context
+
+	fetchMu sync.Mutex
+	once    sync.Once
+
+	mu         sync.RWMutex
+	keys       map[string]JWK
+	expiration time.Time
+	lastFetch  time.Time
+	lastErr    error
+}
+
+// getKeySet returns the key set of the JWKS URL of the config, creating it on the first call.
+// The cache options and the context of the first config declaring the URL are the ones used.
+func getKeySet(cfg *Config, l logging.Logger) *keySet {
+	jwksCaches.Lock()
+	defer jwksCaches.Unlock()
+
+	if s, ok := jwksCaches.sets[cfg.JWKSURL]; ok {
+		return s
+	}
+	s := &keySet{
+		url:             cfg.JWKSURL,
+		ttl:             cfg.cacheTTL,
+		refetchInterval: cfg.refetchInterval,
+		logger:          l,
+		ctx:             cfg.ctx,
+	}
+	if s.ctx == nil {
+		s.ctx = context.Background()
+	}
+	if s.ttl <= 0 {
+		s.ttl = defaultJWKSCacheTTL
+	}
+	if s.refetchInterval <= 0 {
+		s.refetchInterval = defaultJWKSRefetchInterval
+	}
+	jwksCaches.sets[cfg.JWKSURL] = s
+	return s
+}
+
+func getJWK(cfg *Config, keyID string, l logging.Logger) (JWK, error) {
+	return getKeySet(cfg, l).get(keyID)
+}
+
+// get returns the key with the given kid. Expired keys are still used if the refresh fails,
+// but a missing kid is never replaced by another key.
+func (s *keySet) get(kid string) (JWK, error) {
+	s.mu.RLock()
+	jwk, found := s.keys[kid]
+	loaded := s.keys != nil
+	expired := !time.Now().Before(s.expiration)
+	s.mu.RUnlock()
+
+	if found && !expired {
+		return jwk, nil
+	}
+
+	switch {
+	case !loaded:
+		if err := s.fetch(s.failedRecently); err != nil {
+			return JWK{}, err
+		}
+	case expired:
+		if err := s.fetch(s.isFresh); err != nil {
+			s.logger.Warning(logPrefix, "Using the expired keys of", s.url, "after failing to refresh them:", err.Error())
+		}
+	default:
+		if err := s.fetch(s.fetchedRecently); err != nil {
+			s.logger.Warning(logPrefix, "Refetching the JWKS", s.url, "for the kid", kid, err.Error())
+		}
+	}
+
+	s.mu.RLock()
+	jwk, found = s.keys[kid]
+	s.mu.RUnlock()
+	if !found {
+		return JWK{}, fmt.Errorf("%w: kid %q", ErrKeyNotFound, kid)
+	}
+	return jwk, nil
+}
+
+// fetch downloads the JWKS unless the skip func, evaluated once no other fetch is running,
+// reports it is not required anymore. The first successful fetch starts the background refresh.
+func (s *keySet) fetch(skip func() (bool, error)) error {
+	s.fetchMu.Lock()
+	defer s.fetchMu.Unlock()
+
+	if ok, err := skip(); ok {
+		return err
+	}
+
+	keys, ttl, err := fetchJWKS(s.url)
+	now := time.Now()
+
+	s.mu.Lock()
+	s.lastFetch = now
+	s.lastErr = err
+	if err == nil {
+		if ttl <= 0 {
+			ttl = s.ttl
+		}
+		// the refetch interval bounds the load on the issuer even if it disables the caching
+		if ttl < s.refetchInterval {
+			ttl = s.refetchInterval
+		}
+		s.keys = keys
+		s.expiration = now.Add(ttl)
+	}
+	s.mu.Unlock()
+
+	if err == nil {
+		s.once.Do(func() { go s.refreshLoop() })
+	}
+	return err
+}
+
+// failedRecently avoids hammering an issuer that is not responding when no keys were ever loaded
+func (s *keySet) failedRecently() (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.keys != nil {
+		return true, nil
+	}
+	if s.lastErr != nil && time.Since(s.lastFetch) < s.refetchInterval {
+		return true, s.lastErr
+	}
+	return false, nil
+}
+
+func (s *keySet) isFresh() (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if time.Now().Before(s.expiration) {
+		return true, nil
+	}
+	if s.lastErr != nil && time.Since(s.lastFetch) < s.refetchInterval {
+		return true, s.lastErr
+	}
+	return false, nil
+}
+
+func (s *keySet) fetchedRecently() (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return time.Since(s.lastFetch) < s.refetchInterval, nil
+}
+
+// refreshLoop refreshes the keys when they expire, so the requests do not wait for the issuer.
+// Once the context is done, the loop stops and the key set is dropped from the cache.
+func (s *keySet) refreshLoop() {
+	timer := time.NewTimer(s.nextRefresh())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			jwksCaches.Lock()
+			if jwksCaches.sets[s.url] == s {
+				delete(jwksCaches.sets, s.url)
+			}
+			jwksCaches.Unlock()
+			return
+		case <-timer.C:
+		}
+
+		if err := s.fetch(s.isFresh); err != nil {
+			s.logger.Warning(logPrefix, "Refreshing the JWKS", s.url, err.Error())
+		} else {
+			s.logger.Debug(logPrefix, "JWKS refreshed:", s.url)
+		}
+		timer.Reset(s.nextRefresh())
+	}
+}
+
+// nextRefresh returns the time until the keys expire, never under the refetch interval
+func (s *keySet) nextRefresh() time.Duration {
+	s.mu.RLock()
+	wait := time.Until(s.expiration)
+	s.mu.RUnlock()
+	return max(wait, s.refetchInterval)
+}
+
+// fetchJWKS downloads the key set, indexing the keys by their kid, and returns the lifetime
+// declared by the Cache-Control header of the response, if any
+func fetchJWKS(jwksURL string) (map[string]JWK, time.Duration, error) {
+	resp, err := jwksClient.Get(jwksURL)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("failed to fetch JWKS: status code %d", resp.StatusCode)
+	}
+
+	var jwks JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, 0, err
+	}
+
+	keys := make(map[string]JWK, len(jwks.Keys))
+	for _, key := range jwks.Keys {
+		var jwk JWK
+		if err := json.Unmarshal(key, &jwk); err != nil || jwk.Kid == "" {
+			continue
+		}
+		keys[jwk.Kid] = jwk
+	}
+	if len(keys) == 0 {
+		return nil, 0, errors.New("no usable keys in the JWKS")
+	}
+	return keys, maxAge(resp.Header.Get("Cache-Control")), nil
+}
+
+// maxAge returns the max-age directive of the Cache-Control header. The no-cache and no-store
+// directives return the minimum lifetime.
+func maxAge(cacheControl string) time.Duration {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-cache", directive == "no-store":
+			return time.Nanosecond
+		case strings.HasPrefix(directive, "max-age="):
+			secs, err := strconv.Atoi(strings.Trim(directive[len("max-age="):], `"`))
+			if err != nil || secs < 0 {
+				continue
+			}
+			if secs == 0 {
+				return time.Nanosecond
+			}
+			return time.Duration(secs) * time.Second
+		}
+	}
+	return 0
+}
@@ -0,0 +1,80 @@
+package jwtvalidator
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"api-gateway/v2/modules/lura/v2/logging"
+)
+
+func TestKeySet_refreshLoop(t *testing.T) {
+	var fetches int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt64(&fetches, 1)
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write([]byte(`{"keys":[{"kid":"k1","kty":"OKP","crv":"Ed25519","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}]}`))
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg := &Config{JWKSURL: ts.URL, cacheTTL: time.Minute, refetchInterval: 10 * time.Millisecond, ctx: ctx}
+	if _, err := getJWK(cfg, "k1", logging.NoOp); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		cancel()
+		return
+	}
+	if _, err := getJWK(cfg, "unknown", logging.NoOp); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// the keys expire at every refetch interval, so they are refreshed in the background
+	waitFor(t, func() bool { return atomic.LoadInt64(&fetches) > 3 })
+
+	cancel()
+	waitFor(t, func() bool {
+		jwksCaches.Lock()
+		defer jwksCaches.Unlock()
+		_, ok := jwksCaches.sets[ts.URL]
+		return !ok
+	})
+	stopped := atomic.LoadInt64(&fetches)
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt64(&fetches); n != stopped {
+		t.Errorf("the keys were refreshed after the cancellation: %d fetches", n-stopped)
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	for header, want := range map[string]time.Duration{
+		"":                          0,
+		"public":                    0,
+		"max-age=60":                time.Minute,
+		"public, max-age=\"120\"":   2 * time.Minute,
+		"max-age=0":                 time.Nanosecond,
+		"no-store":                  time.Nanosecond,
+		"No-Cache, max-age=60":      time.Nanosecond,
+		"max-age=-1":                0,
+		"max-age=invalid, no-cache": time.Nanosecond,
+	} {
+		if d := maxAge(header); d != want {
+			t.Errorf("unexpected max age of %q. have: %s, want: %s", header, d, want)
+		}
+	}
+}
+
+// waitFor waits until the condition is true, failing the test after a second
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
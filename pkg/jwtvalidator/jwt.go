@@ -1,118 +1,38 @@
 package jwtvalidator
 
 import (
+	jose "api-gateway/v2/modules/krakend-jose/v2"
 	"api-gateway/v2/modules/lura/v2/logging"
+	"crypto/ecdh"
 	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
-	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"math/big"
 	"net/http"
 	"strings"
-	"sync"
 )
 
-var (
-	jwksCache   JWKS
-	jwksCacheMu sync.Mutex
-)
-
-type JWKS struct {
-	Keys []json.RawMessage `json:"keys"`
-}
-
-type JWK struct {
-	Kid string `json:"kid"`
-	Kty string `json:"kty"`
-	Alg string `json:"alg"`
-	Use string `json:"use"`
-	N   string `json:"n"`
-	E   string `json:"e"`
-}
-
-func fetchJWKS(jwksURL string) (JWKS, error) {
-	resp, err := http.Get(jwksURL)
-	if err != nil {
-		return JWKS{}, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return JWKS{}, errors.New("failed to fetch JWKS")
-	}
-
-	var jwks JWKS
-	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
-		return JWKS{}, err
-	}
-	return jwks, nil
-}
-
-func getJWK(keyID string, jwksURL string) (JWK, error) {
-	jwksCacheMu.Lock()
-	defer jwksCacheMu.Unlock()
-
-	if len(jwksCache.Keys) == 0 {
-		newJWKS, err := fetchJWKS(jwksURL)
-		if err != nil {
-			return JWK{}, err
-		}
-		jwksCache = newJWKS
+func validateJWT(cfg *Config, rejecter jose.Rejecter, req *http.Request, params gin.Params, l logging.Logger, ev *authEvent) *ValidationError {
+	if verr := RejectSpoofedHeaders(cfg.PropagateClaims, req.Header); verr != nil {
+		return verr
 	}
 
-	for _, key := range jwksCache.Keys {
-		var jwk JWK
-		if err := json.Unmarshal(key, &jwk); err != nil {
-			continue
-		}
-		if jwk.Kid == keyID {
-			return jwk, nil
-		}
+	tokenString, scheme, verr := extractToken(cfg.TokenSources, req)
+	if verr != nil {
+		return verr
 	}
+	ev.setToken(tokenString)
 
-	// If kid not found, return the first JWK from the array
-	if len(jwksCache.Keys) > 0 {
-		var jwk JWK
-		if err := json.Unmarshal(jwksCache.Keys[0], &jwk); err == nil {
-			return jwk, nil
-		}
-	}
-
-	return JWK{}, errors.New("key not found in JWKS and no keys available")
-}
-
-func validateJWT(cfg *Config, req *http.Request, l logging.Logger) (int, string) {
-	var tokenString string
-	headers := req.Header
-
-	if headers.Get("X-User-Id") != "" || headers.Get("X-Session-Id") != "" {
-		// Return 400 Bad Request
-		return http.StatusBadRequest, "Invalid request headers"
-	}
-
-	tokenValue := headers.Get("Authorization")
-	fmt.Println(tokenValue, "tokenValue")
-
-	tokenParts := strings.Split(tokenValue, " ")
-	if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
-		return http.StatusUnauthorized, "Authorization header format must be 'Bearer {token}'"
-	}
-	tokenString = tokenParts[1]
-
-	type CustomClaims struct {
-		UserID    string   `json:"sub"`
-		SessionID string   `json:"jti"`
-		Roles     []string `json:"roles"`
-		TokenType string   `json:"authenticationType"`
-		jwt.RegisteredClaims
-	}
-
-	token, err := jwt.ParseWithClaims(tokenString, &CustomClaims{}, func(token *jwt.Token) (interface{}, error) {
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
 		if cfg.JwtPublicKey != "" {
 			parsedKey, err := parsePublicKeyFromPEM(cfg.JwtPublicKey)
 			if err != nil {
@@ -129,51 +49,81 @@ func validateJWT(cfg *Config, req *http.Request, l logging.Logger) (int, string)
 		if !ok {
 			return nil, errors.New("kid header not found in token")
 		}
+		ev.kid = keyID
 
-		jwk, err := getJWK(keyID, cfg.JWKSURL)
+		jwk, err := getJWK(cfg, keyID, l)
 		if err != nil {
 			return nil, err
 		}
 
+		if err := checkJWK(jwk, token.Method.Alg()); err != nil {
+			return nil, err
+		}
 		return getKeyFromJWK(jwk)
-	})
+	}, cfg.parserOptions()...)
 
+	// the claims are decoded even if the token is rejected, so the subject of the rejected tokens is
+	// audited apart, as it can not be trusted
 	if err != nil {
-		fmt.Println(err)
-		return http.StatusUnauthorized, "Unauthorized: Authentication is required and has failed or has not yet been provided."
+		ev.unverifiedSubject = claimString(claims["sub"])
+		ev.detail = err.Error()
+		return parseError(err)
+	}
+	if !token.Valid {
+		ev.unverifiedSubject = claimString(claims["sub"])
+		return unauthorized(ErrCodeInvalidToken, "Invalid token or claims")
+	}
+	ev.subject = claimString(claims["sub"])
+
+	if rejecter.Reject(claims) {
+		return unauthorized(ErrCodeTokenRejected, "Unauthorized: The token has been revoked.")
+	}
+
+	if err := cfg.senderConstraint.Validate(req, tokenString, scheme, claims); err != nil {
+		ev.detail = err.Error()
+		return senderConstraintError(err)
 	}
 
-	if claims, ok := token.Claims.(*CustomClaims); ok && token.Valid {
-		if cfg.TokenTypeField != "" && claims.TokenType != cfg.TokenTypeField {
-			return http.StatusUnauthorized, "Unauthorized: Invalid token type."
+	if verr := validateClaims(cfg, claims); verr != nil {
+		return verr
+	}
+
+	tokenType, _ := ClaimValue(claims, cfg.TokenTypeClaim)
+	if cfg.TokenTypeField != "" && claimString(tokenType) != cfg.TokenTypeField {
+		return unauthorized(ErrCodeInvalidTokenType, "Unauthorized: Invalid token type.")
+	}
+	if len(cfg.Roles) > 0 {
+		roleMap := make(map[string]bool)
+		for _, role := range cfg.Roles {
+			roleMap[role] = true
 		}
-		if len(cfg.Roles) > 0 {
-			roleMap := make(map[string]bool)
-			for _, role := range cfg.Roles {
-				roleMap[role] = true
-			}
 
-			// Check if the user has any of the required roles
-			roleFound := false
-			for _, role := range claims.Roles {
-				if roleMap[role] {
-					roleFound = true
-					break
-				}
+		// Check if the user has any of the required roles
+		roleFound := false
+		roles, _ := ClaimValue(claims, cfg.RolesClaim)
+		for _, role := range ClaimValues(roles) {
+			if roleMap[role] {
+				roleFound = true
+				break
 			}
+		}
 
-			if !roleFound {
-				return http.StatusForbidden, "Forbidden: You do not have permission to access this resource with your current role."
+		if !roleFound {
+			return &ValidationError{
+				Status: http.StatusForbidden,
+				Code:   ErrCodeInsufficientRole,
+				Msg:    "Forbidden: You do not have permission to access this resource with your current role.",
 			}
 		}
+	}
 
-		fmt.Println(claims, "claims")
-		req.Header.Set("X-User-Id", claims.UserID)
-		req.Header.Set("X-Session-Id", claims.SessionID)
-		return 0, ""
+	if verr := checkPolicy(cfg, req, params, claims); verr != nil {
+		return verr
 	}
 
-	return http.StatusUnauthorized, "Invalid token or claims"
+	PropagateClaims(cfg.PropagateClaims, claims, req.Header)
+	ev.claims = claims
+	return nil
 }
 
 func parsePublicKeyFromPEM(keyPEM string) (interface{}, error) {
@@ -197,12 +147,49 @@ func parsePublicKeyFromPEM(keyPEM string) (interface{}, error) {
 		return pub, nil
 	case *rsa.PublicKey:
 		return pub, nil
+	case ed25519.PublicKey:
+		return pub, nil
 	default:
 		return nil, errors.New("unsupported key type")
 	}
 }
 
-func getKeyFromJWK(jwk JWK) (*rsa.PublicKey, error) {
+// checkJWK rejects the keys not intended to verify signatures with the algorithm of the token
+func checkJWK(jwk JWK, alg string) error {
+	if jwk.Use != "" && jwk.Use != "sig" {
+		return fmt.Errorf("the key %s is not a signature key (use: %s)", jwk.Kid, jwk.Use)
+	}
+	if len(jwk.KeyOps) > 0 {
+		verify := false
+		for _, op := range jwk.KeyOps {
+			if op == "verify" {
+				verify = true
+				break
+			}
+		}
+		if !verify {
+			return fmt.Errorf("the key %s can not be used to verify signatures", jwk.Kid)
+		}
+	}
+	if jwk.Alg != "" && jwk.Alg != alg {
+		return fmt.Errorf("the token algorithm %s does not match the algorithm %s of the key %s", alg, jwk.Alg, jwk.Kid)
+	}
+	return nil
+}
+
+func getKeyFromJWK(jwk JWK) (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		return getRSAKeyFromJWK(jwk)
+	case "EC":
+		return getECKeyFromJWK(jwk)
+	case "OKP":
+		return getOKPKeyFromJWK(jwk)
+	}
+	return nil, fmt.Errorf("unsupported JWK key type %q", jwk.Kty)
+}
+
+func getRSAKeyFromJWK(jwk JWK) (*rsa.PublicKey, error) {
 	nBytes, err := base64.RawURLEncoding.DecodeString(jwk.N)
 	if err != nil {
 		return nil, fmt.Errorf("invalid JWK N value: %v", err)
@@ -221,3 +208,66 @@ func getKeyFromJWK(jwk JWK) (*rsa.PublicKey, error) {
 
 	return rsaPublicKey, nil
 }
+
+func getECKeyFromJWK(jwk JWK) (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch jwk.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported JWK curve %q", jwk.Crv)
+	}
+
+	xBytes, err := base64.RawURLEncoding.DecodeString(jwk.X)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWK X value: %v", err)
+	}
+	yBytes, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWK Y value: %v", err)
+	}
+
+	// the coordinates must have the size of the curve, so the uncompressed point can be rebuilt
+	size := (curve.Params().BitSize + 7) / 8
+	if len(xBytes) != size || len(yBytes) != size {
+		return nil, errors.New("invalid JWK coordinates size")
+	}
+	point := append([]byte{4}, append(xBytes, yBytes...)...)
+
+	var ecdhCurve ecdh.Curve
+	switch jwk.Crv {
+	case "P-256":
+		ecdhCurve = ecdh.P256()
+	case "P-384":
+		ecdhCurve = ecdh.P384()
+	default:
+		ecdhCurve = ecdh.P521()
+	}
+	if _, err := ecdhCurve.NewPublicKey(point); err != nil {
+		return nil, fmt.Errorf("invalid JWK EC point: %v", err)
+	}
+
+	return &ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(xBytes),
+		Y:     new(big.Int).SetBytes(yBytes),
+	}, nil
+}
+
+func getOKPKeyFromJWK(jwk JWK) (ed25519.PublicKey, error) {
+	if jwk.Crv != "Ed25519" {
+		return nil, fmt.Errorf("unsupported JWK curve %q", jwk.Crv)
+	}
+	xBytes, err := base64.RawURLEncoding.DecodeString(jwk.X)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWK X value: %v", err)
+	}
+	if len(xBytes) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid JWK Ed25519 key size")
+	}
+	return ed25519.PublicKey(xBytes), nil
+}
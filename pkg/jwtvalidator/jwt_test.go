@@ -0,0 +1,604 @@
+package jwtvalidator
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	jose "api-gateway/v2/modules/krakend-jose/v2"
+	"api-gateway/v2/modules/lura/v2/config"
+	"api-gateway/v2/modules/lura/v2/logging"
+	"api-gateway/v2/modules/lura/v2/proxy"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testIssuer signs the tokens with the keys published in its JWKS
+type testIssuer struct {
+	key   *ecdsa.PrivateKey
+	other *ecdsa.PrivateKey
+	url   string
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	i := &testIssuer{key: newECKey(t), other: newECKey(t)}
+	jwks := map[string]interface{}{
+		"keys": []map[string]interface{}{
+			ecJWK(i.key, "k1", map[string]interface{}{"alg": "ES256", "use": "sig"}),
+			ecJWK(i.key, "es384", map[string]interface{}{"alg": "ES384"}),
+			ecJWK(i.key, "enc", map[string]interface{}{"use": "enc"}),
+			ecJWK(i.key, "wrap", map[string]interface{}{"key_ops": []string{"wrapKey"}}),
+		},
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		json.NewEncoder(w).Encode(jwks)
+	}))
+	t.Cleanup(ts.Close)
+	i.url = ts.URL
+	return i
+}
+
+// token returns a token signed with the key of the issuer
+func (i *testIssuer) token(t *testing.T, claims jwt.MapClaims) string {
+	return signToken(t, jwt.SigningMethodES256, "k1", i.key, claims)
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	return s
+}
+
+func TestNew(t *testing.T) {
+	issuer := newTestIssuer(t)
+	now := time.Now()
+	claims := func(extra map[string]interface{}) jwt.MapClaims {
+		c := jwt.MapClaims{"sub": "user-1", "iss": "https://issuer.example.com", "aud": "api", "exp": now.Add(time.Hour).Unix()}
+		for k, v := range extra {
+			if v == nil {
+				delete(c, k)
+				continue
+			}
+			c[k] = v
+		}
+		return c
+	}
+	bearer := func(token string) map[string]string {
+		return map[string]string{"Authorization": "Bearer " + token}
+	}
+	// the public key of the issuer, as an attacker would use it as the secret of a HMAC token
+	publicKey, _ := json.Marshal(ecJWK(issuer.key, "k1", nil))
+
+	for _, tc := range []struct {
+		name    string
+		cfg     map[string]interface{}
+		url     string
+		headers map[string]string
+		cookie  *http.Cookie
+		tls     bool
+		status  int
+		code    string
+	}{
+		{
+			name:    "valid token",
+			headers: bearer(issuer.token(t, claims(nil))),
+			status:  http.StatusOK,
+		},
+		{
+			name:   "missing token",
+			status: http.StatusUnauthorized,
+			code:   ErrCodeMissingToken,
+		},
+		{
+			name:    "unexpected scheme",
+			headers: map[string]string{"Authorization": "Basic " + issuer.token(t, claims(nil))},
+			status:  http.StatusUnauthorized,
+			code:    ErrCodeMissingToken,
+		},
+		{
+			name:    "malformed token",
+			headers: bearer("not-a-token"),
+			status:  http.StatusUnauthorized,
+			code:    ErrCodeMalformedToken,
+		},
+		{
+			name:    "HMAC token signed with the public key",
+			headers: bearer(signToken(t, jwt.SigningMethodHS256, "k1", publicKey, claims(nil))),
+			status:  http.StatusUnauthorized,
+			code:    ErrCodeInvalidSignature,
+		},
+		{
+			name:    "HMAC token with the HMAC algorithms allowed",
+			cfg:     map[string]interface{}{"algorithms": []string{"HS256", "ES256"}},
+			headers: bearer(signToken(t, jwt.SigningMethodHS256, "k1", publicKey, claims(nil))),
+			status:  http.StatusUnauthorized,
+			code:    ErrCodeUnverifiableToken,
+		},
+		{
+			name:    "unsigned token",
+			headers: bearer(signToken(t, jwt.SigningMethodNone, "k1", jwt.UnsafeAllowNoneSignatureType, claims(nil))),
+			status:  http.StatusUnauthorized,
+			code:    ErrCodeInvalidSignature,
+		},
+		{
+			name:    "algorithm not allowed",
+			cfg:     map[string]interface{}{"algorithms": []string{"RS256"}},
+			headers: bearer(issuer.token(t, claims(nil))),
+			status:  http.StatusUnauthorized,
+			code:    ErrCodeInvalidSignature,
+		},
+		{
+			name:    "algorithm of the key mismatch",
+			headers: bearer(signToken(t, jwt.SigningMethodES256, "es384", issuer.key, claims(nil))),
+			status:  http.StatusUnauthorized,
+			code:    ErrCodeUnverifiableToken,
+		},
+		{
+			name:    "encryption key",
+			headers: bearer(signToken(t, jwt.SigningMethodES256, "enc", issuer.key, claims(nil))),
+			status:  http.StatusUnauthorized,
+			code:    ErrCodeUnverifiableToken,
+		},
+		{
+			name:    "key without the verify operation",
+			headers: bearer(signToken(t, jwt.SigningMethodES256, "wrap", issuer.key, claims(nil))),
+			status:  http.StatusUnauthorized,
+			code:    ErrCodeUnverifiableToken,
+		},
+		{
+			name:    "unknown kid",
+			headers: bearer(signToken(t, jwt.SigningMethodES256, "unknown", issuer.key, claims(nil))),
+			status:  http.StatusUnauthorized,
+			code:    ErrCodeUnverifiableToken,
+		},
+		{
+			name:    "missing kid",
+			headers: bearer(signToken(t, jwt.SigningMethodES256, "", issuer.key, claims(nil))),
+			status:  http.StatusUnauthorized,
+			code:    ErrCodeUnverifiableToken,
+		},
+		{
+			name:    "invalid signature",
+			headers: bearer(signToken(t, jwt.SigningMethodES256, "k1", issuer.other, claims(nil))),
+			status:  http.StatusUnauthorized,
+			code:    ErrCodeInvalidSignature,
+		},
+		{
+			name:    "expired token",
+			headers: bearer(issuer.token(t, claims(map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()}))),
+			status:  http.StatusUnauthorized,
+			code:    ErrCodeTokenExpired,
+		},
+		{
+			name:    "expired token in the leeway",
+			cfg:     map[string]interface{}{"leeway": "1m"},
+			headers: bearer(issuer.token(t, claims(map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()}))),
+			status:  http.StatusOK,
+		},
+		{
+			name:    "token not valid yet",
+			headers: bearer(issuer.token(t, claims(map[string]interface{}{"nbf": now.Add(time.Minute).Unix()}))),
+			status:  http.StatusUnauthorized,
+			code:    ErrCodeTokenNotYetValid,
+		},
+		{
+			name:    "token not valid yet in the leeway",
+			cfg:     map[string]interface{}{"leeway": "2m"},
+			headers: bearer(issuer.token(t, claims(map[string]interface{}{"nbf": now.Add(time.Minute).Unix()}))),
+			status:  http.StatusOK,
+		},
+		{
+			name:    "expected issuer",
+			cfg:     map[string]interface{}{"issuer": "https://issuer.example.com"},
+			headers: bearer(issuer.token(t, claims(nil))),
+			status:  http.StatusOK,
+		},
+		{
+			name:    "unexpected issuer",
+			cfg:     map[string]interface{}{"issuer": "https://other.example.com"},
+			headers: bearer(issuer.token(t, claims(nil))),
+			status:  http.StatusUnauthorized,
+			code:    ErrCodeInvalidIssuer,
+		},
+		{
+			name:    "accepted audience",
+			cfg:     map[string]interface{}{"audience": []string{"other", "api"}},
+			headers: bearer(issuer.token(t, claims(map[string]interface{}{"aud": []string{"web", "api"}}))),
+			status:  http.StatusOK,
+		},
+		{
+			name:    "unexpected audience",
+			cfg:     map[string]interface{}{"audience": []string{"other"}},
+			headers: bearer(issuer.token(t, claims(nil))),
+			status:  http.StatusUnauthorized,
+			code:    ErrCodeInvalidAudience,
+		},
+		{
+			name:    "missing audience",
+			cfg:     map[string]interface{}{"audience": []string{"api"}},
+			headers: bearer(issuer.token(t, claims(map[string]interface{}{"aud": nil}))),
+			status:  http.StatusUnauthorized,
+			code:    ErrCodeInvalidAudience,
+		},
+		{
+			name:    "missing required claim",
+			cfg:     map[string]interface{}{"required_claims": []string{"sub", "tenant.id"}},
+			headers: bearer(issuer.token(t, claims(map[string]interface{}{"tenant": map[string]interface{}{"name": "acme"}}))),
+			status:  http.StatusUnauthorized,
+			code:    ErrCodeMissingClaim,
+		},
+		{
+			name:    "required nested claim",
+			cfg:     map[string]interface{}{"required_claims": []string{"sub", "tenant.id"}},
+			headers: bearer(issuer.token(t, claims(map[string]interface{}{"tenant": map[string]interface{}{"id": "acme"}}))),
+			status:  http.StatusOK,
+		},
+		{
+			name:    "claim mismatch",
+			cfg:     map[string]interface{}{"claim_constraints": []map[string]interface{}{{"claim": "tier", "equals": 1}}},
+			headers: bearer(issuer.token(t, claims(map[string]interface{}{"tier": 2}))),
+			status:  http.StatusUnauthorized,
+			code:    ErrCodeClaimMismatch,
+		},
+		{
+			name:    "claim pattern mismatch",
+			cfg:     map[string]interface{}{"claim_constraints": []map[string]interface{}{{"claim": "group", "matches": "admin"}}},
+			headers: bearer(issuer.token(t, claims(map[string]interface{}{"group": "not-admin"}))),
+			status:  http.StatusUnauthorized,
+			code:    ErrCodeClaimPatternMismatch,
+		},
+		{
+			name:    "claim value not allowed",
+			cfg:     map[string]interface{}{"claim_constraints": []map[string]interface{}{{"claim": "groups", "one_of": []string{"admin", "ops"}}}},
+			headers: bearer(issuer.token(t, claims(map[string]interface{}{"groups": []string{"dev", "qa"}}))),
+			status:  http.StatusUnauthorized,
+			code:    ErrCodeClaimNotAllowed,
+		},
+		{
+			name:    "claim constraints met",
+			cfg:     map[string]interface{}{"claim_constraints": []map[string]interface{}{{"claim": "groups", "one_of": []string{"admin", "ops"}, "matches": "op.*"}}},
+			headers: bearer(issuer.token(t, claims(map[string]interface{}{"groups": []string{"dev", "ops"}}))),
+			status:  http.StatusOK,
+		},
+		{
+			name:    "invalid token type",
+			cfg:     map[string]interface{}{"token_type_field": "access-token"},
+			headers: bearer(issuer.token(t, claims(map[string]interface{}{"authenticationType": "refresh-token"}))),
+			status:  http.StatusUnauthorized,
+			code:    ErrCodeInvalidTokenType,
+		},
+		{
+			name:    "insufficient role",
+			cfg:     map[string]interface{}{"roles": []string{"admin"}},
+			headers: bearer(issuer.token(t, claims(map[string]interface{}{"roles": []string{"user"}}))),
+			status:  http.StatusForbidden,
+			code:    ErrCodeInsufficientRole,
+		},
+		{
+			name:    "revoked token",
+			headers: bearer(issuer.token(t, claims(map[string]interface{}{"sub": "revoked"}))),
+			status:  http.StatusUnauthorized,
+			code:    ErrCodeTokenRejected,
+		},
+		{
+			name:    "spoofed claim header",
+			headers: map[string]string{"Authorization": "Bearer " + issuer.token(t, claims(nil)), "X-User-Id": "admin"},
+			status:  http.StatusBadRequest,
+			code:    ErrCodeInvalidHeaders,
+		},
+		{
+			name:   "cookie token",
+			cfg:    map[string]interface{}{"token_sources": []map[string]string{{"type": "header", "name": "Authorization", "scheme": "Bearer"}, {"type": "cookie", "name": "session"}}},
+			cookie: &http.Cookie{Name: "session", Value: issuer.token(t, claims(nil))},
+			status: http.StatusOK,
+		},
+		{
+			name:    "malformed header before a cookie token",
+			cfg:     map[string]interface{}{"token_sources": []map[string]string{{"type": "header", "name": "Authorization", "scheme": "Bearer"}, {"type": "cookie", "name": "session"}}},
+			headers: map[string]string{"Authorization": "Bearer"},
+			cookie:  &http.Cookie{Name: "session", Value: issuer.token(t, claims(nil))},
+			status:  http.StatusUnauthorized,
+			code:    ErrCodeMissingToken,
+		},
+		{
+			name:   "query token",
+			cfg:    map[string]interface{}{"token_sources": []map[string]string{{"type": "query", "name": "access_token"}}},
+			url:    "/orders/1?access_token=" + issuer.token(t, claims(nil)),
+			status: http.StatusOK,
+		},
+		{
+			name:    "header token not in the sources",
+			cfg:     map[string]interface{}{"token_sources": []map[string]string{{"type": "query", "name": "access_token"}}},
+			headers: bearer(issuer.token(t, claims(nil))),
+			status:  http.StatusUnauthorized,
+			code:    ErrCodeMissingToken,
+		},
+		{
+			name:    "raw header token",
+			cfg:     map[string]interface{}{"access_token_header_key": "X-Token"},
+			headers: map[string]string{"X-Token": issuer.token(t, claims(nil))},
+			status:  http.StatusOK,
+		},
+		{
+			name: "policy denied",
+			cfg: map[string]interface{}{"policy": map[string]interface{}{
+				"name": "owners", "params": map[string][]string{"id": {"2"}},
+			}},
+			headers: bearer(issuer.token(t, claims(nil))),
+			status:  http.StatusForbidden,
+			code:    ErrCodePolicyDenied,
+		},
+		{
+			name: "policy granted",
+			cfg: map[string]interface{}{"policy": map[string]interface{}{
+				"name": "owners", "params": map[string][]string{"id": {"1"}}, "scopes": []string{"orders:read"},
+			}},
+			headers: bearer(issuer.token(t, claims(map[string]interface{}{"scope": "profile orders:read"}))),
+			status:  http.StatusOK,
+		},
+		{
+			name:    "DPoP required for a bearer token",
+			cfg:     map[string]interface{}{"dpop": map[string]interface{}{"required": true}},
+			headers: bearer(issuer.token(t, claims(nil))),
+			status:  http.StatusUnauthorized,
+			code:    ErrCodeTokenNotBound,
+		},
+		{
+			name:    "DPoP-bound token without proof",
+			cfg:     map[string]interface{}{"dpop": map[string]interface{}{}},
+			headers: map[string]string{"Authorization": "DPoP " + issuer.token(t, claims(map[string]interface{}{"cnf": map[string]interface{}{"jkt": "thumbprint"}}))},
+			status:  http.StatusUnauthorized,
+			code:    ErrCodeMissingDPoPProof,
+		},
+		{
+			name:    "DPoP scheme without DPoP",
+			headers: map[string]string{"Authorization": "DPoP " + issuer.token(t, claims(nil))},
+			status:  http.StatusUnauthorized,
+			code:    ErrCodeMissingToken,
+		},
+		{
+			name:    "certificate-bound token without certificate",
+			cfg:     map[string]interface{}{"mtls_bound_tokens": true},
+			headers: bearer(issuer.token(t, claims(map[string]interface{}{"cnf": map[string]interface{}{"x5t#S256": "thumbprint"}}))),
+			tls:     true,
+			status:  http.StatusUnauthorized,
+			code:    ErrCodeMissingCertificate,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := map[string]interface{}{"jwks_url": issuer.url}
+			for k, v := range tc.cfg {
+				cfg[k] = v
+			}
+			url := tc.url
+			if url == "" {
+				url = "/orders/1"
+			}
+			req, _ := http.NewRequest(http.MethodGet, url, http.NoBody)
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			if tc.cookie != nil {
+				req.AddCookie(tc.cookie)
+			}
+			if tc.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+
+			w := serveValidator(t, cfg, req)
+			if w.Code != tc.status {
+				t.Errorf("unexpected status code: %d. body: %s", w.Code, w.Body.String())
+			}
+			if tc.status == http.StatusOK {
+				if body := w.Body.String(); body != "user-1" {
+					t.Errorf("unexpected propagated subject: %q", body)
+				}
+				return
+			}
+			var body struct {
+				Error struct {
+					Code string `json:"code"`
+				} `json:"error"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &body)
+			if body.Error.Code != tc.code {
+				t.Errorf("unexpected error code. have: %q, want: %q", body.Error.Code, tc.code)
+			}
+		})
+	}
+}
+
+func TestNew_invalidConfig(t *testing.T) {
+	for name, cfg := range map[string]map[string]interface{}{
+		"unknown algorithm":    {"algorithms": []string{"XX999"}},
+		"invalid leeway":       {"leeway": "-1s"},
+		"invalid cache ttl":    {"jwks_cache_ttl": "soon"},
+		"unknown token source": {"token_sources": []map[string]string{{"type": "body", "name": "token"}}},
+		"unnamed token source": {"token_sources": []map[string]string{{"type": "cookie"}}},
+		"invalid constraint":   {"claim_constraints": []map[string]interface{}{{"claim": "sub"}}},
+		"invalid pattern":      {"claim_constraints": []map[string]interface{}{{"claim": "sub", "matches": "("}}},
+		"empty policy rule":    {"policy": map[string]interface{}{"name": "empty"}},
+		"invalid propagation":  {"propagate_claims": []map[string]string{{"claim": "sub"}}},
+		"invalid DPoP":         {"dpop": map[string]interface{}{"algorithms": []string{"HS256"}}},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, "/orders/1", http.NoBody)
+		if w := serveValidator(t, cfg, req); w.Code != http.StatusInternalServerError {
+			t.Errorf("%s: unexpected status code: %d", name, w.Code)
+		}
+	}
+}
+
+func TestNew_audit(t *testing.T) {
+	issuer := newTestIssuer(t)
+	buf := new(bytes.Buffer)
+	logger, _ := logging.NewLogger("DEBUG", buf, "")
+	now := time.Now()
+
+	for _, tc := range []struct {
+		name   string
+		token  string
+		fields []string
+	}{
+		{
+			name:   "accepted",
+			token:  issuer.token(t, jwt.MapClaims{"sub": "user-1", "exp": now.Add(time.Hour).Unix()}),
+			fields: []string{`subject="user-1"`, `kid="k1"`, "outcome=accepted"},
+		},
+		{
+			name:   "forged",
+			token:  signToken(t, jwt.SigningMethodES256, "k1", issuer.other, jwt.MapClaims{"sub": "admin"}),
+			fields: []string{`subject=""`, `unverified_subject="admin"`, "reason=" + ErrCodeInvalidSignature},
+		},
+		{
+			name:   "expired",
+			token:  issuer.token(t, jwt.MapClaims{"sub": "user-1", "exp": now.Add(-time.Hour).Unix()}),
+			fields: []string{`subject=""`, `unverified_subject="user-1"`, "reason=" + ErrCodeTokenExpired},
+		},
+		{
+			name:   "revoked",
+			token:  issuer.token(t, jwt.MapClaims{"sub": "revoked", "exp": now.Add(time.Hour).Unix()}),
+			fields: []string{`subject="revoked"`, "reason=" + ErrCodeTokenRejected},
+		},
+	} {
+		buf.Reset()
+		hf := New(testHandlerFactory, logger, nil, testRejecterFactory)
+		gin.SetMode(gin.TestMode)
+		engine := gin.New()
+		engine.GET("/orders/:id", hf(&config.EndpointConfig{
+			Endpoint:    "/orders/:id",
+			ExtraConfig: config.ExtraConfig{Namespace: map[string]interface{}{"jwks_url": issuer.url}},
+		}, proxy.NoopProxy))
+		req, _ := http.NewRequest(http.MethodGet, "/orders/1", http.NoBody)
+		req.Header.Set("Authorization", "Bearer "+tc.token)
+		engine.ServeHTTP(httptest.NewRecorder(), req)
+
+		logs := buf.String()
+		for _, field := range tc.fields {
+			if !strings.Contains(logs, field) {
+				t.Errorf("%s: the field %s is not in the audit log: %s", tc.name, field, logs)
+			}
+		}
+		if strings.Contains(logs, tc.token) {
+			t.Errorf("%s: the token was logged", tc.name)
+		}
+		if tc.name == "accepted" && strings.Contains(logs, "unverified_subject") {
+			t.Errorf("unexpected unverified subject: %s", logs)
+		}
+	}
+}
+
+func TestCheckJWK(t *testing.T) {
+	for _, tc := range []struct {
+		jwk   JWK
+		alg   string
+		valid bool
+	}{
+		{jwk: JWK{}, alg: "ES256", valid: true},
+		{jwk: JWK{Use: "sig", Alg: "ES256", KeyOps: []string{"verify"}}, alg: "ES256", valid: true},
+		{jwk: JWK{Use: "enc"}, alg: "ES256"},
+		{jwk: JWK{KeyOps: []string{"sign", "encrypt"}}, alg: "ES256"},
+		{jwk: JWK{Alg: "RS256"}, alg: "HS256"},
+	} {
+		if err := checkJWK(tc.jwk, tc.alg); (err == nil) != tc.valid {
+			t.Errorf("unexpected result for %+v and %s: %v", tc.jwk, tc.alg, err)
+		}
+	}
+}
+
+func TestGetKeyFromJWK(t *testing.T) {
+	key := newECKey(t)
+	valid := ecJWK(key, "k1", nil)
+	b, _ := json.Marshal(valid)
+	var jwk JWK
+	json.Unmarshal(b, &jwk)
+	if _, err := getKeyFromJWK(jwk); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+
+	offCurve := jwk
+	offCurve.Y = base64.RawURLEncoding.EncodeToString(make([]byte, 32))
+	short := jwk
+	short.X = base64.RawURLEncoding.EncodeToString([]byte{1})
+	for name, jwk := range map[string]JWK{
+		"point off the curve": offCurve,
+		"short coordinate":    short,
+		"unknown curve":       {Kty: "EC", Crv: "P-192", X: jwk.X, Y: jwk.Y},
+		"unknown key type":    {Kty: "oct"},
+		"short Ed25519 key":   {Kty: "OKP", Crv: "Ed25519", X: "AQ"},
+		"invalid RSA modulus": {Kty: "RSA", N: "!", E: "AQAB"},
+	} {
+		if _, err := getKeyFromJWK(jwk); err == nil {
+			t.Errorf("%s: error expected", name)
+		}
+	}
+}
+
+// serveValidator sends the request to an endpoint with the validator config, returning the
+// propagated subject in the body of the accepted requests
+func serveValidator(t *testing.T, cfg map[string]interface{}, req *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+	hf := New(testHandlerFactory, logging.NoOp, nil, testRejecterFactory)
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/orders/:id", hf(&config.EndpointConfig{
+		Endpoint:    "/orders/:id",
+		ExtraConfig: config.ExtraConfig{Namespace: cfg},
+	}, proxy.NoopProxy))
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	return w
+}
+
+func testHandlerFactory(*config.EndpointConfig, proxy.Proxy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.String(http.StatusOK, c.Request.Header.Get("X-User-Id"))
+	}
+}
+
+// testRejecterFactory rejects the tokens of the revoked subject
+var testRejecterFactory = jose.RejecterFactoryFunc(func(logging.Logger, *config.EndpointConfig) jose.Rejecter {
+	return jose.RejecterFunc(func(claims map[string]interface{}) bool {
+		return claims["sub"] == "revoked"
+	})
+})
+
+func newECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	return key
+}
+
+// ecJWK returns the public JWK of the P-256 key with the received extra members
+func ecJWK(key *ecdsa.PrivateKey, kid string, extra map[string]interface{}) map[string]interface{} {
+	jwk := map[string]interface{}{
+		"kid": kid,
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}
+	for k, v := range extra {
+		jwk[k] = v
+	}
+	return jwk
+}
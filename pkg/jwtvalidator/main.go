@@ -1,30 +1,57 @@
 package jwtvalidator
 
 import (
+	jose "api-gateway/v2/modules/krakend-jose/v2"
+	metrics "api-gateway/v2/modules/krakend-metrics/v2"
 	"api-gateway/v2/modules/lura/v2/config"
 	"api-gateway/v2/modules/lura/v2/logging"
 	"api-gateway/v2/modules/lura/v2/proxy"
 	krakendgin "api-gateway/v2/modules/lura/v2/router/gin"
+	"context"
 	"github.com/gin-gonic/gin"
 )
 
 const logPrefix = "[SERVICE: Gin][JWTValidator]"
 
+// Register adds the validator of the service config to the engine. The keys of the JWKS are
+// refreshed forever, so RegisterWithContext should be preferred.
 func Register(cfg config.ServiceConfig, l logging.Logger, engine *gin.Engine) {
+	RegisterWithContext(context.Background(), cfg, l, engine)
+}
+
+// RegisterWithContext adds the validator of the service config to the engine. The background
+// refresh of the JWKS stops once the context is done.
+func RegisterWithContext(ctx context.Context, cfg config.ServiceConfig, l logging.Logger, engine *gin.Engine) {
 	validatorCfg, err := ParseConfig(cfg.ExtraConfig)
 	if err == ErrNoConfig {
 		return
 	}
 	if err != nil {
-		l.Warning(logPrefix, err.Error())
+		// fail closed, so the routes are never served without the validation
+		l.Error(logPrefix, err.Error())
+		engine.Use(erroredHandler)
 		return
 	}
 
+	validatorCfg.ctx = ctx
 	l.Debug(logPrefix, "The JWT validator has been registered successfully")
-	engine.Use(middleware(validatorCfg, l))
+	engine.Use(middleware(validatorCfg, jose.FixedRejecter(false), newAuditor(l, logPrefix, "", nil), l))
+}
+
+// New returns a HandlerFactory validating the tokens of the endpoints with the validator config.
+// The decisions are logged and counted with the metrics collector and opencensus. The valid
+// tokens go through the rejecters of the factory, so the revoked ones are refused. The keys of
+// the JWKS are refreshed forever, so NewWithContext should be preferred.
+func New(hf krakendgin.HandlerFactory, l logging.Logger, metricCollector *metrics.Metrics, rejecterF jose.RejecterFactory) krakendgin.HandlerFactory {
+	return NewWithContext(context.Background(), hf, l, metricCollector, rejecterF)
 }
 
-func New(hf krakendgin.HandlerFactory, l logging.Logger) krakendgin.HandlerFactory {
+// NewWithContext returns a HandlerFactory as New does. The background refresh of the JWKS stops
+// once the context is done.
+func NewWithContext(ctx context.Context, hf krakendgin.HandlerFactory, l logging.Logger, metricCollector *metrics.Metrics, rejecterF jose.RejecterFactory) krakendgin.HandlerFactory {
+	if rejecterF == nil {
+		rejecterF = new(jose.NopRejecterFactory)
+	}
 	return func(cfg *config.EndpointConfig, p proxy.Proxy) gin.HandlerFunc {
 		next := hf(cfg, p)
 		logPrefix := "[ENDPOINT: " + cfg.Endpoint + "][JWTValidator]"
@@ -34,42 +61,49 @@ func New(hf krakendgin.HandlerFactory, l logging.Logger) krakendgin.HandlerFacto
 			return next
 		}
 		if err != nil {
-			l.Warning(logPrefix, err.Error())
-			return next
+			// fail closed, so the endpoint is never served without the validation
+			l.Error(logPrefix, err.Error())
+			return erroredHandler
 		}
 
+		validatorCfg.ctx = ctx
 		l.Debug(logPrefix, "The JWT validator has been registered successfully")
-		return handler(validatorCfg, next, l)
+		rejecter := rejecterF.New(l, cfg)
+		return handler(validatorCfg, rejecter, newAuditor(l, logPrefix, cfg.Endpoint, metricCollector), next, l)
 	}
 }
-func middleware(cfg *Config, l logging.Logger) gin.HandlerFunc {
+
+func middleware(cfg *Config, rejecter jose.Rejecter, a *auditor, l logging.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		statusCode, errMsg := validateJWT(cfg, c.Request, l)
-		if statusCode != 0 {
-			c.AbortWithStatusJSON(statusCode, gin.H{
-				"error": gin.H{
-					"msg": errMsg,
-				},
-			})
+		ev := newAuthEvent()
+		err := validateJWT(cfg, rejecter, c.Request, c.Params, l, ev)
+		a.record(c.Request.Context(), ev, err)
+		if err != nil {
+			err.Abort(c)
 			return
 		}
+		c.Set(jose.ClaimsContextKey, ev.claims)
 
 		c.Next()
 	}
 }
 
-func handler(cfg *Config, next gin.HandlerFunc, l logging.Logger) gin.HandlerFunc {
+func handler(cfg *Config, rejecter jose.Rejecter, a *auditor, next gin.HandlerFunc, l logging.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		statusCode, errMsg := validateJWT(cfg, c.Request, l)
-		if statusCode != 0 {
-			c.AbortWithStatusJSON(statusCode, gin.H{
-				"error": gin.H{
-					"msg": errMsg,
-				},
-			})
+		ev := newAuthEvent()
+		err := validateJWT(cfg, rejecter, c.Request, c.Params, l, ev)
+		a.record(c.Request.Context(), ev, err)
+		if err != nil {
+			err.Abort(c)
 			return
 		}
+		c.Set(jose.ClaimsContextKey, ev.claims)
 
 		next(c)
 	}
 }
+
+// erroredHandler rejects the requests of the endpoints with an invalid validator config
+func erroredHandler(c *gin.Context) {
+	errConfig.Abort(c)
+}
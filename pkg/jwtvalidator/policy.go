@@ -0,0 +1,203 @@
+package jwtvalidator
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The ways to match the scopes of a rule
+const (
+	ScopesMatcherAll = "all"
+	ScopesMatcherAny = "any"
+)
+
+// Rule is a node of an authorization policy, evaluated once the token is valid. Every condition
+// declared in a rule must be met, while the all, any and not fields combine other rules:
+//
+//	"policy": {
+//		"any": [
+//			{"name": "admins", "methods": ["DELETE"], "roles": ["admin"]},
+//			{"name": "readers", "methods": ["GET"], "scopes": ["read"]}
+//		]
+//	}
+type Rule struct {
+	// Name identifies the rule in the rejections. It defaults to its position in the policy
+	Name string  `json:"name"`
+	All  []*Rule `json:"all"`
+	Any  []*Rule `json:"any"`
+	Not  *Rule   `json:"not"`
+
+	// Methods lists the accepted HTTP methods
+	Methods []string `json:"methods"`
+	// Params maps the path params of the endpoint to their accepted values
+	Params map[string][]string `json:"params"`
+	// Roles lists the roles granting the access. Any of them is enough
+	Roles []string `json:"roles"`
+	// Scopes lists the required scopes
+	Scopes        []string `json:"scopes"`
+	ScopesMatcher string   `json:"scopes_matcher"` // default value is "all"
+	// Claims restricts the values of the claims
+	Claims []*ClaimConstraint `json:"claims"`
+}
+
+// compile validates the rule and its children, naming the anonymous ones after their path
+func (r *Rule) compile(path string) error {
+	if r.Name == "" {
+		r.Name = path
+	}
+	if len(r.All) == 0 && len(r.Any) == 0 && r.Not == nil && len(r.Methods) == 0 && len(r.Params) == 0 &&
+		len(r.Roles) == 0 && len(r.Scopes) == 0 && len(r.Claims) == 0 {
+		return fmt.Errorf("the policy rule %s has no conditions", r.Name)
+	}
+
+	switch r.ScopesMatcher {
+	case "":
+		r.ScopesMatcher = ScopesMatcherAll
+	case ScopesMatcherAll, ScopesMatcherAny:
+	default:
+		return fmt.Errorf("the policy rule %s has an unknown scopes_matcher %q", r.Name, r.ScopesMatcher)
+	}
+	for i, m := range r.Methods {
+		r.Methods[i] = strings.ToUpper(m)
+	}
+	for _, c := range r.Claims {
+		if c == nil {
+			return fmt.Errorf("the policy rule %s has an invalid claim constraint", r.Name)
+		}
+		if err := c.compile(); err != nil {
+			return fmt.Errorf("the policy rule %s: %w", r.Name, err)
+		}
+	}
+
+	for i, child := range r.All {
+		if child == nil {
+			return errors.New("invalid policy rule in " + r.Name)
+		}
+		if err := child.compile(fmt.Sprintf("%s.all[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	for i, child := range r.Any {
+		if child == nil {
+			return errors.New("invalid policy rule in " + r.Name)
+		}
+		if err := child.compile(fmt.Sprintf("%s.any[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	if r.Not != nil {
+		return r.Not.compile(path + ".not")
+	}
+	return nil
+}
+
+// policyRequest holds the parts of the request the rules are evaluated against
+type policyRequest struct {
+	method string
+	params gin.Params
+	claims map[string]interface{}
+	roles  []string
+	scopes []string
+}
+
+func newPolicyRequest(cfg *Config, req *http.Request, params gin.Params, claims map[string]interface{}) *policyRequest {
+	roles, _ := ClaimValue(claims, cfg.RolesClaim)
+	scopes, _ := ClaimValue(claims, cfg.ScopesClaim)
+	var scopeList []string
+	// the scopes are usually sent as a space-delimited string
+	for _, s := range ClaimValues(scopes) {
+		scopeList = append(scopeList, strings.Fields(s)...)
+	}
+	return &policyRequest{
+		method: req.Method,
+		params: params,
+		claims: claims,
+		roles:  ClaimValues(roles),
+		scopes: scopeList,
+	}
+}
+
+// evaluate returns the deepest rule not satisfied by the request, or nil if the access is granted
+func (r *Rule) evaluate(req *policyRequest) *Rule {
+	if !r.conditionsMet(req) {
+		return r
+	}
+
+	for _, child := range r.All {
+		if failed := child.evaluate(req); failed != nil {
+			return failed
+		}
+	}
+	if len(r.Any) > 0 {
+		granted := false
+		for _, child := range r.Any {
+			if child.evaluate(req) == nil {
+				granted = true
+				break
+			}
+		}
+		if !granted {
+			return r
+		}
+	}
+	if r.Not != nil && r.Not.evaluate(req) == nil {
+		return r
+	}
+	return nil
+}
+
+func (r *Rule) conditionsMet(req *policyRequest) bool {
+	if len(r.Methods) > 0 && !containsAny([]string{req.method}, r.Methods) {
+		return false
+	}
+	for name, accepted := range r.Params {
+		v, ok := req.params.Get(name)
+		if !ok || !containsAny([]string{v}, accepted) {
+			return false
+		}
+	}
+	if len(r.Roles) > 0 && !containsAny(req.roles, r.Roles) {
+		return false
+	}
+	if len(r.Scopes) > 0 {
+		if r.ScopesMatcher == ScopesMatcherAny {
+			if !containsAny(req.scopes, r.Scopes) {
+				return false
+			}
+		} else {
+			for _, s := range r.Scopes {
+				if !containsAny(req.scopes, []string{s}) {
+					return false
+				}
+			}
+		}
+	}
+	for _, c := range r.Claims {
+		if c.check(req.claims) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// checkPolicy evaluates the policy of the config, if any, returning a forbidden error naming
+// the failing rule
+func checkPolicy(cfg *Config, req *http.Request, params gin.Params, claims map[string]interface{}) *ValidationError {
+	if cfg.Policy == nil {
+		return nil
+	}
+	failed := cfg.Policy.evaluate(newPolicyRequest(cfg, req, params, claims))
+	if failed == nil {
+		return nil
+	}
+	return &ValidationError{
+		Status: http.StatusForbidden,
+		Code:   ErrCodePolicyDenied,
+		Msg:    fmt.Sprintf("Forbidden: The policy rule %s is not satisfied.", failed.Name),
+		Rule:   failed.Name,
+	}
+}
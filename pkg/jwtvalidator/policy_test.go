@@ -0,0 +1,123 @@
+package jwtvalidator
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCheckPolicy(t *testing.T) {
+	policy := `{
+		"any": [
+			{"name": "admins", "roles": ["admin"]},
+			{
+				"name": "readers",
+				"all": [
+					{"name": "read methods", "methods": ["get", "head"]},
+					{"name": "read scopes", "scopes": ["orders:read", "orders:list"], "scopes_matcher": "any"}
+				],
+				"not": {"name": "blocked tenant", "claims": [{"claim": "tenant.id", "equals": "blocked"}]}
+			},
+			{"name": "owner", "params": {"id": ["1"]}, "scopes": ["profile", "orders:write"]}
+		]
+	}`
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		id     string
+		claims map[string]interface{}
+		rule   string
+	}{
+		{name: "admin", method: http.MethodDelete, id: "2", claims: map[string]interface{}{"roles": []interface{}{"user", "admin"}}},
+		{name: "reader", method: http.MethodGet, id: "2", claims: map[string]interface{}{"scope": "profile orders:list"}},
+		{name: "reader with a scope array", method: http.MethodHead, id: "2", claims: map[string]interface{}{"scope": []interface{}{"orders:read"}}},
+		{name: "reader of a blocked tenant", method: http.MethodGet, id: "2", claims: map[string]interface{}{"scope": "orders:read", "tenant": map[string]interface{}{"id": "blocked"}}, rule: "policy"},
+		{name: "writer without the write scopes", method: http.MethodPost, id: "1", claims: map[string]interface{}{"scope": "profile"}, rule: "policy"},
+		{name: "writer with all the scopes", method: http.MethodPost, id: "1", claims: map[string]interface{}{"scope": "orders:write profile"}},
+		{name: "writer of another resource", method: http.MethodPost, id: "2", claims: map[string]interface{}{"scope": "orders:write profile"}, rule: "policy"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &Config{RolesClaim: "roles", ScopesClaim: "scope"}
+			if err := json.Unmarshal([]byte(policy), &cfg.Policy); err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if err := cfg.Policy.compile("policy"); err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			req, _ := http.NewRequest(tc.method, "/orders/"+tc.id, http.NoBody)
+			verr := checkPolicy(cfg, req, gin.Params{{Key: "id", Value: tc.id}}, tc.claims)
+			if tc.rule == "" {
+				if verr != nil {
+					t.Errorf("unexpected error: %s", verr.Error())
+				}
+				return
+			}
+			if verr == nil {
+				t.Error("the access was granted")
+				return
+			}
+			if verr.Status != http.StatusForbidden || verr.Code != ErrCodePolicyDenied || verr.Rule != tc.rule {
+				t.Errorf("unexpected error: %+v", verr)
+			}
+		})
+	}
+}
+
+func TestRule_evaluate(t *testing.T) {
+	req := &policyRequest{method: http.MethodGet, roles: []string{"user"}, scopes: []string{"read"}}
+
+	for _, tc := range []struct {
+		name   string
+		policy string
+		failed string
+	}{
+		{name: "all met", policy: `{"all": [{"methods": ["GET"]}, {"roles": ["user"]}]}`},
+		{name: "all failing", policy: `{"all": [{"methods": ["GET"]}, {"roles": ["admin"]}]}`, failed: "p.all[1]"},
+		{name: "nested all failing", policy: `{"all": [{"all": [{"scopes": ["write"]}]}]}`, failed: "p.all[0].all[0]"},
+		{name: "any met", policy: `{"any": [{"roles": ["admin"]}, {"scopes": ["read"]}]}`},
+		{name: "any failing", policy: `{"any": [{"roles": ["admin"]}, {"scopes": ["write"]}]}`, failed: "p"},
+		{name: "not met", policy: `{"not": {"roles": ["banned"]}}`},
+		{name: "not failing", policy: `{"not": {"roles": ["user"]}}`, failed: "p"},
+		{name: "conditions of a combined rule", policy: `{"methods": ["POST"], "any": [{"roles": ["user"]}]}`, failed: "p"},
+		{name: "all the scopes", policy: `{"scopes": ["read", "write"]}`, failed: "p"},
+		{name: "any of the scopes", policy: `{"scopes": ["read", "write"], "scopes_matcher": "any"}`},
+	} {
+		var rule *Rule
+		if err := json.Unmarshal([]byte(tc.policy), &rule); err != nil {
+			t.Fatalf("%s: unexpected error: %s", tc.name, err.Error())
+		}
+		if err := rule.compile("p"); err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.name, err.Error())
+			continue
+		}
+		failed := rule.evaluate(req)
+		if tc.failed == "" && failed != nil {
+			t.Errorf("%s: unexpected failed rule %s", tc.name, failed.Name)
+		}
+		if tc.failed != "" && (failed == nil || failed.Name != tc.failed) {
+			t.Errorf("%s: unexpected failed rule. have: %v, want: %s", tc.name, failed, tc.failed)
+		}
+	}
+}
+
+func TestRule_compile(t *testing.T) {
+	for name, policy := range map[string]string{
+		"empty rule":              `{}`,
+		"empty child":             `{"all": [{"roles": ["a"]}, {}]}`,
+		"null child":              `{"any": [null]}`,
+		"unknown scopes matcher":  `{"scopes": ["a"], "scopes_matcher": "some"}`,
+		"invalid claim":           `{"claims": [{"claim": "sub"}]}`,
+		"invalid negated pattern": `{"not": {"claims": [{"claim": "sub", "matches": "("}]}}`,
+	} {
+		var rule *Rule
+		if err := json.Unmarshal([]byte(policy), &rule); err != nil {
+			t.Fatalf("%s: unexpected error: %s", name, err.Error())
+		}
+		if err := rule.compile("p"); err == nil {
+			t.Errorf("%s: error expected", name)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package jwtvalidator
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	jose "api-gateway/v2/modules/krakend-jose/v2"
+)
+
+// The types of the token sources
+const (
+	TokenSourceHeader = "header"
+	TokenSourceCookie = "cookie"
+	TokenSourceQuery  = "query"
+)
+
+const defaultTokenHeader = "Authorization"
+
+// TokenSource is a place of the request where the token can be sent. The header sources strip
+// the scheme, compared case-insensitively, from the value. An empty scheme means the header
+// contains the raw token.
+type TokenSource struct {
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Scheme string `json:"scheme"`
+
+	// dpop accepts the DPoP scheme along with the Bearer one
+	dpop bool
+}
+
+// DefaultTokenSources returns the source used when the config does not declare any: the
+// access_token_header_key header, with the Bearer scheme for the Authorization header
+func DefaultTokenSources(headerKey string) []TokenSource {
+	if headerKey == "" {
+		headerKey = defaultTokenHeader
+	}
+	src := TokenSource{Type: TokenSourceHeader, Name: headerKey}
+	if strings.EqualFold(headerKey, defaultTokenHeader) {
+		src.Scheme = "Bearer"
+	}
+	return []TokenSource{src}
+}
+
+func (s TokenSource) Validate() error {
+	switch s.Type {
+	case TokenSourceHeader, TokenSourceCookie, TokenSourceQuery:
+	default:
+		return fmt.Errorf("unknown token source type %q", s.Type)
+	}
+	if s.Name == "" {
+		return fmt.Errorf("the %s token source requires a name", s.Type)
+	}
+	return nil
+}
+
+// ExtractToken returns the token of the first source present in the request. A source present
+// but malformed fails the extraction instead of falling back to the next ones.
+func ExtractToken(sources []TokenSource, req *http.Request) (string, *ValidationError) {
+	token, _, verr := extractToken(sources, req)
+	return token, verr
+}
+
+// extractToken also returns the scheme of the header the token was sent with
+func extractToken(sources []TokenSource, req *http.Request) (string, string, *ValidationError) {
+	for _, src := range sources {
+		switch src.Type {
+		case TokenSourceHeader:
+			value := req.Header.Get(src.Name)
+			if value == "" {
+				continue
+			}
+			if src.Scheme == "" {
+				return value, "", nil
+			}
+			parts := strings.Split(value, " ")
+			if len(parts) != 2 || !src.acceptsScheme(parts[0]) || parts[1] == "" {
+				return "", "", unauthorized(ErrCodeMissingToken, fmt.Sprintf("%s header format must be '%s {token}'", src.Name, src.Scheme))
+			}
+			return parts[1], parts[0], nil
+
+		case TokenSourceCookie:
+			if c, err := req.Cookie(src.Name); err == nil && c.Value != "" {
+				return c.Value, "", nil
+			}
+
+		case TokenSourceQuery:
+			if v := req.URL.Query().Get(src.Name); v != "" {
+				return v, "", nil
+			}
+		}
+	}
+	return "", "", unauthorized(ErrCodeMissingToken, "Unauthorized: The request does not contain a token.")
+}
+
+func (s TokenSource) acceptsScheme(scheme string) bool {
+	return strings.EqualFold(scheme, s.Scheme) || (s.dpop && strings.EqualFold(scheme, jose.DPoPScheme))
+}
@@ -0,0 +1,77 @@
+package grpc
+
+import (
+	"fmt"
+
+	"api-gateway/v2/modules/lura/v2/config"
+)
+
+// NewConfigParser wraps the received parser so the parsing fails when a gRPC backend declares
+// descriptors that can not be loaded or that do not contain the service and method referenced
+// by its url_pattern
+func NewConfigParser(p config.Parser) config.Parser {
+	if ls, ok := p.(lastSourcer); ok {
+		return lastSourceConfigParser{configParser{p}, ls}
+	}
+	return configParser{p}
+}
+
+type lastSourcer interface {
+	LastSource() ([]byte, error)
+}
+
+type configParser struct {
+	config.Parser
+}
+
+// Parse implements the config.Parser interface
+func (p configParser) Parse(path string) (config.ServiceConfig, error) {
+	cfg, err := p.Parser.Parse(path)
+	if err != nil {
+		return cfg, err
+	}
+	return cfg, CheckConfig(cfg)
+}
+
+// lastSourceConfigParser keeps exposing the last source of the wrapped parser, so the
+// schema validation of the check command keeps working over the rendered templates
+type lastSourceConfigParser struct {
+	configParser
+	lastSourcer
+}
+
+// CheckConfig verifies the services and methods of all the gRPC backends with declared
+// descriptors
+func CheckConfig(cfg config.ServiceConfig) error {
+	for _, e := range cfg.Endpoints {
+		for _, b := range e.Backend {
+			if !IsGrpcMethod(b) {
+				continue
+			}
+			gcfg, err := ConfigGetter(b)
+			if err != nil {
+				return fmt.Errorf("endpoint %s: %s", e.Endpoint, err.Error())
+			}
+			descriptors, err := LoadDescriptors(gcfg)
+			if err != nil {
+				return fmt.Errorf("endpoint %s: %s", e.Endpoint, err.Error())
+			}
+			if descriptors == nil {
+				continue
+			}
+			if err := checkBackend(b, descriptors); err != nil {
+				return fmt.Errorf("endpoint %s: %s", e.Endpoint, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+func checkBackend(remote *config.Backend, descriptors *DescriptorRegistry) error {
+	serviceName, methodName, err := parseURLPattern(remote.URLPattern)
+	if err != nil {
+		return err
+	}
+	_, err = descriptors.FindMethod(serviceName, methodName)
+	return err
+}
@@ -0,0 +1,235 @@
+package grpc
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"api-gateway/v2/modules/lura/v2/config"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	// DefaultIdleTimeout is the time a pooled connection can stay unused before being closed
+	DefaultIdleTimeout = 5 * time.Minute
+	// DefaultHealthCheckInterval is the period between two consecutive checks of the pooled connections
+	DefaultHealthCheckInterval = 30 * time.Second
+)
+
+var (
+	grpcKeys = []string{"backend/grpc", Namespace}
+
+	// ErrNoExtraCfg is the error returned when the backend has no gRPC extra config
+	ErrNoExtraCfg = errors.New("no extra config")
+)
+
+// Config is the custom config struct containing the params for the gRPC backends
+type Config struct {
+	// IdleTimeout is the max time a pooled connection can stay unused
+	IdleTimeout time.Duration
+	// HealthCheckInterval is the period of the pool maintenance loop
+	HealthCheckInterval time.Duration
+	// HealthCheck enables the grpc.health.v1 probe of the pooled connections
+	HealthCheck bool
+	// ReflectionRefresh is the max age of a cached service descriptor. Zero means
+	// the descriptors are kept until the connection is evicted
+	ReflectionRefresh time.Duration
+	// Protosets is the list of compiled FileDescriptorSet files to load at startup
+	Protosets []string
+	// ProtoFiles is the list of proto sources to parse at startup
+	ProtoFiles []string
+	// ImportPaths is the list of folders where the proto sources and their imports are looked up
+	ImportPaths []string
+	// TLS enables the TLS transport with the given params. Plaintext connections are used if nil
+	TLS *config.ClientTLS
+	// ServerName overrides the server name used to verify the certificate of the backend
+	ServerName string
+	// Credentials are the per-RPC credentials to attach to every call
+	Credentials *CredentialsConfig
+	// ForwardHeaders is the allow-list of request headers to send as metadata. The
+	// headers must also be declared in the input_headers of the endpoint
+	ForwardHeaders []string
+	// MetadataMapping renames the forwarded headers (canonical header name to metadata key)
+	MetadataMapping map[string]string
+	// ReturnMetadata copies the headers and trailers of the backend response into the
+	// response headers
+	ReturnMetadata bool
+	// ResponseHeadersPrefix is the prefix to add to the returned metadata keys
+	ResponseHeadersPrefix string
+	// Body is the path of the field where the request body is decoded. The whole
+	// message is used by default
+	Body string
+	// FieldMapping translates the names of the params and query strings into the paths
+	// of the fields to fill
+	FieldMapping map[string]string
+	// StatusMapping overrides the canonical HTTP status codes of the gRPC codes
+	StatusMapping map[codes.Code]int
+	// ReturnErrorDetails is the name used to embed the errors in the response data. If empty,
+	// the errors are returned to the router
+	ReturnErrorDetails string
+	// StreamFormat is the encoding of the messages of the server-streaming methods
+	StreamFormat string
+	// StreamTimeout bounds the life of the server streams. The streams are not bound to the
+	// timeout of the endpoint, so they last until the client or the backend closes them if zero
+	StreamTimeout time.Duration
+}
+
+// ConfigGetter parses the extra config of the backend and returns a Config with the
+// default values for all the undefined params
+func ConfigGetter(remote *config.Backend) (Config, error) {
+	cfg := Config{
+		IdleTimeout:         DefaultIdleTimeout,
+		HealthCheckInterval: DefaultHealthCheckInterval,
+		StreamFormat:        StreamFormatSSE,
+	}
+
+	tmp, ok := extraConfig(remote)
+	if !ok {
+		return cfg, ErrNoExtraCfg
+	}
+
+	var err error
+	if v, ok := tmp["idle_timeout"]; ok {
+		if cfg.IdleTimeout, err = parseDuration("idle_timeout", v); err != nil {
+			return cfg, err
+		}
+	}
+	if v, ok := tmp["health_check_interval"]; ok {
+		if cfg.HealthCheckInterval, err = parseDuration("health_check_interval", v); err != nil {
+			return cfg, err
+		}
+	}
+	if v, ok := tmp["health_check"].(bool); ok {
+		cfg.HealthCheck = v
+	}
+	if v, ok := tmp["reflection_refresh"]; ok {
+		if cfg.ReflectionRefresh, err = parseDuration("reflection_refresh", v); err != nil {
+			return cfg, err
+		}
+	}
+	if v, ok := tmp["protosets"]; ok {
+		cfg.Protosets = parseStringList(v)
+	}
+	if v, ok := tmp["proto_files"]; ok {
+		cfg.ProtoFiles = parseStringList(v)
+	}
+	if v, ok := tmp["import_paths"]; ok {
+		cfg.ImportPaths = parseStringList(v)
+	}
+	if v, ok := tmp["client_tls"].(map[string]interface{}); ok {
+		cfg.TLS = parseClientTLS(v)
+	}
+	if v, ok := tmp["server_name"].(string); ok {
+		cfg.ServerName = v
+	}
+	if v, ok := tmp["per_rpc_credentials"].(map[string]interface{}); ok {
+		cfg.Credentials = parseCredentials(v)
+	}
+	if v, ok := tmp["forward_headers"]; ok {
+		cfg.ForwardHeaders = parseStringList(v)
+	}
+	if v, ok := tmp["metadata_mapping"].(map[string]interface{}); ok {
+		cfg.MetadataMapping = make(map[string]string, len(v))
+		for header, key := range v {
+			if k, ok := key.(string); ok {
+				cfg.MetadataMapping[http.CanonicalHeaderKey(header)] = k
+			}
+		}
+	}
+	if v, ok := tmp["return_metadata"].(bool); ok {
+		cfg.ReturnMetadata = v
+	}
+	if v, ok := tmp["response_headers_prefix"].(string); ok {
+		cfg.ResponseHeadersPrefix = v
+	}
+	if v, ok := tmp["body"].(string); ok {
+		cfg.Body = v
+	}
+	if v, ok := tmp["field_mapping"].(map[string]interface{}); ok {
+		cfg.FieldMapping = make(map[string]string, len(v))
+		for name, path := range v {
+			if p, ok := path.(string); ok {
+				cfg.FieldMapping[name] = p
+			}
+		}
+	}
+	if v, ok := tmp["status_mapping"].(map[string]interface{}); ok {
+		cfg.StatusMapping = make(map[codes.Code]int, len(v))
+		for name, s := range v {
+			code, ok := parseCode(name)
+			if !ok {
+				return cfg, fmt.Errorf("grpc: unknown status code %s in the status_mapping", name)
+			}
+			switch st := s.(type) {
+			case int:
+				cfg.StatusMapping[code] = st
+			case int64:
+				cfg.StatusMapping[code] = int(st)
+			case float64:
+				cfg.StatusMapping[code] = int(st)
+			default:
+				return cfg, fmt.Errorf("grpc: invalid HTTP status for %s in the status_mapping", name)
+			}
+		}
+	}
+	if v, ok := tmp["return_error_details"].(string); ok {
+		cfg.ReturnErrorDetails = v
+	}
+	if v, ok := tmp["stream_format"].(string); ok {
+		if _, ok := streamContentTypes[v]; !ok {
+			return cfg, fmt.Errorf("grpc: unknown stream_format %s", v)
+		}
+		cfg.StreamFormat = v
+	}
+	if v, ok := tmp["stream_timeout"]; ok {
+		if cfg.StreamTimeout, err = parseDuration("stream_timeout", v); err != nil {
+			return cfg, err
+		}
+	}
+
+	return cfg, nil
+}
+
+// extraConfig returns the gRPC section of the backend extra config, looking for it under
+// every accepted namespace
+func extraConfig(remote *config.Backend) (map[string]interface{}, bool) {
+	for _, key := range grpcKeys {
+		v, ok := remote.ExtraConfig[key]
+		if !ok {
+			continue
+		}
+		tmp, ok := v.(map[string]interface{})
+		if !ok {
+			return map[string]interface{}{}, true
+		}
+		return tmp, true
+	}
+	return nil, false
+}
+
+func parseDuration(name string, v interface{}) (time.Duration, error) {
+	d, err := time.ParseDuration(fmt.Sprintf("%v", v))
+	if err != nil {
+		return 0, fmt.Errorf("grpc: invalid %s: %s", name, err.Error())
+	}
+	return d, nil
+}
+
+func parseStringList(v interface{}) []string {
+	switch vs := v.(type) {
+	case []string:
+		return vs
+	case []interface{}:
+		res := make([]string, 0, len(vs))
+		for _, v := range vs {
+			if s, ok := v.(string); ok {
+				res = append(res, s)
+			}
+		}
+		return res
+	case string:
+		return []string{vs}
+	}
+	return nil
+}
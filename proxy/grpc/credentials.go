@@ -0,0 +1,154 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+
+	oauth2client "api-gateway/v2/modules/krakend-oauth2-clientcredentials/v2"
+	"api-gateway/v2/modules/lura/v2/config"
+	"api-gateway/v2/modules/lura/v2/logging"
+	"api-gateway/v2/modules/lura/v2/transport/http/server"
+	"golang.org/x/oauth2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// CredentialsConfig defines the credentials to attach to every call sent to the backend
+type CredentialsConfig struct {
+	// BearerToken is a static token to send in the authorization metadata
+	BearerToken string
+	// ClientCredentials is the config of the oauth2 client credentials grant used to get
+	// the tokens to send in the authorization metadata
+	ClientCredentials *oauth2client.Config
+	// AllowInsecure allows sending the credentials over plaintext connections
+	AllowInsecure bool
+}
+
+// dialOptions returns the transport and per-RPC credentials declared in the config
+func dialOptions(cfg Config, logger logging.Logger) []grpc.DialOption {
+	opts := []grpc.DialOption{transportCredentials(cfg, logger)}
+	if c := perRPCCredentials(cfg.Credentials); c != nil {
+		opts = append(opts, grpc.WithPerRPCCredentials(c))
+	}
+	return opts
+}
+
+func transportCredentials(cfg Config, logger logging.Logger) grpc.DialOption {
+	if cfg.TLS == nil {
+		return grpc.WithTransportCredentials(insecure.NewCredentials())
+	}
+	tlsConfig := server.ParseClientTLSConfigWithLogger(cfg.TLS, logger)
+	if cfg.ServerName != "" {
+		tlsConfig.ServerName = cfg.ServerName
+	}
+	return grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))
+}
+
+func perRPCCredentials(cfg *CredentialsConfig) credentials.PerRPCCredentials {
+	if cfg == nil {
+		return nil
+	}
+	var ts oauth2.TokenSource
+	switch {
+	case cfg.ClientCredentials != nil && !cfg.ClientCredentials.IsDisabled:
+		ts = oauth2client.NewTokenSource(context.Background(), *cfg.ClientCredentials)
+	case cfg.BearerToken != "":
+		ts = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: cfg.BearerToken, TokenType: "Bearer"})
+	default:
+		return nil
+	}
+	return tokenCredentials{ts: ts, requireTLS: !cfg.AllowInsecure}
+}
+
+// tokenCredentials is a credentials.PerRPCCredentials injecting the tokens of an oauth2.TokenSource
+type tokenCredentials struct {
+	ts         oauth2.TokenSource
+	requireTLS bool
+}
+
+// GetRequestMetadata implements the credentials.PerRPCCredentials interface
+func (t tokenCredentials) GetRequestMetadata(_ context.Context, _ ...string) (map[string]string, error) {
+	token, err := t.ts.Token()
+	if err != nil {
+		return nil, fmt.Errorf("grpc: getting the token for the per-RPC credentials: %s", err.Error())
+	}
+	return map[string]string{"authorization": token.Type() + " " + token.AccessToken}, nil
+}
+
+// RequireTransportSecurity implements the credentials.PerRPCCredentials interface
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return t.requireTLS
+}
+
+func parseCredentials(tmp map[string]interface{}) *CredentialsConfig {
+	cfg := &CredentialsConfig{}
+	if v, ok := tmp["bearer_token"].(string); ok {
+		cfg.BearerToken = v
+	}
+	if v, ok := tmp["client_credentials"].(map[string]interface{}); ok {
+		cc := oauth2client.ParseConfig(v)
+		cfg.ClientCredentials = &cc
+	}
+	if v, ok := tmp["allow_insecure"].(bool); ok {
+		cfg.AllowInsecure = v
+	}
+	return cfg
+}
+
+func parseClientTLS(tmp map[string]interface{}) *config.ClientTLS {
+	cfg := &config.ClientTLS{}
+	if v, ok := tmp["allow_insecure_connections"].(bool); ok {
+		cfg.AllowInsecureConnections = v
+	}
+	if v, ok := tmp["ca_certs"]; ok {
+		cfg.CaCerts = parseStringList(v)
+	}
+	if v, ok := tmp["disable_system_ca_pool"].(bool); ok {
+		cfg.DisableSystemCaPool = v
+	}
+	if v, ok := tmp["min_version"].(string); ok {
+		cfg.MinVersion = v
+	}
+	if v, ok := tmp["max_version"].(string); ok {
+		cfg.MaxVersion = v
+	}
+	if v, ok := tmp["curve_preferences"]; ok {
+		cfg.CurvePreferences = parseUint16List(v)
+	}
+	if v, ok := tmp["cipher_suites"]; ok {
+		cfg.CipherSuites = parseUint16List(v)
+	}
+	if vs, ok := tmp["client_certs"].([]interface{}); ok {
+		for _, v := range vs {
+			cert, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			c := config.ClientTLSCert{}
+			c.Certificate, _ = cert["certificate"].(string)
+			c.PrivateKey, _ = cert["private_key"].(string)
+			cfg.ClientCerts = append(cfg.ClientCerts, c)
+		}
+	}
+	return cfg
+}
+
+func parseUint16List(v interface{}) []uint16 {
+	vs, ok := v.([]interface{})
+	if !ok {
+		return nil
+	}
+	res := make([]uint16, 0, len(vs))
+	for _, v := range vs {
+		switch val := v.(type) {
+		case int:
+			res = append(res, uint16(val))
+		case int64:
+			res = append(res, uint16(val))
+		case float64:
+			res = append(res, uint16(val))
+		}
+	}
+	return res
+}
@@ -0,0 +1,159 @@
+package grpc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+var (
+	// ErrServiceNotFound is the error returned when a service is not declared in the descriptors
+	ErrServiceNotFound = errors.New("grpc: service not found in the descriptors")
+	// ErrMethodNotFound is the error returned when a method is not declared in the service descriptor
+	ErrMethodNotFound = errors.New("grpc: method not found in the service descriptor")
+
+	descriptorsCache = map[string]*DescriptorRegistry{}
+	descriptorsMu    = new(sync.Mutex)
+)
+
+// DescriptorRegistry holds the service descriptors loaded from compiled protosets and
+// proto sources, so they can be used without relying on the server reflection
+type DescriptorRegistry struct {
+	files    []*desc.FileDescriptor
+	services map[string]*desc.ServiceDescriptor
+}
+
+// NewDescriptorRegistry indexes all the services declared in the received files and
+// their dependencies
+func NewDescriptorRegistry(files []*desc.FileDescriptor) *DescriptorRegistry {
+	r := &DescriptorRegistry{
+		files:    files,
+		services: map[string]*desc.ServiceDescriptor{},
+	}
+	visited := map[string]struct{}{}
+	for _, fd := range files {
+		r.index(fd, visited)
+	}
+	return r
+}
+
+func (r *DescriptorRegistry) index(fd *desc.FileDescriptor, visited map[string]struct{}) {
+	if _, ok := visited[fd.GetName()]; ok {
+		return
+	}
+	visited[fd.GetName()] = struct{}{}
+
+	for _, sd := range fd.GetServices() {
+		r.services[sd.GetFullyQualifiedName()] = sd
+	}
+	for _, dep := range fd.GetDependencies() {
+		r.index(dep, visited)
+	}
+}
+
+// Files returns the file descriptors the registry was built with
+func (r *DescriptorRegistry) Files() []*desc.FileDescriptor {
+	return r.files
+}
+
+// FindService returns the descriptor of the service with the received fully qualified name
+func (r *DescriptorRegistry) FindService(serviceName string) (*desc.ServiceDescriptor, bool) {
+	sd, ok := r.services[serviceName]
+	return sd, ok
+}
+
+// FindMethod returns the descriptor of the method of the service
+func (r *DescriptorRegistry) FindMethod(serviceName, methodName string) (*desc.MethodDescriptor, error) {
+	sd, ok := r.FindService(serviceName)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrServiceNotFound, serviceName)
+	}
+	md := sd.FindMethodByName(methodName)
+	if md == nil {
+		return nil, fmt.Errorf("%w: %s/%s", ErrMethodNotFound, serviceName, methodName)
+	}
+	return md, nil
+}
+
+// LoadDescriptors builds a DescriptorRegistry with the protosets and proto sources declared
+// in the config. It returns nil if the config does not declare any of them. Registries are
+// cached, so backends sharing the same set of files also share the registry.
+func LoadDescriptors(cfg Config) (*DescriptorRegistry, error) {
+	if len(cfg.Protosets) == 0 && len(cfg.ProtoFiles) == 0 {
+		return nil, nil
+	}
+
+	key := descriptorsCacheKey(cfg)
+
+	descriptorsMu.Lock()
+	defer descriptorsMu.Unlock()
+
+	if r, ok := descriptorsCache[key]; ok {
+		return r, nil
+	}
+
+	var files []*desc.FileDescriptor
+	for _, path := range cfg.Protosets {
+		fds, err := loadProtoset(path)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, fds...)
+	}
+
+	if len(cfg.ProtoFiles) > 0 {
+		// the source info keeps the comments, so the reflection service of the gRPC router
+		// describes them
+		p := protoparse.Parser{ImportPaths: cfg.ImportPaths, IncludeSourceCodeInfo: true}
+		fds, err := p.ParseFiles(cfg.ProtoFiles...)
+		if err != nil {
+			return nil, fmt.Errorf("grpc: parsing the proto files: %s", err.Error())
+		}
+		files = append(files, fds...)
+	}
+
+	r := NewDescriptorRegistry(files)
+	descriptorsCache[key] = r
+	return r, nil
+}
+
+func loadProtoset(path string) ([]*desc.FileDescriptor, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("grpc: reading the protoset %s: %s", path, err.Error())
+	}
+	fds := new(descriptorpb.FileDescriptorSet)
+	if err := proto.Unmarshal(b, fds); err != nil {
+		return nil, fmt.Errorf("grpc: decoding the protoset %s: %s", path, err.Error())
+	}
+	files, err := desc.CreateFileDescriptorsFromSet(fds)
+	if err != nil {
+		return nil, fmt.Errorf("grpc: linking the protoset %s: %s", path, err.Error())
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	res := make([]*desc.FileDescriptor, len(names))
+	for i, name := range names {
+		res[i] = files[name]
+	}
+	return res, nil
+}
+
+func descriptorsCacheKey(cfg Config) string {
+	return strings.Join(cfg.Protosets, ",") + "|" +
+		strings.Join(cfg.ProtoFiles, ",") + "|" +
+		strings.Join(cfg.ImportPaths, ",")
+}
@@ -4,16 +4,19 @@ import (
 	"api-gateway/v2/modules/lura/v2/config"
 	"api-gateway/v2/modules/lura/v2/logging"
 	"api-gateway/v2/modules/lura/v2/proxy"
+	"api-gateway/v2/modules/lura/v2/sd"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"io"
+	"net/http"
 	"net/url"
-	"regexp"
 	"strings"
-	"time"
+	"sync"
 )
 
 const (
@@ -21,98 +24,197 @@ const (
 	EncodingPrefix = "grpc-"
 )
 
-func trimPrefixes(s string) string {
-	re, err := regexp.Compile(`^(?:http://|https://|gpc://)`)
+// dialTarget returns the address to dial for the host returned by the balancer. The scheme
+// of the host, added by the config parser or by the sd_scheme, is not relevant for gRPC.
+func dialTarget(host string) (string, error) {
+	if !strings.Contains(host, "://") {
+		return host, nil
+	}
+	u, err := url.Parse(host)
 	if err != nil {
-		fmt.Println("Regex compile error:", err)
-		return s
+		return "", err
 	}
-	return re.ReplaceAllString(s, "")
-}
-
-func RoundRobinHostSelector(hosts []string) func() string {
-	var next int
-	return func() string {
-		host := hosts[next%len(hosts)]
-		next++
-		return host
+	if u.Host == "" {
+		return "", fmt.Errorf("grpc: invalid host %s", host)
 	}
-}
-
-// ErrorResponse to encapsulate an error response
-type ErrorResponse struct {
-	StatusCode int    `json:"status_code"`
-	Message    string `json:"message"`
+	return u.Host, nil
 }
 
 // IsGrpcMethod checks if the given backend configuration is designated for gRPC.
 func IsGrpcMethod(remote *config.Backend) bool {
-	grpcKeys := []string{"backend/grpc", "github.com/davron112/grpc"}
-
-	for _, key := range grpcKeys {
-		if _, exists := remote.ExtraConfig[key]; exists {
-			return true
-		}
-	}
-	return false
+	_, ok := extraConfig(remote)
+	return ok
 }
 
+// NewGrpcBackendFactory returns a BackendFactory handling the gRPC backends. Their connections are
+// never released, so NewGrpcBackendFactoryWithContext should be preferred.
 func NewGrpcBackendFactory(logger logging.Logger, bf proxy.BackendFactory) proxy.BackendFactory {
+	return NewGrpcBackendFactoryWithContext(context.Background(), logger, bf)
+}
 
+// NewGrpcBackendFactoryWithContext returns a BackendFactory handling the gRPC backends. Every backend
+// gets its own pool of connections, closed when the received context is done.
+func NewGrpcBackendFactoryWithContext(ctx context.Context, logger logging.Logger, bf proxy.BackendFactory) proxy.BackendFactory {
 	return func(remote *config.Backend) proxy.Proxy {
 		next := bf(remote)
 		if !IsGrpcMethod(remote) {
 			return next
 		}
 
-		getNextHost := RoundRobinHostSelector(remote.Host)
+		logPrefix := "[BACKEND: " + remote.URLPattern + "][gRPC]"
+		cfg, err := ConfigGetter(remote)
+		if err != nil {
+			// the backend must not be reached over HTTP, so every request fails until the
+			// config is fixed
+			logger.Error(logPrefix, "Invalid gRPC config:", err.Error())
+			return erroredProxy(fmt.Errorf("invalid gRPC config of the backend %s: %w", remote.URLPattern, err))
+		}
+		descriptors, err := LoadDescriptors(cfg)
+		if err != nil {
+			logger.Error(logPrefix, err.Error())
+		}
+		if descriptors != nil {
+			if err := checkBackend(remote, descriptors); err != nil {
+				logger.Warning(logPrefix, err.Error())
+			}
+		}
+		pool := NewConnPool(ctx, cfg, descriptors, logger)
+
+		// the balancer is only required when the request does not come from the load
+		// balanced middleware of the proxy stack
+		var lb sd.Balancer
+		lbOnce := new(sync.Once)
+		getBalancer := func() sd.Balancer {
+			lbOnce.Do(func() {
+				lb = sd.NewBalancer(sd.GetRegister().Get(remote.SD)(remote))
+			})
+			return lb
+		}
 
 		return func(requestCtx context.Context, req *proxy.Request) (*proxy.Response, error) {
-			timeoutDuration := 3000 * time.Millisecond
-			ctx, cancel := context.WithTimeout(requestCtx, timeoutDuration)
-			defer cancel()
+			ctx := requestCtx
+			if remote.Timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(requestCtx, remote.Timeout)
+				defer cancel()
+			}
 
-			hostURL, err := url.Parse(trimPrefixes(getNextHost()))
+			host := ""
+			if req.URL != nil && req.URL.Host != "" {
+				host = req.URL.Host
+			} else {
+				h, err := getBalancer().Host()
+				if err != nil {
+					logger.Error(logPrefix, "Selecting the host:", err.Error())
+					return errorResponse(cfg, NewResponseError(status.Error(codes.Unavailable, err.Error()), cfg.StatusMapping))
+				}
+				host = h
+			}
+			target, err := dialTarget(host)
 			if err != nil {
-				logger.Error("URL parsing error: ", err)
-				return createErrorResponse(500, "URL parsing error"), nil
+				logger.Error(logPrefix, err.Error())
+				return errorResponse(cfg, NewResponseError(status.Error(codes.Internal, "URL parsing error"), cfg.StatusMapping))
 			}
 
-			grpcProxy := NewProxy()
-			if err := grpcProxy.Connect(ctx, hostURL, logger); err != nil {
-				logger.Error("Failed to connect: ", err)
-				return createErrorResponse(500, "Failed to connect"), nil
+			grpcProxy, err := pool.Get(target)
+			if err != nil {
+				logger.Error(logPrefix, "Failed to connect:", err.Error())
+				return errorResponse(cfg, NewResponseError(status.Error(codes.Unavailable, "Failed to connect"), cfg.StatusMapping))
 			}
 
 			bodyBytes, err := io.ReadAll(req.Body)
 			req.Body.Close()
 			if err != nil {
-				logger.Error("Failed to read request body: ", err)
-				return createErrorResponse(500, "Failed to read request body"), nil
+				logger.Error(logPrefix, "Failed to read the request body:", err.Error())
+				return errorResponse(cfg, NewResponseError(status.Error(codes.InvalidArgument, "Failed to read request body"), cfg.StatusMapping))
 			}
 
 			serviceName, methodName, err := parseURLPattern(remote.URLPattern)
 			if err != nil {
-				logger.Error(err.Error())
-				return createErrorResponse(400, err.Error()), nil
+				logger.Error(logPrefix, err.Error())
+				return errorResponse(cfg, NewResponseError(status.Error(codes.Internal, err.Error()), cfg.StatusMapping))
+			}
+
+			methodDesc, err := grpcProxy.Method(serviceName, methodName)
+			if err != nil {
+				return errorResponse(cfg, NewResponseError(err, cfg.StatusMapping))
+			}
+			if methodDesc.IsClientStreaming() {
+				return errorResponse(cfg, NewResponseError(status.Error(codes.Unimplemented, "client streaming methods are not supported"), cfg.StatusMapping))
+			}
+			input := &Input{
+				Body:   bodyBytes,
+				Params: req.Params,
+				Query:  req.Query,
+			}
+			md := outgoingMetadata(cfg, req.Headers)
+			var header, trailer metadata.MD
+
+			if methodDesc.IsServerStreaming() {
+				// the endpoint wraps the request context with its timeout, so the messages are
+				// streamed with a detached context closed by the router once the client goes away.
+				// The wait for the headers of the stream is still bound to the request context.
+				streamCtx := context.WithoutCancel(requestCtx)
+				var cancel context.CancelFunc
+				if cfg.StreamTimeout > 0 {
+					streamCtx, cancel = context.WithTimeout(streamCtx, cfg.StreamTimeout)
+				} else {
+					streamCtx, cancel = context.WithCancel(streamCtx)
+				}
+				stop := context.AfterFunc(ctx, cancel)
+				stream, err := grpcProxy.Stream(metadata.NewOutgoingContext(streamCtx, md), serviceName, methodName, input, &header)
+				if !stop() {
+					// the request was canceled or timed out before the stream was handed to the router
+					if err == nil {
+						stream.Close()
+					}
+					err = status.FromContextError(ctx.Err()).Err()
+				}
+				if err != nil {
+					cancel()
+					logger.Error(logPrefix, "gRPC stream failed:", err.Error())
+					respErr := NewResponseError(err, cfg.StatusMapping)
+					respErr.Metadata = responseHeaders(cfg, header, trailer)
+					return errorResponse(cfg, respErr)
+				}
+				headers := responseHeaders(cfg, header, trailer)
+				if headers == nil {
+					headers = map[string][]string{}
+				}
+				headers["Content-Type"] = []string{streamContentTypes[cfg.StreamFormat]}
+				headers["Cache-Control"] = []string{"no-cache"}
+				return &proxy.Response{
+					Data:       map[string]interface{}{},
+					IsComplete: true,
+					Metadata: proxy.Metadata{
+						Headers:    headers,
+						StatusCode: http.StatusOK,
+					},
+					Io: &cancelReader{ReadCloser: stream, cancel: cancel},
+				}, nil
 			}
 
-			md := metadata.New(nil)
-			responseBytes, err := grpcProxy.Call(ctx, serviceName, methodName, bodyBytes, &md)
+			ctx = metadata.NewOutgoingContext(ctx, md)
+			responseBytes, err := grpcProxy.Call(ctx, serviceName, methodName, input, &header, &trailer)
 			if err != nil {
-				logger.Error("gRPC call failed: ", err)
-				return createErrorResponse(500, err.Error()), nil
+				logger.Error(logPrefix, "gRPC call failed:", err.Error())
+				respErr := NewResponseError(err, cfg.StatusMapping)
+				respErr.Metadata = responseHeaders(cfg, header, trailer)
+				return errorResponse(cfg, respErr)
 			}
 
 			var responseData map[string]interface{}
 			if err := json.Unmarshal(responseBytes, &responseData); err != nil {
-				logger.Error("Failed to unmarshal JSON data into map: ", err)
-				return createErrorResponse(500, "Failed to unmarshal JSON data into map"), nil
+				logger.Error(logPrefix, "Failed to unmarshal the response:", err.Error())
+				return errorResponse(cfg, NewResponseError(status.Error(codes.Internal, "Failed to unmarshal JSON data into map"), cfg.StatusMapping))
 			}
 
 			return &proxy.Response{
 				Data:       responseData,
 				IsComplete: true,
+				Metadata: proxy.Metadata{
+					Headers: responseHeaders(cfg, header, trailer),
+				},
 			}, nil
 		}
 	}
@@ -133,13 +235,21 @@ func parseURLPattern(urlPattern string) (serviceName, methodName string, err err
 	return serviceName, methodName, nil
 }
 
-func createErrorResponse(statusCode int, message string) *proxy.Response {
-	responseData := map[string]interface{}{
-		"msg": message,
-	}
-	return &proxy.Response{
-		Data:       responseData,
-		IsComplete: false,
-		Metadata:   proxy.Metadata{StatusCode: statusCode},
+// erroredProxy returns a proxy failing every request with the received error
+func erroredProxy(err error) proxy.Proxy {
+	return func(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+		return nil, err
 	}
 }
+
+// cancelReader releases the context of the stream once it is closed
+type cancelReader struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close closes the stream and cancels its context
+func (r *cancelReader) Close() error {
+	defer r.cancel()
+	return r.ReadCloser.Close()
+}
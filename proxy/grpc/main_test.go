@@ -0,0 +1,177 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+
+	"api-gateway/v2/modules/lura/v2/config"
+	"api-gateway/v2/modules/lura/v2/logging"
+	"api-gateway/v2/modules/lura/v2/proxy"
+)
+
+// testService is the grpc.testing.TestService of the in-process backends. The streaming
+// calls without response parameters never reply.
+type testService struct {
+	testpb.UnimplementedTestServiceServer
+}
+
+func (testService) UnaryCall(ctx context.Context, in *testpb.SimpleRequest) (*testpb.SimpleResponse, error) {
+	if st := in.GetResponseStatus(); st != nil {
+		return nil, status.Error(codes.Code(st.GetCode()), st.GetMessage())
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	grpc.SetHeader(ctx, metadata.Pairs("x-request-user", strings.Join(md.Get("x-user"), ",")))
+	return &testpb.SimpleResponse{Payload: in.GetPayload(), Username: "test"}, nil
+}
+
+func (testService) StreamingOutputCall(in *testpb.StreamingOutputCallRequest, stream testpb.TestService_StreamingOutputCallServer) error {
+	if len(in.GetResponseParameters()) == 0 {
+		<-stream.Context().Done()
+		return stream.Context().Err()
+	}
+	for _, p := range in.GetResponseParameters() {
+		if err := stream.Send(&testpb.StreamingOutputCallResponse{
+			Payload: &testpb.Payload{Body: make([]byte, p.GetSize())},
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newTestBackend starts an in-process gRPC server with the TestService and the reflection
+// service and returns its address
+func newTestBackend(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	s := grpc.NewServer()
+	testpb.RegisterTestServiceServer(s, testService{})
+	reflection.Register(s)
+	go s.Serve(l)
+	t.Cleanup(s.Stop)
+	return l.Addr().String()
+}
+
+func newTestBackendProxy(t *testing.T, method string, extra map[string]interface{}) proxy.Proxy {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	bf := NewGrpcBackendFactoryWithContext(ctx, logging.NoOp, func(*config.Backend) proxy.Proxy {
+		return func(context.Context, *proxy.Request) (*proxy.Response, error) {
+			t.Error("the gRPC backend was handled as an http one")
+			return nil, nil
+		}
+	})
+	return bf(&config.Backend{
+		URLPattern:  "/grpc.testing.TestService/" + method,
+		ExtraConfig: config.ExtraConfig{Namespace: extra},
+	})
+}
+
+func newTestRequest(addr, body string) *proxy.Request {
+	return &proxy.Request{
+		Method:  http.MethodPost,
+		URL:     &url.URL{Scheme: "http", Host: addr},
+		Body:    io.NopCloser(strings.NewReader(body)),
+		Params:  map[string]string{},
+		Headers: map[string][]string{},
+	}
+}
+
+func TestNewGrpcBackendFactoryWithContext_unary(t *testing.T) {
+	addr := newTestBackend(t)
+	prxy := newTestBackendProxy(t, "UnaryCall", map[string]interface{}{})
+
+	resp, err := prxy(context.Background(), newTestRequest(addr, `{"payload":{"body":"aGk="}}`))
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if !resp.IsComplete {
+		t.Error("the response is not complete")
+	}
+	if resp.Data["username"] != "test" {
+		t.Errorf("unexpected response: %v", resp.Data)
+	}
+
+	_, err = prxy(context.Background(), newTestRequest(addr, `{"response_status":{"code":5,"message":"missing"}}`))
+	var respErr ResponseError
+	if !errors.As(err, &respErr) {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	if respErr.Code != http.StatusNotFound || respErr.Status.Message() != "missing" {
+		t.Errorf("unexpected error: %s", respErr.Error())
+	}
+}
+
+func TestNewGrpcBackendFactoryWithContext_stream(t *testing.T) {
+	addr := newTestBackend(t)
+	prxy := newTestBackendProxy(t, "StreamingOutputCall", map[string]interface{}{"stream_format": StreamFormatNDJSON})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	resp, err := prxy(ctx, newTestRequest(addr, `{"response_parameters":[{"size":1},{"size":2}]}`))
+	// the stream must outlive the request context
+	cancel()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if ct := resp.Metadata.Headers["Content-Type"]; len(ct) != 1 || ct[0] != "application/x-ndjson" {
+		t.Errorf("unexpected content type: %v", ct)
+	}
+	b, err := io.ReadAll(resp.Io)
+	resp.Io.(io.Closer).Close()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if string(b) != "{\"payload\":{\"type\":0,\"body\":\"AA==\"}}\n{\"payload\":{\"type\":0,\"body\":\"AAA=\"}}\n" {
+		t.Errorf("unexpected stream: %q", b)
+	}
+}
+
+func TestNewGrpcBackendFactoryWithContext_silentStream(t *testing.T) {
+	addr := newTestBackend(t)
+	prxy := newTestBackendProxy(t, "StreamingOutputCall", map[string]interface{}{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := prxy(ctx, newTestRequest(addr, `{}`))
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		var respErr ResponseError
+		if !errors.As(err, &respErr) {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if respErr.Code != http.StatusGatewayTimeout {
+			t.Errorf("unexpected status code: %d", respErr.Code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("the wait for the headers of a silent backend was not bound to the request context")
+	}
+}
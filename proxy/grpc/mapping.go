@@ -0,0 +1,326 @@
+package grpc
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// BodyWildcard maps the whole request body into the input message
+const BodyWildcard = "*"
+
+var pathVariablePattern = regexp.MustCompile(`\{([^}=]+)(=[^}]*)?\}`)
+
+// Input contains the data of the request to map into the input message of a method
+type Input struct {
+	Body   []byte
+	Params map[string]string
+	Query  url.Values
+}
+
+// RequestMapper fills the input messages with the body, the params and the query strings of
+// the requests, in the same way the google.api.http annotations do
+type RequestMapper struct {
+	// body is the field path where the request body is decoded
+	body string
+	// fieldMapping translates param and query string names into field paths
+	fieldMapping map[string]string
+}
+
+// NewRequestMapper returns a RequestMapper using the body and the field mapping declared in the config
+func NewRequestMapper(cfg Config) *RequestMapper {
+	m := &RequestMapper{
+		body:         cfg.Body,
+		fieldMapping: make(map[string]string, len(cfg.FieldMapping)),
+	}
+	if m.body == "" {
+		m.body = BodyWildcard
+	}
+	for k, v := range cfg.FieldMapping {
+		m.fieldMapping[strings.ToLower(k)] = v
+	}
+	return m
+}
+
+// Map fills msg with the input data. When the method has a google.api.http annotation, its
+// body and path variables take precedence over the mapper config.
+func (m *RequestMapper) Map(md *desc.MethodDescriptor, input *Input, msg *dynamic.Message) error {
+	body := m.body
+	applyQuery := true
+	var pathFields map[string]string
+	if rule := HTTPRule(md); rule != nil {
+		body = rule.GetBody()
+		// the query params are only used for the fields not bound to the body
+		applyQuery = body != BodyWildcard
+		pathFields = pathVariables(rule)
+	}
+
+	// methods annotated without a body just ignore it
+	if len(input.Body) > 0 && body != "" {
+		if err := setBody(msg, body, input.Body); err != nil {
+			return err
+		}
+	}
+
+	if applyQuery {
+		for k, vs := range input.Query {
+			if err := m.setPath(msg, m.fieldPath(k), vs...); err != nil {
+				return err
+			}
+		}
+	}
+
+	for k, v := range input.Params {
+		path := m.fieldPath(k)
+		if p, ok := pathFields[strings.ToLower(k)]; ok {
+			path = p
+		}
+		if err := m.setPath(msg, path, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *RequestMapper) fieldPath(name string) string {
+	if p, ok := m.fieldMapping[strings.ToLower(name)]; ok {
+		return p
+	}
+	return name
+}
+
+// setPath sets the values into the field referenced by path. Unknown fields are ignored, so
+// params and query strings not declared in the message do not break the request.
+func (m *RequestMapper) setPath(msg *dynamic.Message, path string, values ...string) error {
+	if len(values) == 0 {
+		return nil
+	}
+	keys := strings.Split(path, ".")
+	for _, key := range keys[:len(keys)-1] {
+		fd := findField(msg.GetMessageDescriptor(), key)
+		if fd == nil || fd.GetMessageType() == nil || fd.IsRepeated() {
+			return nil
+		}
+		msg = nestedMessage(msg, fd)
+	}
+
+	fd := findField(msg.GetMessageDescriptor(), keys[len(keys)-1])
+	if fd == nil || fd.IsMap() {
+		return nil
+	}
+
+	if !fd.IsRepeated() {
+		v, err := coerce(fd, values[len(values)-1])
+		if err != nil {
+			return err
+		}
+		return msg.TrySetField(fd, v)
+	}
+
+	msg.ClearField(fd)
+	for _, value := range values {
+		v, err := coerce(fd, value)
+		if err != nil {
+			return err
+		}
+		if err := msg.TryAddRepeatedField(fd, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setBody(msg *dynamic.Message, path string, body []byte) error {
+	if path == BodyWildcard {
+		if err := msg.UnmarshalMergeJSON(body); err != nil {
+			return fmt.Errorf("grpc: decoding the request body: %s", err.Error())
+		}
+		return nil
+	}
+
+	keys := strings.Split(path, ".")
+	for _, key := range keys[:len(keys)-1] {
+		fd := findField(msg.GetMessageDescriptor(), key)
+		if fd == nil || fd.GetMessageType() == nil || fd.IsRepeated() {
+			return fmt.Errorf("grpc: invalid body field %s", path)
+		}
+		msg = nestedMessage(msg, fd)
+	}
+	fd := findField(msg.GetMessageDescriptor(), keys[len(keys)-1])
+	if fd == nil {
+		return fmt.Errorf("grpc: invalid body field %s", path)
+	}
+
+	// wrapping the body in a JSON object lets the dynamic message decode any kind of field
+	wrapped := make([]byte, 0, len(body)+len(fd.GetJSONName())+5)
+	wrapped = append(wrapped, `{"`...)
+	wrapped = append(wrapped, fd.GetJSONName()...)
+	wrapped = append(wrapped, `":`...)
+	wrapped = append(wrapped, body...)
+	wrapped = append(wrapped, '}')
+	if err := msg.UnmarshalMergeJSON(wrapped); err != nil {
+		return fmt.Errorf("grpc: decoding the request body: %s", err.Error())
+	}
+	return nil
+}
+
+func nestedMessage(msg *dynamic.Message, fd *desc.FieldDescriptor) *dynamic.Message {
+	if v, err := msg.TryGetField(fd); err == nil {
+		if nested, ok := v.(*dynamic.Message); ok && nested != nil {
+			return nested
+		}
+	}
+	nested := dynamic.NewMessage(fd.GetMessageType())
+	msg.SetField(fd, nested)
+	return nested
+}
+
+// findField looks for a field by its proto name or its JSON name, ignoring the case since the
+// router capitalizes the names of the params
+func findField(md *desc.MessageDescriptor, name string) *desc.FieldDescriptor {
+	if fd := md.FindFieldByName(name); fd != nil {
+		return fd
+	}
+	if fd := md.FindFieldByJSONName(name); fd != nil {
+		return fd
+	}
+	for _, fd := range md.GetFields() {
+		if strings.EqualFold(fd.GetName(), name) || strings.EqualFold(fd.GetJSONName(), name) {
+			return fd
+		}
+	}
+	return nil
+}
+
+// coerce converts the string into the Go type expected by the dynamic message for the field
+func coerce(fd *desc.FieldDescriptor, s string) (interface{}, error) {
+	var v interface{}
+	var err error
+
+	switch fd.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING:
+		return s, nil
+	case descriptorpb.FieldDescriptorProto_TYPE_BOOL:
+		v, err = strconv.ParseBool(s)
+	case descriptorpb.FieldDescriptorProto_TYPE_INT32,
+		descriptorpb.FieldDescriptorProto_TYPE_SINT32,
+		descriptorpb.FieldDescriptorProto_TYPE_SFIXED32:
+		var i int64
+		i, err = strconv.ParseInt(s, 10, 32)
+		v = int32(i)
+	case descriptorpb.FieldDescriptorProto_TYPE_INT64,
+		descriptorpb.FieldDescriptorProto_TYPE_SINT64,
+		descriptorpb.FieldDescriptorProto_TYPE_SFIXED64:
+		v, err = strconv.ParseInt(s, 10, 64)
+	case descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+		descriptorpb.FieldDescriptorProto_TYPE_FIXED32:
+		var u uint64
+		u, err = strconv.ParseUint(s, 10, 32)
+		v = uint32(u)
+	case descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+		descriptorpb.FieldDescriptorProto_TYPE_FIXED64:
+		v, err = strconv.ParseUint(s, 10, 64)
+	case descriptorpb.FieldDescriptorProto_TYPE_FLOAT:
+		var f float64
+		f, err = strconv.ParseFloat(s, 32)
+		v = float32(f)
+	case descriptorpb.FieldDescriptorProto_TYPE_DOUBLE:
+		v, err = strconv.ParseFloat(s, 64)
+	case descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+		v, err = base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			v, err = base64.URLEncoding.DecodeString(s)
+		}
+	case descriptorpb.FieldDescriptorProto_TYPE_ENUM:
+		if ev := fd.GetEnumType().FindValueByName(s); ev != nil {
+			return ev.GetNumber(), nil
+		}
+		var i int64
+		i, err = strconv.ParseInt(s, 10, 32)
+		v = int32(i)
+	case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE:
+		// well known types (wrappers, timestamps, durations, field masks...) accept their
+		// JSON representation, so the value is decoded as a JSON string
+		nested := dynamic.NewMessage(fd.GetMessageType())
+		b, _ := json.Marshal(s)
+		if err = nested.UnmarshalJSON(b); err != nil {
+			if valueField := nested.GetMessageDescriptor().FindFieldByName("value"); valueField != nil {
+				var inner interface{}
+				if inner, err = coerce(valueField, s); err == nil {
+					err = nested.TrySetField(valueField, inner)
+				}
+			}
+		}
+		v = nested
+	default:
+		err = fmt.Errorf("unsupported type %s", fd.GetType())
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("grpc: invalid value for the field %s: %s", fd.GetName(), err.Error())
+	}
+	return v, nil
+}
+
+// HTTPRule returns the google.api.http annotation of the method, if any
+func HTTPRule(md *desc.MethodDescriptor) *annotations.HttpRule {
+	opts := md.GetMethodOptions()
+	if opts == nil {
+		return nil
+	}
+	if rule, ok := proto.GetExtension(opts, annotations.E_Http).(*annotations.HttpRule); ok && rule != nil && rule.GetPattern() != nil {
+		return rule
+	}
+
+	// the descriptors could have been built before the extension was registered, so it
+	// could still be stored as an unknown field
+	b, err := proto.Marshal(opts)
+	if err != nil {
+		return nil
+	}
+	fresh := new(descriptorpb.MethodOptions)
+	if err := (proto.UnmarshalOptions{Resolver: protoregistry.GlobalTypes}).Unmarshal(b, fresh); err != nil {
+		return nil
+	}
+	if rule, ok := proto.GetExtension(fresh, annotations.E_Http).(*annotations.HttpRule); ok && rule != nil && rule.GetPattern() != nil {
+		return rule
+	}
+	return nil
+}
+
+// pathVariables returns the field paths bound to the variables of the rule path template, indexed
+// by the lowercased name of the variable
+func pathVariables(rule *annotations.HttpRule) map[string]string {
+	var pattern string
+	switch p := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		pattern = p.Get
+	case *annotations.HttpRule_Put:
+		pattern = p.Put
+	case *annotations.HttpRule_Post:
+		pattern = p.Post
+	case *annotations.HttpRule_Delete:
+		pattern = p.Delete
+	case *annotations.HttpRule_Patch:
+		pattern = p.Patch
+	case *annotations.HttpRule_Custom:
+		pattern = p.Custom.GetPath()
+	}
+
+	res := map[string]string{}
+	for _, match := range pathVariablePattern.FindAllStringSubmatch(pattern, -1) {
+		res[strings.ToLower(match[1])] = match[1]
+	}
+	return res
+}
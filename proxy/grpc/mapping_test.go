@@ -0,0 +1,253 @@
+package grpc
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"github.com/jhump/protoreflect/dynamic"
+)
+
+const testOrdersProto = `syntax = "proto3";
+
+package orders;
+
+import "google/api/annotations.proto";
+import "google/protobuf/timestamp.proto";
+import "google/protobuf/wrappers.proto";
+
+enum Status {
+  UNKNOWN = 0;
+  PENDING = 1;
+  SHIPPED = 2;
+}
+
+message Filter {
+  string tenant = 1;
+  repeated Status status = 2;
+}
+
+message Order {
+  string id = 1;
+  string customer_name = 2;
+  int32 quantity = 3;
+  uint64 total = 4;
+  double price = 5;
+  bool paid = 6;
+  bytes signature = 7;
+  Status status = 8;
+  repeated string tags = 9;
+  google.protobuf.Timestamp created_at = 10;
+  google.protobuf.Int64Value version = 11;
+  Filter filter = 12;
+  map<string, string> labels = 13;
+}
+
+message UpdateOrderRequest {
+  string order_id = 1;
+  Order order = 2;
+  bool notify = 3;
+}
+
+service Orders {
+  rpc CreateOrder(Order) returns (Order);
+  rpc UpdateOrder(UpdateOrderRequest) returns (Order) {
+    option (google.api.http) = {
+      patch: "/v1/orders/{order_id}"
+      body: "order"
+      response_body: "order"
+    };
+  }
+  rpc ReplaceOrder(UpdateOrderRequest) returns (Order) {
+    option (google.api.http) = {
+      put: "/v1/orders/{order_id}"
+      body: "*"
+    };
+  }
+  rpc GetOrder(UpdateOrderRequest) returns (Order) {
+    option (google.api.http) = {
+      get: "/v1/orders/{order_id}"
+    };
+  }
+}
+`
+
+func testOrdersService(t *testing.T) *desc.ServiceDescriptor {
+	t.Helper()
+	p := protoparse.Parser{
+		Accessor:     protoparse.FileContentsFromMap(map[string]string{"orders.proto": testOrdersProto}),
+		LookupImport: desc.LoadFileDescriptor,
+	}
+	fds, err := p.ParseFiles("orders.proto")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	return fds[0].FindService("orders.Orders")
+}
+
+func TestRequestMapper_Map(t *testing.T) {
+	sd := testOrdersService(t)
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		cfg    Config
+		input  *Input
+		want   string
+	}{
+		{
+			name:   "body, params and query strings",
+			method: "CreateOrder",
+			input: &Input{
+				Body:   []byte(`{"customer_name":"john","quantity":1}`),
+				Params: map[string]string{"Id": "o-1"},
+				Query:  url.Values{"tags": {"a", "b"}, "paid": {"true"}, "unknown": {"x"}},
+			},
+			want: `{"id":"o-1","customerName":"john","quantity":1,"paid":true,"tags":["a","b"]}`,
+		},
+		{
+			name:   "scalar types",
+			method: "CreateOrder",
+			input: &Input{Query: url.Values{
+				"total":      {"18446744073709551615"},
+				"price":      {"9.5"},
+				"signature":  {"AQI="},
+				"status":     {"SHIPPED"},
+				"created_at": {"2024-01-02T03:04:05Z"},
+				"version":    {"7"},
+			}},
+			want: `{"total":"18446744073709551615","price":9.5,"signature":"AQI=","status":"SHIPPED","createdAt":"2024-01-02T03:04:05Z","version":"7"}`,
+		},
+		{
+			name:   "field mapping",
+			method: "CreateOrder",
+			cfg:    Config{FieldMapping: map[string]string{"Tenant": "filter.tenant", "state": "filter.status"}},
+			input: &Input{
+				Params: map[string]string{"Tenant": "acme"},
+				Query:  url.Values{"state": {"1", "SHIPPED"}},
+			},
+			want: `{"filter":{"tenant":"acme","status":["PENDING","SHIPPED"]}}`,
+		},
+		{
+			name:   "body field",
+			method: "CreateOrder",
+			cfg:    Config{Body: "filter"},
+			input:  &Input{Body: []byte(`{"tenant":"acme"}`), Query: url.Values{"id": {"o-1"}}},
+			want:   `{"id":"o-1","filter":{"tenant":"acme"}}`,
+		},
+		{
+			name:   "map and nested repeated fields are ignored",
+			method: "CreateOrder",
+			input:  &Input{Query: url.Values{"labels": {"a"}, "tags.name": {"a"}}},
+			want:   `{}`,
+		},
+		{
+			name:   "annotated body field",
+			method: "UpdateOrder",
+			input: &Input{
+				Body:   []byte(`{"customer_name":"john"}`),
+				Params: map[string]string{"Order_id": "o-1"},
+				Query:  url.Values{"notify": {"true"}},
+			},
+			want: `{"orderId":"o-1","order":{"customerName":"john"},"notify":true}`,
+		},
+		{
+			name:   "annotated wildcard body",
+			method: "ReplaceOrder",
+			input: &Input{
+				Body:   []byte(`{"order":{"id":"o-2"}}`),
+				Params: map[string]string{"Order_id": "o-1"},
+				Query:  url.Values{"notify": {"true"}},
+			},
+			want: `{"orderId":"o-1","order":{"id":"o-2"}}`,
+		},
+		{
+			name:   "annotated without body",
+			method: "GetOrder",
+			input: &Input{
+				Body:   []byte(`{"notify":true}`),
+				Params: map[string]string{"Order_id": "o-1"},
+			},
+			want: `{"orderId":"o-1"}`,
+		},
+	} {
+		md := sd.FindMethodByName(tc.method)
+		msg := dynamic.NewMessage(md.GetInputType())
+		if err := NewRequestMapper(tc.cfg).Map(md, tc.input, msg); err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.name, err.Error())
+			continue
+		}
+		b, err := msg.MarshalJSON()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.name, err.Error())
+			continue
+		}
+		if string(b) != tc.want {
+			t.Errorf("%s: unexpected message. have: %s, want: %s", tc.name, b, tc.want)
+		}
+	}
+}
+
+func TestRequestMapper_Map_errors(t *testing.T) {
+	md := testOrdersService(t).FindMethodByName("CreateOrder")
+
+	for _, tc := range []struct {
+		name  string
+		cfg   Config
+		input *Input
+	}{
+		{name: "invalid body", input: &Input{Body: []byte(`{"quantity":"many"}`)}},
+		{name: "unknown body field", cfg: Config{Body: "items"}, input: &Input{Body: []byte(`{}`)}},
+		{name: "scalar body parent", cfg: Config{Body: "id.value"}, input: &Input{Body: []byte(`{}`)}},
+		{name: "invalid int", input: &Input{Query: url.Values{"quantity": {"many"}}}},
+		{name: "int overflow", input: &Input{Query: url.Values{"quantity": {"4294967296"}}}},
+		{name: "invalid bool", input: &Input{Params: map[string]string{"Paid": "maybe"}}},
+		{name: "invalid bytes", input: &Input{Query: url.Values{"signature": {"%%%"}}}},
+		{name: "invalid enum", input: &Input{Query: url.Values{"status": {"LOST"}}}},
+		{name: "invalid repeated enum", cfg: Config{FieldMapping: map[string]string{"state": "filter.status"}}, input: &Input{Query: url.Values{"state": {"1", "LOST"}}}},
+	} {
+		msg := dynamic.NewMessage(md.GetInputType())
+		if err := NewRequestMapper(tc.cfg).Map(md, tc.input, msg); err == nil {
+			t.Errorf("%s: error expected", tc.name)
+		}
+	}
+}
+
+func TestHTTPRule(t *testing.T) {
+	sd := testOrdersService(t)
+
+	if rule := HTTPRule(sd.FindMethodByName("CreateOrder")); rule != nil {
+		t.Errorf("unexpected rule: %v", rule)
+	}
+	rule := HTTPRule(sd.FindMethodByName("UpdateOrder"))
+	if rule == nil || rule.GetPatch() != "/v1/orders/{order_id}" || rule.GetBody() != "order" {
+		t.Errorf("unexpected rule: %v", rule)
+		return
+	}
+	if vars := pathVariables(rule); len(vars) != 1 || vars["order_id"] != "order_id" {
+		t.Errorf("unexpected path variables: %v", vars)
+	}
+}
+
+func TestResponseBody(t *testing.T) {
+	md := testOrdersService(t).FindMethodByName("UpdateOrder")
+	b, err := responseBody(md, "tags", []byte(`{"id":"o-1","tags":["a"]}`))
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if string(b) != `{"collection":["a"]}` {
+		t.Errorf("unexpected body: %s", b)
+	}
+
+	if b, _ := responseBody(md, "filter", []byte(`{"id":"o-1","filter":{"tenant":"acme"}}`)); string(b) != `{"tenant":"acme"}` {
+		t.Errorf("unexpected body: %s", b)
+	}
+	if b, _ := responseBody(md, "filter", []byte(`{"id":"o-1"}`)); string(b) != `{}` {
+		t.Errorf("unexpected body: %s", b)
+	}
+	if b, _ := responseBody(md, "unknown", []byte(`{"id":"o-1"}`)); string(b) != `{"id":"o-1"}` {
+		t.Errorf("unexpected body: %s", b)
+	}
+}
@@ -0,0 +1,87 @@
+package grpc
+
+import (
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// reservedMetadata are the keys that can not be set by the gateway since they are managed
+// by the HTTP/2 and gRPC transports
+var reservedMetadata = map[string]struct{}{
+	"connection":        {},
+	"content-length":    {},
+	"content-type":      {},
+	"host":              {},
+	"keep-alive":        {},
+	"proxy-connection":  {},
+	"te":                {},
+	"trailer":           {},
+	"transfer-encoding": {},
+	"upgrade":           {},
+	"user-agent":        {},
+}
+
+func isReservedMetadata(key string) bool {
+	if _, ok := reservedMetadata[key]; ok {
+		return true
+	}
+	return strings.HasPrefix(key, "grpc-") || strings.HasPrefix(key, ":")
+}
+
+// outgoingMetadata builds the metadata to send to the backend with the allowed request headers,
+// renaming them as declared in the metadata mapping
+func outgoingMetadata(cfg Config, headers map[string][]string) metadata.MD {
+	md := metadata.MD{}
+	if len(cfg.ForwardHeaders) == 0 {
+		return md
+	}
+
+	forwardAll := false
+	allowed := make(map[string]struct{}, len(cfg.ForwardHeaders))
+	for _, h := range cfg.ForwardHeaders {
+		if h == "*" {
+			forwardAll = true
+			break
+		}
+		allowed[http.CanonicalHeaderKey(h)] = struct{}{}
+	}
+
+	for k, vs := range headers {
+		name := http.CanonicalHeaderKey(k)
+		if _, ok := allowed[name]; !ok && !forwardAll {
+			continue
+		}
+
+		key := strings.ToLower(name)
+		if renamed, ok := cfg.MetadataMapping[name]; ok {
+			key = strings.ToLower(renamed)
+		}
+		// binary metadata must be base64 encoded, so it can not be forwarded as is
+		if isReservedMetadata(key) || strings.HasSuffix(key, "-bin") {
+			continue
+		}
+		md.Append(key, vs...)
+	}
+	return md
+}
+
+// responseHeaders copies the received headers and trailers into a set of HTTP headers using the
+// configured prefix. It returns nil if the backend is not configured to return its metadata.
+func responseHeaders(cfg Config, header, trailer metadata.MD) map[string][]string {
+	if !cfg.ReturnMetadata {
+		return nil
+	}
+	res := make(map[string][]string, len(header)+len(trailer))
+	for _, md := range []metadata.MD{header, trailer} {
+		for k, vs := range md {
+			if isReservedMetadata(k) || strings.HasSuffix(k, "-bin") {
+				continue
+			}
+			name := http.CanonicalHeaderKey(cfg.ResponseHeadersPrefix + k)
+			res[name] = append(res[name], vs...)
+		}
+	}
+	return res
+}
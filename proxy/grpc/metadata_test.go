@@ -0,0 +1,84 @@
+package grpc
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestOutgoingMetadata(t *testing.T) {
+	headers := map[string][]string{
+		"X-User":            {"user-1"},
+		"X-Tenant":          {"a", "b"},
+		"Authorization":     {"Bearer token"},
+		"Content-Type":      {"application/json"},
+		"Grpc-Timeout":      {"1S"},
+		"X-Trace-Bin":       {"AAE="},
+		"Transfer-Encoding": {"chunked"},
+	}
+
+	for _, tc := range []struct {
+		name string
+		cfg  Config
+		want metadata.MD
+	}{
+		{
+			name: "no forwarded headers",
+			want: metadata.MD{},
+		},
+		{
+			name: "allow-list",
+			cfg:  Config{ForwardHeaders: []string{"x-user", "X-Tenant", "X-Missing"}},
+			want: metadata.MD{"x-user": {"user-1"}, "x-tenant": {"a", "b"}},
+		},
+		{
+			name: "renamed headers",
+			cfg: Config{
+				ForwardHeaders:  []string{"X-User", "Authorization"},
+				MetadataMapping: map[string]string{"X-User": "User-Id", "Authorization": "Grpc-Authorization"},
+			},
+			want: metadata.MD{"user-id": {"user-1"}},
+		},
+		{
+			name: "wildcard",
+			cfg:  Config{ForwardHeaders: []string{"*"}},
+			want: metadata.MD{"x-user": {"user-1"}, "x-tenant": {"a", "b"}, "authorization": {"Bearer token"}},
+		},
+		{
+			name: "reserved headers",
+			cfg:  Config{ForwardHeaders: []string{"Content-Type", "Grpc-Timeout", "X-Trace-Bin", "Transfer-Encoding"}},
+			want: metadata.MD{},
+		},
+	} {
+		if md := outgoingMetadata(tc.cfg, headers); !reflect.DeepEqual(md, tc.want) {
+			t.Errorf("%s: unexpected metadata. have: %v, want: %v", tc.name, md, tc.want)
+		}
+	}
+}
+
+func TestResponseHeaders(t *testing.T) {
+	header := metadata.MD{
+		"x-request-id":  {"1"},
+		"content-type":  {"application/grpc"},
+		"x-details-bin": {"\x00\x01"},
+	}
+	trailer := metadata.MD{
+		"x-request-id": {"2"},
+		"grpc-status":  {"0"},
+		"x-elapsed":    {"10ms"},
+	}
+
+	if res := responseHeaders(Config{}, header, trailer); res != nil {
+		t.Errorf("unexpected headers: %v", res)
+	}
+
+	res := responseHeaders(Config{ReturnMetadata: true, ResponseHeadersPrefix: "x-backend-"}, header, trailer)
+	want := map[string][]string{
+		"X-Backend-X-Request-Id": {"1", "2"},
+		"X-Backend-X-Elapsed":    {"10ms"},
+	}
+	if !reflect.DeepEqual(res, want) {
+		t.Errorf("unexpected headers. have: %v, want: %v", res, want)
+	}
+}
@@ -0,0 +1,212 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"api-gateway/v2/modules/lura/v2/logging"
+	"golang.org/x/sync/singleflight"
+	"google.golang.org/grpc/connectivity"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// ErrPoolClosed is the error returned when a connection is requested to a closed pool
+var ErrPoolClosed = errors.New("grpc: connection pool closed")
+
+// ConnPool keeps a long-lived connection (with its reflection client) per backend host.
+// Idle and unhealthy connections are evicted by a maintenance loop and all of them are
+// gracefully closed once the context is done.
+type ConnPool struct {
+	ctx         context.Context
+	cfg         Config
+	descriptors *DescriptorRegistry
+	logger      logging.Logger
+
+	mu     *sync.Mutex
+	conns  map[string]*pooledProxy
+	closed bool
+	// dials deduplicates the concurrent dials to the same target
+	dials *singleflight.Group
+}
+
+type pooledProxy struct {
+	*Proxy
+	lastUsed int64
+}
+
+func (p *pooledProxy) touch() {
+	atomic.StoreInt64(&p.lastUsed, time.Now().UnixNano())
+}
+
+func (p *pooledProxy) idleSince() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&p.lastUsed))
+}
+
+// NewConnPool returns a ConnPool bound to the received context. The descriptors registry
+// is optional.
+func NewConnPool(ctx context.Context, cfg Config, descriptors *DescriptorRegistry, logger logging.Logger) *ConnPool {
+	p := &ConnPool{
+		ctx:         ctx,
+		cfg:         cfg,
+		descriptors: descriptors,
+		logger:      logger,
+		mu:          new(sync.Mutex),
+		conns:       map[string]*pooledProxy{},
+		dials:       new(singleflight.Group),
+	}
+
+	go p.manage()
+
+	return p
+}
+
+// Get returns the pooled Proxy for the target, dialing a new connection if there is
+// no usable one
+func (p *ConnPool) Get(target string) (*Proxy, error) {
+	if prx, err := p.lookup(target); prx != nil || err != nil {
+		return prx, err
+	}
+
+	// the connection is dialed outside the lock, so a slow backend does not block the
+	// requests to the rest of the backends
+	v, err, _ := p.dials.Do(target, func() (interface{}, error) {
+		if prx, err := p.lookup(target); prx != nil || err != nil {
+			return prx, err
+		}
+
+		prx := NewProxy(p.cfg, p.descriptors)
+		if err := prx.Connect(p.ctx, target, p.logger); err != nil {
+			return nil, err
+		}
+		c := &pooledProxy{Proxy: prx}
+		c.touch()
+
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			prx.Close()
+			return nil, ErrPoolClosed
+		}
+		p.conns[target] = c
+		p.mu.Unlock()
+
+		return prx, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Proxy), nil
+}
+
+// lookup returns the usable pooled Proxy of the target, if any
+func (p *ConnPool) lookup(target string) (*Proxy, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, ErrPoolClosed
+	}
+
+	if c, ok := p.conns[target]; ok {
+		if c.cc.GetState() != connectivity.Shutdown {
+			c.touch()
+			return c.Proxy, nil
+		}
+		delete(p.conns, target)
+	}
+	return nil, nil
+}
+
+// Close closes all the pooled connections. The pool can not be used after this call.
+func (p *ConnPool) Close() {
+	p.mu.Lock()
+	conns := p.conns
+	p.conns = map[string]*pooledProxy{}
+	p.closed = true
+	p.mu.Unlock()
+
+	for key, c := range conns {
+		if err := c.Close(); err != nil {
+			p.logger.Warning("[gRPC][Pool] Closing the connection to", key, err.Error())
+		}
+	}
+}
+
+func (p *ConnPool) manage() {
+	interval := p.cfg.HealthCheckInterval
+	if interval <= 0 {
+		interval = DefaultHealthCheckInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			p.Close()
+			return
+		case <-t.C:
+			p.evict()
+		}
+	}
+}
+
+func (p *ConnPool) evict() {
+	p.mu.Lock()
+	candidates := make(map[string]*pooledProxy, len(p.conns))
+	for k, c := range p.conns {
+		candidates[k] = c
+	}
+	p.mu.Unlock()
+
+	now := time.Now()
+	var toEvict []string
+	for key, c := range candidates {
+		if p.cfg.IdleTimeout > 0 && now.Sub(c.idleSince()) > p.cfg.IdleTimeout {
+			p.logger.Debug("[gRPC][Pool] Closing idle connection to", key)
+			toEvict = append(toEvict, key)
+			continue
+		}
+		if !p.isHealthy(c) {
+			p.logger.Warning("[gRPC][Pool] Closing unhealthy connection to", key)
+			toEvict = append(toEvict, key)
+		}
+	}
+
+	if len(toEvict) == 0 {
+		return
+	}
+
+	p.mu.Lock()
+	evicted := make([]*pooledProxy, 0, len(toEvict))
+	for _, key := range toEvict {
+		// the connection could have been replaced while checking its health
+		if c, ok := p.conns[key]; ok && c == candidates[key] {
+			delete(p.conns, key)
+			evicted = append(evicted, c)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, c := range evicted {
+		c.Close()
+	}
+}
+
+func (p *ConnPool) isHealthy(c *pooledProxy) bool {
+	switch c.cc.GetState() {
+	case connectivity.Shutdown, connectivity.TransientFailure:
+		return false
+	}
+	if !p.cfg.HealthCheck {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(p.ctx, time.Second)
+	defer cancel()
+	resp, err := healthpb.NewHealthClient(c.cc).Check(ctx, &healthpb.HealthCheckRequest{})
+	return err == nil && resp.GetStatus() == healthpb.HealthCheckResponse_SERVING
+}
@@ -0,0 +1,151 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"api-gateway/v2/modules/lura/v2/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+)
+
+func TestConnPool_Get(t *testing.T) {
+	addr := newTestBackend(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := NewConnPool(ctx, Config{}, nil, logging.NoOp)
+
+	// the concurrent requests share the dialed connection
+	proxies := make([]*Proxy, 10)
+	var wg sync.WaitGroup
+	for i := range proxies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			prx, err := p.Get(addr)
+			if err != nil {
+				t.Errorf("unexpected error: %s", err.Error())
+			}
+			proxies[i] = prx
+		}(i)
+	}
+	wg.Wait()
+	for _, prx := range proxies[1:] {
+		if prx != proxies[0] {
+			t.Error("the connection was dialed more than once")
+		}
+	}
+
+	var header, trailer metadata.MD
+	b, err := proxies[0].Call(context.Background(), "grpc.testing.TestService", "UnaryCall", &Input{Body: []byte(`{"payload":{"body":"aGk="}}`)}, &header, &trailer)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if string(b) != `{"payload":{"type":0,"body":"aGk="},"username":"test","oauthScope":"","serverId":"","grpclbRouteType":0,"hostname":""}` {
+		t.Errorf("unexpected response: %s", b)
+	}
+
+	other, err := p.Get(newTestBackend(t))
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if other == proxies[0] {
+		t.Error("the backends share the connection")
+	}
+}
+
+func TestConnPool_Close(t *testing.T) {
+	addr := newTestBackend(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	p := NewConnPool(ctx, Config{}, nil, logging.NoOp)
+
+	if _, err := p.Get(addr); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+
+	// the pool is closed once its context is done
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err := p.Get(addr)
+		if errors.Is(err, ErrPoolClosed) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("the pool was not closed: %v", err)
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestConnPool_evict(t *testing.T) {
+	healthy := newTestHealthBackend(t, healthpb.HealthCheckResponse_SERVING)
+	notServing := newTestHealthBackend(t, healthpb.HealthCheckResponse_NOT_SERVING)
+	noHealth := newTestBackend(t)
+
+	for _, tc := range []struct {
+		name    string
+		cfg     Config
+		target  string
+		evicted bool
+	}{
+		{name: "active connection", cfg: Config{IdleTimeout: time.Minute}, target: noHealth},
+		{name: "idle connection", cfg: Config{IdleTimeout: time.Nanosecond}, target: noHealth, evicted: true},
+		{name: "idle timeout disabled", target: noHealth},
+		{name: "healthy backend", cfg: Config{HealthCheck: true}, target: healthy},
+		{name: "unhealthy backend", cfg: Config{HealthCheck: true}, target: notServing, evicted: true},
+		{name: "backend without health service", cfg: Config{HealthCheck: true}, target: noHealth, evicted: true},
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		tc.cfg.HealthCheckInterval = time.Hour
+		p := NewConnPool(ctx, tc.cfg, nil, logging.NoOp)
+
+		prx, err := p.Get(tc.target)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.name, err.Error())
+			cancel()
+			continue
+		}
+		time.Sleep(time.Millisecond)
+		p.evict()
+
+		next, err := p.Get(tc.target)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.name, err.Error())
+		}
+		if evicted := next != prx; evicted != tc.evicted {
+			t.Errorf("%s: unexpected eviction: %v", tc.name, evicted)
+		}
+		cancel()
+	}
+}
+
+// newTestHealthBackend starts an in-process gRPC server reporting the received status in
+// the grpc.health.v1 service
+func newTestHealthBackend(t *testing.T, st healthpb.HealthCheckResponse_ServingStatus) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	hs := health.NewServer()
+	hs.SetServingStatus("", st)
+	s := grpc.NewServer()
+	testpb.RegisterTestServiceServer(s, testService{})
+	healthpb.RegisterHealthServer(s, hs)
+	reflection.Register(s)
+	go s.Serve(l)
+	t.Cleanup(s.Stop)
+	return l.Addr().String()
+}
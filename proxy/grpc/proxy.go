@@ -4,58 +4,68 @@ import (
 	"context"
 	"encoding/json"
 	"api-gateway/v2/modules/lura/v2/logging"
-	"github.com/davron112/lura/v2/utils"
 	"github.com/golang/protobuf/jsonpb"
-	"net/url"
 
+	"github.com/jhump/protoreflect/desc"
 	"github.com/jhump/protoreflect/dynamic"
 	"github.com/jhump/protoreflect/dynamic/grpcdynamic"
 	"github.com/jhump/protoreflect/grpcreflect"
 	"github.com/pkg/errors"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
 	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
-	"google.golang.org/grpc/status"
 )
 
 type Proxy struct {
-	cc        *grpc.ClientConn
-	reflector *Reflector
-	stub      grpcdynamic.Stub
+	cfg         Config
+	mapper      *RequestMapper
+	descriptors *DescriptorRegistry
+	cc          *grpc.ClientConn
+	reflector   *Reflector
+	stub        grpcdynamic.Stub
 }
 
-// NewProxy creates a new client
-func NewProxy() *Proxy {
-	return &Proxy{}
+// NewProxy creates a new client. The descriptors registry is optional.
+func NewProxy(cfg Config, descriptors *DescriptorRegistry) *Proxy {
+	return &Proxy{cfg: cfg, mapper: NewRequestMapper(cfg), descriptors: descriptors}
 }
 
-// Connect opens a connection to target.
-func (p *Proxy) Connect(ctx context.Context, target *url.URL, logger logging.Logger) error {
-	cc, err := grpc.DialContext(ctx, target.String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+// Connect opens a connection to target. The received context must outlive the connection,
+// since it is also used by the reflection stream.
+func (p *Proxy) Connect(ctx context.Context, target string, logger logging.Logger) error {
+	cc, err := grpc.DialContext(ctx, target, dialOptions(p.cfg, logger)...)
 	if err != nil {
 		return err
 	}
 	p.cc = cc
 	rc := grpcreflect.NewClientV1Alpha(ctx, rpb.NewServerReflectionClient(p.cc))
-	p.reflector = NewReflector(rc, logger)
+	p.reflector = NewReflector(rc, p.descriptors, logger, p.cfg.ReflectionRefresh)
 	p.stub = grpcdynamic.NewStub(p.cc)
 	return err
 }
 
-// Call performs the gRPC call after doing reflection to obtain type information.
-func (p *Proxy) Call(ctx context.Context, serviceName, methodName string, message []byte, md *metadata.MD) ([]byte, error) {
-	invocation, err := p.reflector.CreateInvocation(ctx, serviceName, methodName, message)
+// Close releases the reflection stream and closes the underlying connection
+func (p *Proxy) Close() error {
+	if p.reflector != nil {
+		p.reflector.Reset()
+	}
+	if p.cc == nil {
+		return nil
+	}
+	return p.cc.Close()
+}
+
+// Call performs the gRPC call after doing reflection to obtain type information. The received
+// header and trailer are filled with the metadata returned by the backend.
+func (p *Proxy) Call(ctx context.Context, serviceName, methodName string, input *Input, header, trailer *metadata.MD) ([]byte, error) {
+	invocation, err := p.reflector.CreateInvocation(ctx, serviceName, methodName, input, p.mapper)
 	if err != nil {
 		return nil, err
 	}
 
-	output, err := p.stub.InvokeRpc(ctx, invocation.MethodDescriptor, invocation.Message, grpc.Header(md))
+	output, err := p.stub.InvokeRpc(ctx, invocation.MethodDescriptor, invocation.Message, grpc.Header(header), grpc.Trailer(trailer))
 	if err != nil {
-		stat := status.Convert(err)
-		grpcError := utils.NewHTTPError(int(stat.Code()), stat.Message())
-		grpcErrorJson, _ := json.Marshal(grpcError)
-		return grpcErrorJson, grpcError
+		return nil, err
 	}
 
 	outputMessage := dynamic.NewMessage(invocation.MethodDescriptor.GetOutputType())
@@ -68,5 +78,29 @@ func (p *Proxy) Call(ctx context.Context, serviceName, methodName string, messag
 	if err != nil {
 		return nil, err
 	}
+	if rule := HTTPRule(invocation.MethodDescriptor); rule != nil && rule.GetResponseBody() != "" {
+		return responseBody(invocation.MethodDescriptor, rule.GetResponseBody(), m)
+	}
 	return m, err
 }
+
+// responseBody extracts the field declared as response_body in the google.api.http annotation.
+// Values that are not objects are returned under the collection key, as the proxy package does.
+func responseBody(md *desc.MethodDescriptor, name string, m []byte) ([]byte, error) {
+	fd := findField(md.GetOutputType(), name)
+	if fd == nil {
+		return m, nil
+	}
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal(m, &data); err != nil {
+		return nil, err
+	}
+	v, ok := data[fd.GetJSONName()]
+	if !ok {
+		return []byte("{}"), nil
+	}
+	if fd.GetMessageType() != nil && !fd.IsRepeated() && !fd.IsMap() {
+		return v, nil
+	}
+	return json.Marshal(map[string]json.RawMessage{"collection": v})
+}
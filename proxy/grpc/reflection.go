@@ -2,30 +2,118 @@ package grpc
 
 import (
 	"context"
+	"sync"
+	"time"
+
 	"api-gateway/v2/modules/lura/v2/logging"
-	"api-gateway/v2/modules/lura/v2/utils"
 	"github.com/jhump/protoreflect/desc"
 	"github.com/jhump/protoreflect/dynamic"
 	"github.com/jhump/protoreflect/grpcreflect"
-	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"golang.org/x/sync/singleflight"
+	"google.golang.org/grpc/status"
 )
 
 type Reflector struct {
-	c      *grpcreflect.Client
-	logger logging.Logger
+	c           *grpcreflect.Client
+	descriptors *DescriptorRegistry
+	logger      logging.Logger
+	refresh     time.Duration
+
+	mu       *sync.RWMutex
+	services map[string]cachedService
+	// group deduplicates the concurrent lookups of the same service
+	group *singleflight.Group
+}
+
+type cachedService struct {
+	desc     *desc.ServiceDescriptor
+	loadedAt time.Time
 }
 
 // NewReflector creates a new Reflector from the reflection client and a lura logger.
-func NewReflector(client *grpcreflect.Client, logger logging.Logger) *Reflector {
+// Services declared in the descriptors registry (if any) are never requested to the
+// reflection service. The rest of the resolved service descriptors are cached and only
+// requested again to the upstream once they are older than refresh. A zero refresh keeps
+// them for the whole life of the reflector.
+func NewReflector(client *grpcreflect.Client, descriptors *DescriptorRegistry, logger logging.Logger, refresh time.Duration) *Reflector {
 	return &Reflector{
-		c:      client,
-		logger: logger,
+		c:           client,
+		descriptors: descriptors,
+		logger:      logger,
+		refresh:     refresh,
+		mu:          new(sync.RWMutex),
+		services:    map[string]cachedService{},
+		group:       new(singleflight.Group),
 	}
 }
 
-// CreateInvocation creates a MethodInvocation by performing reflection
-func (r *Reflector) CreateInvocation(ctx context.Context, serviceName, methodName string, input []byte) (*MethodInvocation, error) {
-	serviceDesc, err := r.c.ResolveService(serviceName)
+// Reset drops all the cached descriptors and closes the reflection stream
+func (r *Reflector) Reset() {
+	r.mu.Lock()
+	r.services = map[string]cachedService{}
+	r.c.Reset()
+	r.mu.Unlock()
+}
+
+// ResolveService returns the descriptor of the service, looking first at the loaded descriptors
+// and hitting the reflection service only on cache miss or when the cached descriptor is stale
+func (r *Reflector) ResolveService(serviceName string) (*desc.ServiceDescriptor, error) {
+	if r.descriptors != nil {
+		if sd, ok := r.descriptors.FindService(serviceName); ok {
+			return sd, nil
+		}
+	}
+
+	if sd, ok := r.cached(serviceName); ok {
+		return sd, nil
+	}
+
+	// the reflection call is done outside the lock, so a slow upstream does not block the
+	// resolution of the rest of the services
+	v, err, _ := r.group.Do(serviceName, func() (interface{}, error) {
+		if sd, ok := r.cached(serviceName); ok {
+			return sd, nil
+		}
+		r.mu.RLock()
+		_, stale := r.services[serviceName]
+		r.mu.RUnlock()
+		if stale {
+			// the grpcreflect client keeps its own cache of files, so it must be dropped
+			// in order to get fresh descriptors from the upstream
+			r.c.Reset()
+		}
+
+		serviceDesc, err := r.c.ResolveService(serviceName)
+		if err != nil {
+			return nil, err
+		}
+		r.mu.Lock()
+		r.services[serviceName] = cachedService{desc: serviceDesc, loadedAt: time.Now()}
+		r.mu.Unlock()
+		return serviceDesc, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*desc.ServiceDescriptor), nil
+}
+
+// cached returns the descriptor of the service if it is cached and fresh
+func (r *Reflector) cached(serviceName string) (*desc.ServiceDescriptor, bool) {
+	r.mu.RLock()
+	cached, ok := r.services[serviceName]
+	r.mu.RUnlock()
+
+	if ok && (r.refresh <= 0 || time.Since(cached.loadedAt) < r.refresh) {
+		return cached.desc, true
+	}
+	return nil, false
+}
+
+// ResolveMethod returns the descriptor of the method of the service
+func (r *Reflector) ResolveMethod(serviceName, methodName string) (*desc.MethodDescriptor, error) {
+	serviceDesc, err := r.ResolveService(serviceName)
 	if err != nil {
 		r.logger.Error("Failed to resolve service", map[string]interface{}{
 			"service": serviceName,
@@ -40,22 +128,36 @@ func (r *Reflector) CreateInvocation(ctx context.Context, serviceName, methodNam
 			"method":  methodName,
 			"service": serviceName,
 		})
-		return nil, errors.New("method not found upstream")
+		return nil, status.Errorf(codes.Unimplemented, "method %s not found upstream", methodName)
+	}
+	return methodDesc, nil
+}
+
+// CreateInvocation creates a MethodInvocation by performing reflection and mapping the input into
+// the request message. A nil mapper only decodes the body into the message.
+func (r *Reflector) CreateInvocation(ctx context.Context, serviceName, methodName string, input *Input, mapper *RequestMapper) (*MethodInvocation, error) {
+	methodDesc, err := r.ResolveMethod(serviceName, methodName)
+	if err != nil {
+		return nil, err
+	}
+
+	if mapper == nil {
+		mapper = NewRequestMapper(Config{})
 	}
 
 	inputMessage := dynamic.NewMessage(methodDesc.GetInputType())
-	if methodDesc.GetInputType().GetName() == "Empty" && len(input) > 0 {
+	if methodDesc.GetInputType().GetName() == "Empty" && len(input.Body) > 0 {
 		r.logger.Warning("Non-empty body received for an operation expecting an Empty message type.", map[string]interface{}{
 			"service": serviceName,
 			"method":  methodName,
 		})
-		return nil, utils.NewHTTPError(500, "Unexpected non-empty body for Empty type")
-	} else if len(input) > 0 {
-		if err := inputMessage.UnmarshalJSON(input); err != nil {
-			r.logger.Error("Failed to unmarshal request into dynamic message", map[string]interface{}{
+		return nil, status.Error(codes.InvalidArgument, "Unexpected non-empty body for Empty type")
+	} else {
+		if err := mapper.Map(methodDesc, input, inputMessage); err != nil {
+			r.logger.Error("Failed to map the request into the dynamic message", map[string]interface{}{
 				"error": err.Error(),
 			})
-			return nil, utils.NewHTTPError(500, "Failed to unmarshal request")
+			return nil, status.Error(codes.InvalidArgument, err.Error())
 		}
 	}
 
@@ -0,0 +1,99 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"api-gateway/v2/modules/lura/v2/config"
+	"api-gateway/v2/modules/lura/v2/logging"
+	"api-gateway/v2/modules/lura/v2/sd"
+	"github.com/jhump/protoreflect/desc"
+	"golang.org/x/sync/singleflight"
+)
+
+// ErrNotGrpcBackend is the error returned when the backend has no gRPC extra config
+var ErrNotGrpcBackend = errors.New("grpc: not a gRPC backend")
+
+// MethodResolver returns the descriptor of the method invoked by a gRPC backend
+type MethodResolver func() (*desc.MethodDescriptor, error)
+
+// NewMethodResolver returns a MethodResolver for the backend. The method is looked up in the
+// declared descriptors or, if there are none, requested to the reflection service of the hosts
+// of the backend. Successful resolutions are cached for the life of the resolver.
+func NewMethodResolver(ctx context.Context, remote *config.Backend, logger logging.Logger) (MethodResolver, error) {
+	if !IsGrpcMethod(remote) {
+		return nil, ErrNotGrpcBackend
+	}
+	cfg, err := ConfigGetter(remote)
+	if err != nil {
+		return nil, err
+	}
+	serviceName, methodName, err := parseURLPattern(remote.URLPattern)
+	if err != nil {
+		return nil, err
+	}
+	descriptors, err := LoadDescriptors(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if descriptors != nil {
+		md, err := descriptors.FindMethod(serviceName, methodName)
+		return func() (*desc.MethodDescriptor, error) { return md, err }, nil
+	}
+
+	var pool *ConnPool
+	var lb sd.Balancer
+	init := new(sync.Once)
+
+	var md *desc.MethodDescriptor
+	mu := new(sync.RWMutex)
+	cached := func() *desc.MethodDescriptor {
+		mu.RLock()
+		defer mu.RUnlock()
+		return md
+	}
+	resolutions := new(singleflight.Group)
+
+	return func() (*desc.MethodDescriptor, error) {
+		if m := cached(); m != nil {
+			return m, nil
+		}
+		init.Do(func() {
+			pool = NewConnPool(ctx, cfg, nil, logger)
+			lb = sd.NewBalancer(sd.GetRegister().Get(remote.SD)(remote))
+		})
+		host, err := lb.Host()
+		if err != nil {
+			return nil, err
+		}
+		target, err := dialTarget(host)
+		if err != nil {
+			return nil, err
+		}
+
+		// the reflection requests are made outside the lock, so a slow host does not block
+		// the resolutions against the rest of the hosts
+		v, err, _ := resolutions.Do(target, func() (interface{}, error) {
+			if m := cached(); m != nil {
+				return m, nil
+			}
+			prx, err := pool.Get(target)
+			if err != nil {
+				return nil, err
+			}
+			m, err := prx.Method(serviceName, methodName)
+			if err != nil {
+				return nil, err
+			}
+			mu.Lock()
+			md = m
+			mu.Unlock()
+			return m, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return v.(*desc.MethodDescriptor), nil
+	}, nil
+}
@@ -0,0 +1,77 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"api-gateway/v2/modules/lura/v2/config"
+	"api-gateway/v2/modules/lura/v2/logging"
+	"github.com/jhump/protoreflect/desc"
+)
+
+func TestNewMethodResolver(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	resolve, err := NewMethodResolver(ctx, &config.Backend{
+		URLPattern:  "/grpc.testing.TestService/UnaryCall",
+		Host:        []string{"http://" + newTestBackend(t)},
+		ExtraConfig: config.ExtraConfig{Namespace: map[string]interface{}{}},
+	}, logging.NoOp)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	// the concurrent resolutions share the reflection request
+	mds := make([]*desc.MethodDescriptor, 10)
+	var wg sync.WaitGroup
+	for i := range mds {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			md, err := resolve()
+			if err != nil {
+				t.Errorf("unexpected error: %s", err.Error())
+			}
+			mds[i] = md
+		}(i)
+	}
+	wg.Wait()
+	if mds[0] == nil || mds[0].GetFullyQualifiedName() != "grpc.testing.TestService.UnaryCall" {
+		t.Errorf("unexpected method: %v", mds[0])
+		return
+	}
+	for _, md := range mds[1:] {
+		if md != mds[0] {
+			t.Error("the method was resolved more than once")
+		}
+	}
+}
+
+func TestNewMethodResolver_errors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if _, err := NewMethodResolver(ctx, &config.Backend{URLPattern: "/grpc.testing.TestService/UnaryCall"}, logging.NoOp); !errors.Is(err, ErrNotGrpcBackend) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	resolve, err := NewMethodResolver(ctx, &config.Backend{
+		URLPattern:  "/grpc.testing.TestService/UnknownCall",
+		Host:        []string{newTestBackend(t)},
+		ExtraConfig: config.ExtraConfig{Namespace: map[string]interface{}{}},
+	}, logging.NoOp)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	// the failed resolutions are not cached
+	for i := 0; i < 2; i++ {
+		if _, err := resolve(); err == nil {
+			t.Error("error expected")
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"api-gateway/v2/modules/lura/v2/proxy"
+	_ "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// httpStatus is the canonical mapping of the gRPC status codes into HTTP status codes, as
+// defined in google/rpc/code.proto
+var httpStatus = map[codes.Code]int{
+	codes.OK:                 http.StatusOK,
+	codes.Canceled:           499,
+	codes.Unknown:            http.StatusInternalServerError,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.FailedPrecondition: http.StatusBadRequest,
+	codes.Aborted:            http.StatusConflict,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Internal:           http.StatusInternalServerError,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DataLoss:           http.StatusInternalServerError,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+}
+
+// HTTPStatusFromCode returns the HTTP status code for the gRPC code, checking the
+// overrides first
+func HTTPStatusFromCode(code codes.Code, overrides map[codes.Code]int) int {
+	if s, ok := overrides[code]; ok {
+		return s
+	}
+	if s, ok := httpStatus[code]; ok {
+		return s
+	}
+	return http.StatusInternalServerError
+}
+
+// ResponseError is the error returned by the gRPC backends when the call fails. Its string
+// representation is the JSON encoding of the google.rpc.Status, so it can be returned to the
+// client when the router is configured with return_error_msg.
+type ResponseError struct {
+	Code     int                 `json:"http_status_code"`
+	Status   *status.Status      `json:"-"`
+	Body     json.RawMessage     `json:"grpc_status"`
+	Metadata map[string][]string `json:"-"`
+	name     string
+}
+
+// NewResponseError wraps the error into a ResponseError. Errors not created by the gRPC
+// packages are handled as codes.Unknown, except the context ones.
+func NewResponseError(err error, overrides map[codes.Code]int) ResponseError {
+	var stat *status.Status
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		stat = status.FromContextError(err)
+	default:
+		stat = status.Convert(err)
+	}
+	return ResponseError{
+		Code:   HTTPStatusFromCode(stat.Code(), overrides),
+		Status: stat,
+		Body:   statusBody(stat),
+	}
+}
+
+// Error returns the JSON representation of the gRPC status
+func (r ResponseError) Error() string {
+	return string(r.Body)
+}
+
+// StatusCode returns the HTTP status code mapped from the gRPC status
+func (r ResponseError) StatusCode() int {
+	return r.Code
+}
+
+// Encoding returns the content type of the error message
+func (r ResponseError) Encoding() string {
+	return "application/json"
+}
+
+// GRPCStatus returns the status received from the backend, so the gRPC router can forward it
+func (r ResponseError) GRPCStatus() *status.Status {
+	return r.Status
+}
+
+// Name returns the name of the backend where the error happened
+func (r ResponseError) Name() string {
+	return r.name
+}
+
+// statusBody renders the status following the JSON mapping of google.rpc.Status. The details
+// with known types (BadRequest, ErrorInfo, RetryInfo...) are fully decoded, while the unknown
+// ones only expose their type URL.
+func statusBody(stat *status.Status) json.RawMessage {
+	pb := stat.Proto()
+	body := map[string]interface{}{
+		"code":    int32(stat.Code()),
+		"status":  codeName(stat.Code()),
+		"message": stat.Message(),
+	}
+	if len(pb.GetDetails()) > 0 {
+		details := make([]json.RawMessage, 0, len(pb.GetDetails()))
+		for _, d := range pb.GetDetails() {
+			b, err := protojson.Marshal(d)
+			if err != nil {
+				b, _ = json.Marshal(map[string]string{"@type": d.GetTypeUrl()})
+			}
+			details = append(details, b)
+		}
+		body["details"] = details
+	}
+	b, _ := json.Marshal(body)
+	return b
+}
+
+// codeName returns the name of the code as declared in google/rpc/code.proto
+func codeName(c codes.Code) string {
+	switch c {
+	case codes.OK:
+		return "OK"
+	case codes.Canceled:
+		return "CANCELLED"
+	case codes.InvalidArgument:
+		return "INVALID_ARGUMENT"
+	case codes.DeadlineExceeded:
+		return "DEADLINE_EXCEEDED"
+	case codes.NotFound:
+		return "NOT_FOUND"
+	case codes.AlreadyExists:
+		return "ALREADY_EXISTS"
+	case codes.PermissionDenied:
+		return "PERMISSION_DENIED"
+	case codes.ResourceExhausted:
+		return "RESOURCE_EXHAUSTED"
+	case codes.FailedPrecondition:
+		return "FAILED_PRECONDITION"
+	case codes.Aborted:
+		return "ABORTED"
+	case codes.OutOfRange:
+		return "OUT_OF_RANGE"
+	case codes.Unimplemented:
+		return "UNIMPLEMENTED"
+	case codes.Internal:
+		return "INTERNAL"
+	case codes.Unavailable:
+		return "UNAVAILABLE"
+	case codes.DataLoss:
+		return "DATA_LOSS"
+	case codes.Unauthenticated:
+		return "UNAUTHENTICATED"
+	}
+	return "UNKNOWN"
+}
+
+// parseCode accepts the names of the codes (as declared in google/rpc/code.proto or in
+// the codes package) and their numeric values
+func parseCode(s string) (codes.Code, bool) {
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 && n <= int(codes.Unauthenticated) {
+		return codes.Code(n), true
+	}
+	name := strings.ToUpper(strings.ReplaceAll(s, "_", ""))
+	for c := codes.OK; c <= codes.Unauthenticated; c++ {
+		if name == strings.ReplaceAll(codeName(c), "_", "") || name == strings.ToUpper(c.String()) {
+			return c, true
+		}
+	}
+	return codes.Unknown, false
+}
+
+// errorResponse returns the response to send when the call fails. If the backend is configured
+// with return_error_details, the error is embedded in the response data as the http backends
+// do, so it can be merged with the responses of the other backends.
+func errorResponse(cfg Config, err ResponseError) (*proxy.Response, error) {
+	if cfg.ReturnErrorDetails == "" {
+		return nil, err
+	}
+	err.name = cfg.ReturnErrorDetails
+	return &proxy.Response{
+		Data: map[string]interface{}{
+			"error_" + err.name: err,
+		},
+		Metadata: proxy.Metadata{
+			StatusCode: err.Code,
+			Headers:    err.Metadata,
+		},
+	}, nil
+}
@@ -0,0 +1,170 @@
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestHTTPStatusFromCode(t *testing.T) {
+	overrides := map[codes.Code]int{codes.NotFound: http.StatusGone}
+	for _, tc := range []struct {
+		code codes.Code
+		want int
+	}{
+		{code: codes.OK, want: http.StatusOK},
+		{code: codes.Canceled, want: 499},
+		{code: codes.InvalidArgument, want: http.StatusBadRequest},
+		{code: codes.DeadlineExceeded, want: http.StatusGatewayTimeout},
+		{code: codes.NotFound, want: http.StatusGone},
+		{code: codes.PermissionDenied, want: http.StatusForbidden},
+		{code: codes.ResourceExhausted, want: http.StatusTooManyRequests},
+		{code: codes.Unimplemented, want: http.StatusNotImplemented},
+		{code: codes.Unavailable, want: http.StatusServiceUnavailable},
+		{code: codes.Unauthenticated, want: http.StatusUnauthorized},
+		{code: codes.Code(42), want: http.StatusInternalServerError},
+	} {
+		if s := HTTPStatusFromCode(tc.code, overrides); s != tc.want {
+			t.Errorf("unexpected status code of %s. have: %d, want: %d", tc.code, s, tc.want)
+		}
+	}
+}
+
+func TestNewResponseError(t *testing.T) {
+	withDetails, _ := status.New(codes.InvalidArgument, "invalid order").WithDetails(&errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: "id", Description: "required"}},
+	})
+
+	for _, tc := range []struct {
+		name   string
+		err    error
+		code   codes.Code
+		status int
+		body   string
+	}{
+		{
+			name:   "grpc error",
+			err:    status.Error(codes.NotFound, "missing"),
+			code:   codes.NotFound,
+			status: http.StatusNotFound,
+			body:   `{"code":5,"message":"missing","status":"NOT_FOUND"}`,
+		},
+		{
+			name:   "error with details",
+			err:    withDetails.Err(),
+			code:   codes.InvalidArgument,
+			status: http.StatusBadRequest,
+			body:   `{"code":3,"details":[{"@type":"type.googleapis.com/google.rpc.BadRequest","fieldViolations":[{"field":"id","description":"required"}]}],"message":"invalid order","status":"INVALID_ARGUMENT"}`,
+		},
+		{
+			name:   "deadline",
+			err:    fmt.Errorf("calling the backend: %w", context.DeadlineExceeded),
+			code:   codes.DeadlineExceeded,
+			status: http.StatusGatewayTimeout,
+			body:   `{"code":4,"message":"calling the backend: context deadline exceeded","status":"DEADLINE_EXCEEDED"}`,
+		},
+		{
+			name:   "canceled",
+			err:    context.Canceled,
+			code:   codes.Canceled,
+			status: 499,
+			body:   `{"code":1,"message":"context canceled","status":"CANCELLED"}`,
+		},
+		{
+			name:   "generic error",
+			err:    errors.New("boom"),
+			code:   codes.Unknown,
+			status: http.StatusInternalServerError,
+			body:   `{"code":2,"message":"boom","status":"UNKNOWN"}`,
+		},
+	} {
+		err := NewResponseError(tc.err, nil)
+		if err.StatusCode() != tc.status {
+			t.Errorf("%s: unexpected status code: %d", tc.name, err.StatusCode())
+		}
+		if err.Encoding() != "application/json" {
+			t.Errorf("%s: unexpected encoding: %s", tc.name, err.Encoding())
+		}
+		if s := err.GRPCStatus(); s.Code() != tc.code {
+			t.Errorf("%s: unexpected status: %v", tc.name, s)
+		}
+		var body, want interface{}
+		json.Unmarshal([]byte(err.Error()), &body)
+		json.Unmarshal([]byte(tc.body), &want)
+		if b1, b2 := mustMarshal(body), mustMarshal(want); b1 != b2 {
+			t.Errorf("%s: unexpected body. have: %s, want: %s", tc.name, b1, b2)
+		}
+	}
+}
+
+func TestParseCode(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		code codes.Code
+		ok   bool
+	}{
+		{in: "5", code: codes.NotFound, ok: true},
+		{in: "NOT_FOUND", code: codes.NotFound, ok: true},
+		{in: "not_found", code: codes.NotFound, ok: true},
+		{in: "NotFound", code: codes.NotFound, ok: true},
+		{in: "CANCELLED", code: codes.Canceled, ok: true},
+		{in: "Canceled", code: codes.Canceled, ok: true},
+		{in: "0", code: codes.OK, ok: true},
+		{in: "17"},
+		{in: "-1"},
+		{in: "missing"},
+	} {
+		code, ok := parseCode(tc.in)
+		if ok != tc.ok || (ok && code != tc.code) {
+			t.Errorf("unexpected code of %q: %s %v", tc.in, code, ok)
+		}
+	}
+}
+
+func TestCodeName(t *testing.T) {
+	for c := codes.OK; c <= codes.Unauthenticated; c++ {
+		code, ok := parseCode(codeName(c))
+		if !ok || code != c {
+			t.Errorf("the name of %s can not be parsed: %s", c, codeName(c))
+		}
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	respErr := NewResponseError(status.Error(codes.PermissionDenied, "denied"), nil)
+	respErr.Metadata = map[string][]string{"X-Reason": {"policy"}}
+
+	if resp, err := errorResponse(Config{}, respErr); resp != nil || err == nil {
+		t.Errorf("unexpected result: %v %v", resp, err)
+	}
+
+	resp, err := errorResponse(Config{ReturnErrorDetails: "orders"}, respErr)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if resp.Metadata.StatusCode != http.StatusForbidden || resp.Metadata.Headers["X-Reason"][0] != "policy" {
+		t.Errorf("unexpected metadata: %+v", resp.Metadata)
+	}
+	embedded, ok := resp.Data["error_orders"].(ResponseError)
+	if !ok || embedded.Name() != "orders" {
+		t.Errorf("unexpected data: %v", resp.Data)
+		return
+	}
+	b, _ := json.Marshal(embedded)
+	if want := `{"http_status_code":403,"grpc_status":{"code":7,"message":"denied","status":"PERMISSION_DENIED"}}`; string(b) != want {
+		t.Errorf("unexpected embedded error: %s", b)
+	}
+}
+
+func mustMarshal(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,133 @@
+package grpc
+
+import (
+	"context"
+	"io"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/jhump/protoreflect/dynamic/grpcdynamic"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// StreamFormatSSE writes every message of a server stream as a Server-Sent Event
+	StreamFormatSSE = "sse"
+	// StreamFormatNDJSON writes every message of a server stream as a line of JSON
+	StreamFormatNDJSON = "ndjson"
+)
+
+// streamContentTypes are the content types of the supported stream formats
+var streamContentTypes = map[string]string{
+	StreamFormatSSE:    "text/event-stream",
+	StreamFormatNDJSON: "application/x-ndjson",
+}
+
+// Stream starts a server-streaming call and returns a reader with the received messages encoded
+// in the configured format. The call is canceled when the reader is closed. The received header
+// is filled with the metadata sent by the backend before the first message.
+func (p *Proxy) Stream(ctx context.Context, serviceName, methodName string, input *Input, header *metadata.MD) (io.ReadCloser, error) {
+	invocation, err := p.reflector.CreateInvocation(ctx, serviceName, methodName, input, p.mapper)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := p.stub.InvokeRpcServerStream(ctx, invocation.MethodDescriptor, invocation.Message)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	md, err := stream.Header()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	*header = md
+
+	pr, pw := io.Pipe()
+	go pumpStream(stream, invocation.MethodDescriptor.GetOutputType(), p.cfg.StreamFormat, pw)
+	// the pending writes must be released even if the reader is never consumed nor closed
+	go func() {
+		<-ctx.Done()
+		pr.Close()
+	}()
+
+	return &streamReader{PipeReader: pr, cancel: cancel}, nil
+}
+
+// Method returns the descriptor of the method of the service
+func (p *Proxy) Method(serviceName, methodName string) (*desc.MethodDescriptor, error) {
+	return p.reflector.ResolveMethod(serviceName, methodName)
+}
+
+// streamReader is the reader of the encoded messages of a server stream. Closing it
+// cancels the upstream call.
+type streamReader struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+// Close cancels the stream and releases the reader
+func (s *streamReader) Close() error {
+	s.cancel()
+	return s.PipeReader.Close()
+}
+
+// pumpStream encodes the received messages into the writer until the stream ends. A failed
+// stream is reported with a last frame containing the google.rpc.Status of the error.
+func pumpStream(stream *grpcdynamic.ServerStream, output *desc.MessageDescriptor, format string, w *io.PipeWriter) {
+	marshaler := &jsonpb.Marshaler{EnumsAsInts: true, EmitDefaults: true}
+	for {
+		msg, err := stream.RecvMsg()
+		if err == io.EOF {
+			w.Close()
+			return
+		}
+		if err != nil {
+			w.Write(streamFrame(format, "error", NewResponseError(err, nil).Body))
+			w.Close()
+			return
+		}
+
+		outputMessage := dynamic.NewMessage(output)
+		if err := outputMessage.ConvertFrom(msg); err != nil {
+			w.CloseWithError(err)
+			return
+		}
+		b, err := outputMessage.MarshalJSONPB(marshaler)
+		if err != nil {
+			w.CloseWithError(err)
+			return
+		}
+		// the writes fail once the reader is closed
+		if _, err := w.Write(streamFrame(format, "", b)); err != nil {
+			return
+		}
+	}
+}
+
+// streamFrame wraps the JSON encoded message with the framing of the format. The event name
+// is only used by the SSE frames, while the NDJSON ones wrap the errors in an error object.
+func streamFrame(format, event string, b []byte) []byte {
+	if format == StreamFormatNDJSON {
+		res := make([]byte, 0, len(b)+11)
+		if event != "" {
+			res = append(res, `{"`+event+`":`...)
+			res = append(res, b...)
+			res = append(res, '}')
+		} else {
+			res = append(res, b...)
+		}
+		return append(res, '\n')
+	}
+
+	res := make([]byte, 0, len(b)+len(event)+16)
+	if event != "" {
+		res = append(res, "event: "+event+"\n"...)
+	}
+	res = append(res, "data: "...)
+	res = append(res, b...)
+	return append(res, "\n\n"...)
+}
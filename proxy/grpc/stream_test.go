@@ -0,0 +1,20 @@
+package grpc
+
+import "testing"
+
+func TestStreamFrame(t *testing.T) {
+	for _, tc := range []struct {
+		format string
+		event  string
+		want   string
+	}{
+		{format: StreamFormatSSE, want: "data: {\"id\":1}\n\n"},
+		{format: StreamFormatSSE, event: "error", want: "event: error\ndata: {\"id\":1}\n\n"},
+		{format: StreamFormatNDJSON, want: "{\"id\":1}\n"},
+		{format: StreamFormatNDJSON, event: "error", want: "{\"error\":{\"id\":1}}\n"},
+	} {
+		if b := streamFrame(tc.format, tc.event, []byte(`{"id":1}`)); string(b) != tc.want {
+			t.Errorf("unexpected %s frame: %q", tc.format, b)
+		}
+	}
+}
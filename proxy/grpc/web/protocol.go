@@ -0,0 +1,239 @@
+package web
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	grpcrouter "api-gateway/v2/modules/lura/v2/router/grpc"
+	"api-gateway/v2/proxy/grpc"
+	"github.com/gin-gonic/gin"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// protocolWriter encodes the responses with the framing of the protocol used by the client
+type protocolWriter struct {
+	p protocol
+	c *gin.Context
+}
+
+func newProtocolWriter(p protocol, c *gin.Context) protocolWriter {
+	return protocolWriter{p: p, c: c}
+}
+
+// WriteMessage writes a successful response with the serialized output message
+func (w protocolWriter) WriteMessage(b []byte, headers http.Header) {
+	w.copyHeaders(headers)
+
+	switch w.p {
+	case protocolConnectProto, protocolConnectJSON:
+		w.c.Data(http.StatusOK, w.contentType(), b)
+	default:
+		body := append(frame(0x00, b), frame(0x80, trailer(status.New(codes.OK, "")))...)
+		w.writeGrpcWeb(body)
+	}
+}
+
+// WriteError writes a failed response. The gRPC-Web clients receive the status in the trailer
+// frame, while the Connect ones receive a JSON error with the closest HTTP status.
+func (w protocolWriter) WriteError(st *status.Status, headers http.Header) {
+	w.copyHeaders(headers)
+
+	switch w.p {
+	case protocolConnectProto, protocolConnectJSON:
+		w.c.Data(grpc.HTTPStatusFromCode(st.Code(), nil), contentTypeConnectJSON, connectError(st))
+	default:
+		w.writeGrpcWeb(frame(0x80, trailer(st)))
+	}
+}
+
+func (w protocolWriter) writeGrpcWeb(body []byte) {
+	if w.p == protocolGrpcWebText {
+		body = []byte(base64.StdEncoding.EncodeToString(body))
+	}
+	w.c.Data(http.StatusOK, w.contentType(), body)
+}
+
+func (w protocolWriter) contentType() string {
+	switch w.p {
+	case protocolGrpcWebText:
+		return contentTypeGrpcWebText + "+proto"
+	case protocolGrpcWeb:
+		return contentTypeGrpcWeb + "+proto"
+	case protocolConnectJSON:
+		return contentTypeConnectJSON
+	}
+	return contentTypeConnectProto
+}
+
+func (w protocolWriter) copyHeaders(headers http.Header) {
+	for k, vs := range headers {
+		for _, v := range vs {
+			w.c.Writer.Header().Add(k, v)
+		}
+	}
+}
+
+// frame prefixes the payload with the flags and the big-endian length of the gRPC framing
+func frame(flags byte, payload []byte) []byte {
+	res := make([]byte, 5, 5+len(payload))
+	res[0] = flags
+	binary.BigEndian.PutUint32(res[1:], uint32(len(payload)))
+	return append(res, payload...)
+}
+
+// trailer encodes the status as the HTTP/1 style headers of the gRPC-Web trailer frame
+func trailer(st *status.Status) []byte {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "grpc-status: %d\r\n", st.Code())
+	if msg := st.Message(); msg != "" {
+		fmt.Fprintf(buf, "grpc-message: %s\r\n", encodeGrpcMessage(msg))
+	}
+	if len(st.Proto().GetDetails()) > 0 {
+		if b, err := proto.Marshal(st.Proto()); err == nil {
+			fmt.Fprintf(buf, "grpc-status-details-bin: %s\r\n", base64.RawStdEncoding.EncodeToString(b))
+		}
+	}
+	return buf.Bytes()
+}
+
+// encodeGrpcMessage percent-encodes the message as required by the gRPC spec
+func encodeGrpcMessage(msg string) string {
+	var sb strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c >= ' ' && c <= '~' && c != '%' {
+			sb.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&sb, "%%%02X", c)
+	}
+	return sb.String()
+}
+
+type connectErrorDetail struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// connectError encodes the status as the JSON error of the Connect protocol
+func connectError(st *status.Status) []byte {
+	body := map[string]interface{}{
+		"code": connectCode(st.Code()),
+	}
+	if msg := st.Message(); msg != "" {
+		body["message"] = msg
+	}
+	if details := st.Proto().GetDetails(); len(details) > 0 {
+		res := make([]connectErrorDetail, 0, len(details))
+		for _, d := range details {
+			res = append(res, connectErrorDetail{
+				Type:  strings.TrimPrefix(d.GetTypeUrl(), "type.googleapis.com/"),
+				Value: base64.RawStdEncoding.EncodeToString(d.GetValue()),
+			})
+		}
+		body["details"] = res
+	}
+	b, _ := json.Marshal(body)
+	return b
+}
+
+// connectCode returns the name of the code as expected by the Connect clients
+func connectCode(c codes.Code) string {
+	if c == codes.Canceled {
+		return "canceled"
+	}
+	var sb strings.Builder
+	for i, r := range c.String() {
+		if r >= 'A' && r <= 'Z' {
+			if i > 0 {
+				sb.WriteByte('_')
+			}
+			r += 'a' - 'A'
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// statusFromResponse rebuilds the status of a failed endpoint response. The bodies with the
+// google.rpc.Status rendered by the gRPC backends keep their code and details, while the rest
+// are translated from the HTTP status code.
+func statusFromResponse(code int, body []byte) *status.Status {
+	if st, ok := parseStatus(body); ok {
+		return st
+	}
+	msg := strings.TrimSpace(string(body))
+	if msg == "" {
+		msg = http.StatusText(code)
+	}
+	return status.New(grpcrouter.CodeFromHTTPStatus(code), msg)
+}
+
+func parseStatus(body []byte) (*status.Status, bool) {
+	var wrapper map[string]json.RawMessage
+	if err := json.Unmarshal(body, &wrapper); err != nil {
+		return nil, false
+	}
+	// the errors returned with the return_error_details option are wrapped by their name, and
+	// their status is nested in the grpc_status field
+	var tmp map[string]json.RawMessage
+	if len(wrapper) == 1 {
+		for k, v := range wrapper {
+			if !strings.HasPrefix(k, "error_") {
+				continue
+			}
+			var details map[string]json.RawMessage
+			if err := json.Unmarshal(v, &details); err != nil {
+				return nil, false
+			}
+			if nested, ok := details["grpc_status"]; ok {
+				v = nested
+			}
+			body = v
+		}
+	}
+
+	// the status name is not part of the google.rpc.Status message
+	if err := json.Unmarshal(body, &tmp); err != nil {
+		return nil, false
+	}
+	if _, ok := tmp["code"]; !ok {
+		return nil, false
+	}
+	delete(tmp, "status")
+	body, _ = json.Marshal(tmp)
+
+	pb := &spb.Status{}
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(body, pb); err != nil || pb.GetCode() == 0 {
+		return nil, false
+	}
+	return status.FromProto(pb), true
+}
+
+// recorder collects the response of the endpoint so it can be encoded for the client
+type recorder struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func newRecorder() *recorder {
+	return &recorder{header: http.Header{}, code: http.StatusOK}
+}
+
+func (r *recorder) Header() http.Header { return r.header }
+
+func (r *recorder) Write(b []byte) (int, error) { return r.body.Write(b) }
+
+func (r *recorder) WriteHeader(code int) { r.code = code }
+
+func (r *recorder) Flush() {}
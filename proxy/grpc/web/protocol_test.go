@@ -0,0 +1,189 @@
+package web
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestFrame(t *testing.T) {
+	for _, tc := range []struct {
+		flags   byte
+		payload []byte
+		want    []byte
+	}{
+		{flags: 0x00, payload: nil, want: []byte{0, 0, 0, 0, 0}},
+		{flags: 0x00, payload: []byte("abc"), want: []byte{0, 0, 0, 0, 3, 'a', 'b', 'c'}},
+		{flags: 0x80, payload: make([]byte, 258), want: append([]byte{0x80, 0, 0, 1, 2}, make([]byte, 258)...)},
+	} {
+		if b := frame(tc.flags, tc.payload); !bytes.Equal(b, tc.want) {
+			t.Errorf("unexpected frame: %v", b[:5])
+		}
+	}
+
+	// the frames are read back by the request decoder
+	if msg, err := readFrame(frame(0x00, []byte("abc"))); err != nil || string(msg) != "abc" {
+		t.Errorf("unexpected message: %q %v", msg, err)
+	}
+}
+
+func TestTrailer(t *testing.T) {
+	withDetails, _ := status.New(codes.InvalidArgument, "invalid").WithDetails(&errdetails.ErrorInfo{Reason: "TEST"})
+
+	for _, tc := range []struct {
+		name string
+		st   *status.Status
+		want string
+	}{
+		{name: "ok", st: status.New(codes.OK, ""), want: "grpc-status: 0\r\n"},
+		{name: "message", st: status.New(codes.NotFound, "order not found"), want: "grpc-status: 5\r\ngrpc-message: order not found\r\n"},
+		{name: "encoded message", st: status.New(codes.Internal, "100% \"done\"\nñ"), want: "grpc-status: 13\r\ngrpc-message: 100%25 \"done\"%0A%C3%B1\r\n"},
+	} {
+		if b := trailer(tc.st); string(b) != tc.want {
+			t.Errorf("%s: unexpected trailer: %q", tc.name, b)
+		}
+	}
+
+	b := string(trailer(withDetails))
+	prefix := "grpc-status: 3\r\ngrpc-message: invalid\r\ngrpc-status-details-bin: "
+	if !strings.HasPrefix(b, prefix) || !strings.HasSuffix(b, "\r\n") {
+		t.Errorf("unexpected trailer: %q", b)
+		return
+	}
+	raw, err := base64.RawStdEncoding.DecodeString(strings.TrimSuffix(strings.TrimPrefix(b, prefix), "\r\n"))
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	pb := &spb.Status{}
+	if err := proto.Unmarshal(raw, pb); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if !proto.Equal(pb, withDetails.Proto()) {
+		t.Errorf("unexpected status details: %v", pb)
+	}
+}
+
+func TestConnectCode(t *testing.T) {
+	for c, want := range map[codes.Code]string{
+		codes.Canceled:           "canceled",
+		codes.Unknown:            "unknown",
+		codes.InvalidArgument:    "invalid_argument",
+		codes.DeadlineExceeded:   "deadline_exceeded",
+		codes.NotFound:           "not_found",
+		codes.PermissionDenied:   "permission_denied",
+		codes.ResourceExhausted:  "resource_exhausted",
+		codes.FailedPrecondition: "failed_precondition",
+		codes.Unimplemented:      "unimplemented",
+		codes.Unavailable:        "unavailable",
+		codes.DataLoss:           "data_loss",
+		codes.Unauthenticated:    "unauthenticated",
+	} {
+		if s := connectCode(c); s != want {
+			t.Errorf("unexpected name of %s. have: %s, want: %s", c, s, want)
+		}
+	}
+}
+
+func TestConnectError(t *testing.T) {
+	withDetails, _ := status.New(codes.InvalidArgument, "invalid").WithDetails(&errdetails.ErrorInfo{Reason: "TEST"})
+	detail, _ := proto.Marshal(&errdetails.ErrorInfo{Reason: "TEST"})
+
+	for _, tc := range []struct {
+		name string
+		st   *status.Status
+		want string
+	}{
+		{name: "without message", st: status.New(codes.Unavailable, ""), want: `{"code":"unavailable"}`},
+		{name: "message", st: status.New(codes.NotFound, "missing"), want: `{"code":"not_found","message":"missing"}`},
+		{
+			name: "details",
+			st:   withDetails,
+			want: `{"code":"invalid_argument","details":[{"type":"google.rpc.ErrorInfo","value":"` + base64.RawStdEncoding.EncodeToString(detail) + `"}],"message":"invalid"}`,
+		},
+	} {
+		if b := connectError(tc.st); string(b) != tc.want {
+			t.Errorf("%s: unexpected error: %s", tc.name, b)
+		}
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		body string
+		code codes.Code
+		msg  string
+		ok   bool
+	}{
+		{
+			name: "google.rpc.Status",
+			body: `{"code":5,"status":"NOT_FOUND","message":"missing"}`,
+			code: codes.NotFound,
+			msg:  "missing",
+			ok:   true,
+		},
+		{
+			name: "status with details",
+			body: `{"code":3,"message":"invalid","details":[{"@type":"type.googleapis.com/google.rpc.ErrorInfo","reason":"TEST"}]}`,
+			code: codes.InvalidArgument,
+			msg:  "invalid",
+			ok:   true,
+		},
+		{
+			name: "wrapped error details",
+			body: `{"error_orders":{"http_status_code":404,"grpc_status":{"code":5,"status":"NOT_FOUND","message":"missing"}}}`,
+			code: codes.NotFound,
+			msg:  "missing",
+			ok:   true,
+		},
+		{name: "ok code", body: `{"code":0,"message":""}`},
+		{name: "missing code", body: `{"message":"missing"}`},
+		{name: "http error", body: `{"error":"not found"}`},
+		{name: "wrapped http error", body: `{"error_orders":"not found"}`},
+		{name: "not json", body: `not found`},
+		{name: "array", body: `[1]`},
+	} {
+		st, ok := parseStatus([]byte(tc.body))
+		if ok != tc.ok {
+			t.Errorf("%s: unexpected result: %v", tc.name, ok)
+			continue
+		}
+		if ok && (st.Code() != tc.code || st.Message() != tc.msg) {
+			t.Errorf("%s: unexpected status: %v", tc.name, st)
+		}
+	}
+
+	st, _ := parseStatus([]byte(`{"code":3,"message":"invalid","details":[{"@type":"type.googleapis.com/google.rpc.ErrorInfo","reason":"TEST"}]}`))
+	if details := st.Details(); len(details) != 1 || details[0].(*errdetails.ErrorInfo).GetReason() != "TEST" {
+		t.Errorf("unexpected details: %v", details)
+	}
+}
+
+func TestStatusFromResponse(t *testing.T) {
+	for _, tc := range []struct {
+		code int
+		body string
+		want codes.Code
+		msg  string
+	}{
+		{code: http.StatusNotFound, body: `{"code":7,"message":"denied"}`, want: codes.PermissionDenied, msg: "denied"},
+		{code: http.StatusNotFound, body: " not found\n", want: codes.NotFound, msg: "not found"},
+		{code: http.StatusServiceUnavailable, want: codes.Unavailable, msg: "Service Unavailable"},
+		{code: http.StatusTooManyRequests, body: `{"error":"limited"}`, want: codes.ResourceExhausted, msg: `{"error":"limited"}`},
+	} {
+		st := statusFromResponse(tc.code, []byte(tc.body))
+		if st.Code() != tc.want || st.Message() != tc.msg {
+			t.Errorf("unexpected status of %d %q: %v", tc.code, tc.body, st)
+		}
+	}
+}
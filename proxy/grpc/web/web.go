@@ -0,0 +1,429 @@
+/*
+Package web translates the gRPC-Web and the Connect unary requests into regular requests to the
+endpoints of the gateway, so browser clients can reach the gRPC backends through the same
+pipelines the JSON clients use.
+
+An endpoint with a single gRPC backend is exposed at the path of its gRPC method by adding the
+namespace to its extra config:
+
+	"extra_config": {
+		"grpc/web": {}
+	}
+*/
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"api-gateway/v2/modules/lura/v2/config"
+	"api-gateway/v2/modules/lura/v2/logging"
+	"api-gateway/v2/proxy/grpc"
+	"github.com/gin-gonic/gin"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Namespace is the key to look for in the extra config of the endpoints
+const Namespace = "api-gateway/v2/proxy/grpc/web"
+
+const (
+	contentTypeGrpcWeb      = "application/grpc-web"
+	contentTypeGrpcWebText  = "application/grpc-web-text"
+	contentTypeConnectProto = "application/proto"
+	contentTypeConnectJSON  = "application/json"
+
+	logPrefix = "[SERVICE: Gin][gRPC-Web]"
+)
+
+type protocol int
+
+const (
+	protocolUnknown protocol = iota
+	protocolGrpcWeb
+	protocolGrpcWebText
+	protocolConnectProto
+	protocolConnectJSON
+)
+
+// ErrUnsupportedEndpoint is the error returned when the endpoint can not be exposed as a gRPC method
+var ErrUnsupportedEndpoint = errors.New("grpc-web: the endpoint must have a single gRPC backend")
+
+// Register adds a route for the gRPC method of every endpoint enabling the namespace. The routes
+// decode the gRPC-Web and Connect requests and dispatch them through the engine to the endpoint.
+// The global middlewares already registered in the engine are skipped by the dispatched requests,
+// so they only run once. The connections used to resolve the methods are closed once the context
+// is done.
+func Register(ctx context.Context, cfg config.ServiceConfig, logger logging.Logger, engine *gin.Engine) {
+	registered := false
+	for _, e := range cfg.Endpoints {
+		tmp, ok := e.ExtraConfig[Namespace]
+		if !ok {
+			continue
+		}
+		if len(e.Backend) != 1 {
+			logger.Error(logPrefix, e.Endpoint, ErrUnsupportedEndpoint.Error())
+			continue
+		}
+		resolver, err := grpc.NewMethodResolver(ctx, e.Backend[0], logger)
+		if err != nil {
+			logger.Error(logPrefix, e.Endpoint, err.Error())
+			continue
+		}
+
+		path := e.Backend[0].URLPattern
+		if m, ok := tmp.(map[string]interface{}); ok {
+			if v, ok := m["path"].(string); ok && v != "" {
+				path = v
+			}
+		}
+		if hasRoute(engine, path) {
+			logger.Error(logPrefix, "The path", path, "is already registered. Ignoring", e.Endpoint)
+			continue
+		}
+		if !registered {
+			skipDispatched(engine)
+			registered = true
+		}
+		logger.Debug(logPrefix, "Exposing the endpoint", e.Endpoint, "at", path)
+		engine.POST(path, NewHandler(e, resolver, engine))
+	}
+}
+
+type dispatchedKey struct{}
+
+// isDispatched reports whether the request was dispatched to the endpoint by a gRPC-Web route
+func isDispatched(r *http.Request) bool {
+	v, _ := r.Context().Value(dispatchedKey{}).(bool)
+	return v
+}
+
+// skipDispatched wraps the global middlewares of the engine, so they are not executed again for
+// the requests dispatched to the endpoints. They already ran for the gRPC-Web request.
+func skipDispatched(engine *gin.Engine) {
+	for i, h := range engine.Handlers {
+		h := h
+		engine.Handlers[i] = func(c *gin.Context) {
+			if isDispatched(c.Request) {
+				return
+			}
+			h(c)
+		}
+	}
+	// rebuilds the chains of the NoRoute and NoMethod handlers with the wrapped middlewares
+	engine.Use()
+}
+
+// NewHandler returns a gin handler decoding the gRPC-Web and Connect unary requests into JSON
+// requests to the endpoint and encoding the JSON responses into the output message of the method
+func NewHandler(e *config.EndpointConfig, resolver grpc.MethodResolver, next http.Handler) gin.HandlerFunc {
+	unmarshaler := &jsonpb.Unmarshaler{AllowUnknownFields: true}
+	marshaler := &jsonpb.Marshaler{}
+
+	return func(c *gin.Context) {
+		p := detectProtocol(c.ContentType())
+		if p == protocolUnknown {
+			c.AbortWithStatus(http.StatusUnsupportedMediaType)
+			return
+		}
+		w := newProtocolWriter(p, c)
+
+		if enc := c.GetHeader("Content-Encoding"); enc != "" && enc != "identity" {
+			w.WriteError(status.New(codes.Unimplemented, "compression is not supported"), nil)
+			return
+		}
+
+		md, err := resolver()
+		if err != nil {
+			w.WriteError(status.New(codes.Unavailable, err.Error()), nil)
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		c.Request.Body.Close()
+		if err != nil {
+			w.WriteError(status.New(codes.InvalidArgument, err.Error()), nil)
+			return
+		}
+		msg, err := decodeRequest(p, body)
+		if err != nil {
+			w.WriteError(status.New(codes.InvalidArgument, err.Error()), nil)
+			return
+		}
+
+		in := dynamic.NewMessage(md.GetInputType())
+		var payload []byte
+		if p == protocolConnectJSON {
+			if len(msg) > 0 {
+				err = in.UnmarshalJSONPB(unmarshaler, msg)
+			}
+			payload = msg
+		} else {
+			if err = in.Unmarshal(msg); err == nil {
+				payload, err = in.MarshalJSONPB(marshaler)
+			}
+		}
+		if err != nil {
+			w.WriteError(status.New(codes.InvalidArgument, err.Error()), nil)
+			return
+		}
+
+		ctx := c.Request.Context()
+		if timeout, ok := requestTimeout(p, c.Request.Header); ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		req, err := newEndpointRequest(context.WithValue(ctx, dispatchedKey{}, true), c.Request, e, in, payload)
+		if err != nil {
+			w.WriteError(status.New(codes.Internal, err.Error()), nil)
+			return
+		}
+
+		rec := newRecorder()
+		next.ServeHTTP(rec, req)
+
+		headers := responseHeaders(rec.header)
+		if rec.code < http.StatusOK || rec.code >= http.StatusMultipleChoices {
+			w.WriteError(statusFromResponse(rec.code, rec.body.Bytes()), headers)
+			return
+		}
+
+		out := dynamic.NewMessage(md.GetOutputType())
+		if b := bytes.TrimSpace(rec.body.Bytes()); len(b) > 0 && !bytes.Equal(b, []byte("null")) {
+			if err := out.UnmarshalJSONPB(unmarshaler, b); err != nil {
+				w.WriteError(status.New(codes.Internal, err.Error()), headers)
+				return
+			}
+		}
+
+		var res []byte
+		if p == protocolConnectJSON {
+			res, err = out.MarshalJSONPB(marshaler)
+		} else {
+			res, err = out.Marshal()
+		}
+		if err != nil {
+			w.WriteError(status.New(codes.Internal, err.Error()), headers)
+			return
+		}
+		w.WriteMessage(res, headers)
+	}
+}
+
+func detectProtocol(contentType string) protocol {
+	switch {
+	case contentType == contentTypeGrpcWebText || contentType == contentTypeGrpcWebText+"+proto":
+		return protocolGrpcWebText
+	case contentType == contentTypeGrpcWeb || contentType == contentTypeGrpcWeb+"+proto":
+		return protocolGrpcWeb
+	case contentType == contentTypeConnectProto:
+		return protocolConnectProto
+	case contentType == contentTypeConnectJSON:
+		return protocolConnectJSON
+	}
+	return protocolUnknown
+}
+
+// decodeRequest returns the serialized message sent in the request body
+func decodeRequest(p protocol, body []byte) ([]byte, error) {
+	switch p {
+	case protocolGrpcWebText:
+		decoded, err := decodeBase64(body)
+		if err != nil {
+			return nil, err
+		}
+		return readFrame(decoded)
+	case protocolGrpcWeb:
+		return readFrame(body)
+	}
+	return body, nil
+}
+
+// decodeBase64 decodes the body of the grpc-web-text requests. Every frame can be encoded
+// independently, so the body can contain padding in the middle.
+func decodeBase64(b []byte) ([]byte, error) {
+	b = bytes.TrimSpace(b)
+	var res []byte
+	for len(b) > 0 {
+		end := bytes.IndexByte(b, '=')
+		if end < 0 {
+			end = len(b)
+		} else {
+			for end < len(b) && b[end] == '=' {
+				end++
+			}
+		}
+		chunk := make([]byte, base64.StdEncoding.DecodedLen(end))
+		n, err := base64.StdEncoding.Decode(chunk, b[:end])
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, chunk[:n]...)
+		b = b[end:]
+	}
+	return res, nil
+}
+
+// readFrame returns the message of the first data frame
+func readFrame(b []byte) ([]byte, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	if len(b) < 5 {
+		return nil, errors.New("grpc-web: truncated frame header")
+	}
+	if b[0]&0x01 != 0 {
+		return nil, errors.New("grpc-web: compressed frames are not supported")
+	}
+	size := int(b[1])<<24 | int(b[2])<<16 | int(b[3])<<8 | int(b[4])
+	if len(b)-5 < size {
+		return nil, errors.New("grpc-web: truncated frame")
+	}
+	return b[5 : 5+size], nil
+}
+
+// requestTimeout parses the deadline propagated by the client
+func requestTimeout(p protocol, h http.Header) (time.Duration, bool) {
+	if p == protocolConnectProto || p == protocolConnectJSON {
+		ms, err := strconv.ParseInt(h.Get("Connect-Timeout-Ms"), 10, 64)
+		if err != nil || ms <= 0 {
+			return 0, false
+		}
+		return time.Duration(ms) * time.Millisecond, true
+	}
+
+	v := h.Get("Grpc-Timeout")
+	if len(v) < 2 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	units := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}
+	unit, ok := units[v[len(v)-1]]
+	if !ok {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// newEndpointRequest builds the JSON request to the endpoint. The params of the endpoint path
+// are filled with the fields of the message with the same name.
+func newEndpointRequest(ctx context.Context, r *http.Request, e *config.EndpointConfig, in *dynamic.Message, payload []byte) (*http.Request, error) {
+	values := messageValues(in)
+
+	parts := strings.Split(e.Endpoint, "/")
+	for i, part := range parts {
+		if len(part) < 2 || (part[0] != ':' && part[0] != '*') {
+			continue
+		}
+		v, ok := values[part[1:]]
+		if !ok || v == "" {
+			return nil, fmt.Errorf("grpc-web: missing value for the param %s", part[1:])
+		}
+		// the values are escaped, so they can not send the request to other routes
+		segments := []string{v}
+		if part[0] == '*' {
+			segments = strings.Split(strings.TrimPrefix(v, "/"), "/")
+		} else if strings.Contains(v, "/") {
+			return nil, fmt.Errorf("grpc-web: invalid value for the param %s", part[1:])
+		}
+		for j, s := range segments {
+			if s == "." || s == ".." {
+				return nil, fmt.Errorf("grpc-web: invalid value for the param %s", part[1:])
+			}
+			segments[j] = url.PathEscape(s)
+		}
+		parts[i] = strings.Join(segments, "/")
+	}
+	path := strings.Join(parts, "/")
+
+	query := r.URL.Query()
+	for _, q := range e.QueryString {
+		if v, ok := values[q]; ok {
+			query.Set(q, v)
+		}
+	}
+	target := path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, e.Method, target, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range r.Header {
+		switch textproto.CanonicalMIMEHeaderKey(k) {
+		case "Content-Type", "Content-Length", "Content-Encoding", "Accept", "Accept-Encoding",
+			"Grpc-Timeout", "Grpc-Accept-Encoding", "X-Grpc-Web", "Connect-Protocol-Version",
+			"Connect-Timeout-Ms":
+			continue
+		}
+		req.Header[k] = vs
+	}
+	req.Header.Set("Content-Type", contentTypeConnectJSON)
+	req.Header.Set("Accept", contentTypeConnectJSON)
+	req.Host = r.Host
+	req.RemoteAddr = r.RemoteAddr
+	req.TLS = r.TLS
+	return req, nil
+}
+
+// messageValues returns the string representation of the scalar fields of the message
+func messageValues(in *dynamic.Message) map[string]string {
+	res := map[string]string{}
+	for _, fd := range in.GetKnownFields() {
+		if fd.IsRepeated() || fd.GetMessageType() != nil || !in.HasField(fd) {
+			continue
+		}
+		v := fmt.Sprintf("%v", in.GetField(fd))
+		res[fd.GetName()] = v
+		res[fd.GetJSONName()] = v
+	}
+	return res
+}
+
+// responseHeaders returns the headers of the endpoint response to forward to the client
+func responseHeaders(h http.Header) http.Header {
+	res := http.Header{}
+	for k, vs := range h {
+		switch k {
+		case "Content-Type", "Content-Length":
+			continue
+		}
+		res[k] = vs
+	}
+	return res
+}
+
+func hasRoute(engine *gin.Engine, path string) bool {
+	for _, r := range engine.Routes() {
+		if r.Method == http.MethodPost && r.Path == path {
+			return true
+		}
+	}
+	return false
+}
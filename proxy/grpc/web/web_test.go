@@ -0,0 +1,389 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"api-gateway/v2/modules/lura/v2/config"
+	"api-gateway/v2/modules/lura/v2/logging"
+	"api-gateway/v2/proxy/grpc"
+	"github.com/gin-gonic/gin"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"github.com/jhump/protoreflect/dynamic"
+	grpcserver "google.golang.org/grpc"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/protobuf/proto"
+)
+
+// newTestBackend starts an in-process gRPC server exposing the TestService descriptors through
+// the reflection service and returns its address
+func newTestBackend(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	s := grpcserver.NewServer()
+	testpb.RegisterTestServiceServer(s, testpb.UnimplementedTestServiceServer{})
+	reflection.Register(s)
+	go s.Serve(l)
+	t.Cleanup(s.Stop)
+	return l.Addr().String()
+}
+
+// newTestEngine returns an engine exposing the /sizes/:response_size endpoint through the
+// UnaryCall method. The global middleware counts its executions.
+func newTestEngine(t *testing.T, calls *int) *gin.Engine {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) {
+		*calls++
+		c.Header("X-Global", "true")
+	})
+
+	endpoint := &config.EndpointConfig{
+		Endpoint:    "/sizes/:response_size",
+		Method:      http.MethodPost,
+		QueryString: []string{"fill_username"},
+		ExtraConfig: config.ExtraConfig{Namespace: map[string]interface{}{}},
+		Backend: []*config.Backend{{
+			URLPattern:  "/grpc.testing.TestService/UnaryCall",
+			Host:        []string{newTestBackend(t)},
+			ExtraConfig: config.ExtraConfig{grpc.Namespace: map[string]interface{}{}},
+		}},
+	}
+	Register(ctx, config.ServiceConfig{Endpoints: []*config.EndpointConfig{endpoint}}, logging.NoOp, engine)
+
+	// the endpoint registered by the router after the engine is created
+	engine.POST("/sizes/:response_size", func(c *gin.Context) {
+		var body map[string]interface{}
+		json.NewDecoder(c.Request.Body).Decode(&body)
+		switch c.Param("response_size") {
+		case "404":
+			c.JSON(http.StatusNotFound, gin.H{"code": 5, "message": "user not found"})
+			return
+		case "502":
+			c.String(http.StatusBadGateway, "bad gateway")
+			return
+		}
+		c.Header("X-Endpoint", "sizes")
+		c.JSON(http.StatusOK, gin.H{
+			"username": c.Param("response_size") + "?" + c.Request.URL.RawQuery,
+			"payload":  body["payload"],
+			"unknown":  true,
+		})
+	})
+	return engine
+}
+
+func TestRegister(t *testing.T) {
+	var calls int
+	engine := newTestEngine(t, &calls)
+
+	in := &testpb.SimpleRequest{ResponseSize: 7, FillUsername: true, Payload: &testpb.Payload{Body: []byte("hi")}}
+	msg, _ := proto.Marshal(in)
+
+	for _, tc := range []struct {
+		name        string
+		contentType string
+		body        []byte
+		status      int
+		check       func(*testing.T, []byte)
+	}{
+		{
+			name:        "grpc-web",
+			contentType: "application/grpc-web+proto",
+			body:        frame(0x00, msg),
+			status:      http.StatusOK,
+			check: func(t *testing.T, b []byte) {
+				out := decodeGrpcWebResponse(t, b)
+				if out.GetUsername() != "7?fill_username=true" || string(out.GetPayload().GetBody()) != "hi" {
+					t.Errorf("unexpected response: %v", out)
+				}
+			},
+		},
+		{
+			name:        "grpc-web-text",
+			contentType: "application/grpc-web-text",
+			body:        []byte(base64.StdEncoding.EncodeToString(frame(0x00, msg))),
+			status:      http.StatusOK,
+			check: func(t *testing.T, b []byte) {
+				decoded, err := base64.StdEncoding.DecodeString(string(b))
+				if err != nil {
+					t.Errorf("unexpected error: %s", err.Error())
+					return
+				}
+				if out := decodeGrpcWebResponse(t, decoded); out.GetUsername() != "7?fill_username=true" {
+					t.Errorf("unexpected response: %v", out)
+				}
+			},
+		},
+		{
+			name:        "connect json",
+			contentType: "application/json",
+			body:        []byte(`{"responseSize":7,"payload":{"body":"aGk="}}`),
+			status:      http.StatusOK,
+			check: func(t *testing.T, b []byte) {
+				if string(b) != `{"payload":{"body":"aGk="},"username":"7?"}` {
+					t.Errorf("unexpected response: %s", b)
+				}
+			},
+		},
+		{
+			name:        "connect proto",
+			contentType: "application/proto",
+			body:        msg,
+			status:      http.StatusOK,
+			check: func(t *testing.T, b []byte) {
+				out := &testpb.SimpleResponse{}
+				if err := proto.Unmarshal(b, out); err != nil || out.GetUsername() != "7?fill_username=true" {
+					t.Errorf("unexpected response: %v %v", out, err)
+				}
+			},
+		},
+		{
+			name:        "grpc-web endpoint status",
+			contentType: "application/grpc-web",
+			body:        frame(0x00, mustMarshal(&testpb.SimpleRequest{ResponseSize: 404})),
+			status:      http.StatusOK,
+			check: func(t *testing.T, b []byte) {
+				if want := frame(0x80, []byte("grpc-status: 5\r\ngrpc-message: user not found\r\n")); !bytes.Equal(b, want) {
+					t.Errorf("unexpected response: %q", b)
+				}
+			},
+		},
+		{
+			name:        "connect endpoint error",
+			contentType: "application/json",
+			body:        []byte(`{"responseSize":502}`),
+			status:      http.StatusServiceUnavailable,
+			check: func(t *testing.T, b []byte) {
+				if string(b) != `{"code":"unavailable","message":"bad gateway"}` {
+					t.Errorf("unexpected response: %s", b)
+				}
+			},
+		},
+		{
+			name:        "invalid message",
+			contentType: "application/json",
+			body:        []byte(`{"responseSize":"many"}`),
+			status:      http.StatusBadRequest,
+		},
+		{
+			name:        "missing param",
+			contentType: "application/json",
+			body:        []byte(`{}`),
+			status:      http.StatusInternalServerError,
+		},
+		{
+			name:        "truncated frame",
+			contentType: "application/grpc-web",
+			body:        frame(0x00, msg)[:8],
+			status:      http.StatusOK,
+			check: func(t *testing.T, b []byte) {
+				if !bytes.HasPrefix(b[5:], []byte("grpc-status: 3\r\n")) {
+					t.Errorf("unexpected response: %q", b)
+				}
+			},
+		},
+		{
+			name:        "unknown content type",
+			contentType: "text/plain",
+			body:        msg,
+			status:      http.StatusUnsupportedMediaType,
+		},
+	} {
+		calls = 0
+		req, _ := http.NewRequest(http.MethodPost, "/grpc.testing.TestService/UnaryCall", bytes.NewReader(tc.body))
+		req.Header.Set("Content-Type", tc.contentType)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+
+		if w.Code != tc.status {
+			t.Errorf("%s: unexpected status code: %d. body: %s", tc.name, w.Code, w.Body.String())
+			continue
+		}
+		// the global middlewares only run for the client request
+		if calls != 1 {
+			t.Errorf("%s: the global middleware ran %d times", tc.name, calls)
+		}
+		if tc.check != nil {
+			tc.check(t, w.Body.Bytes())
+		}
+	}
+
+	// the endpoints keep running the global middlewares
+	calls = 0
+	req, _ := http.NewRequest(http.MethodPost, "/sizes/1", strings.NewReader(`{}`))
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || calls != 1 || w.Header().Get("X-Global") != "true" {
+		t.Errorf("unexpected response of the endpoint: %d, %d middleware calls", w.Code, calls)
+	}
+}
+
+func TestRegister_headers(t *testing.T) {
+	var calls int
+	engine := newTestEngine(t, &calls)
+
+	req, _ := http.NewRequest(http.MethodPost, "/grpc.testing.TestService/UnaryCall", strings.NewReader(`{"responseSize":1}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Connect-Timeout-Ms", "1000")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	if w.Header().Get("X-Endpoint") != "sizes" || w.Header().Get("X-Global") != "true" {
+		t.Errorf("unexpected headers: %v", w.Header())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("unexpected content type: %s", ct)
+	}
+}
+
+func TestDecodeBase64(t *testing.T) {
+	first := base64.StdEncoding.EncodeToString(frame(0x00, []byte("a")))
+	second := base64.StdEncoding.EncodeToString(frame(0x80, []byte("bc")))
+
+	b, err := decodeBase64([]byte(first + second + "\n"))
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if want := append(frame(0x00, []byte("a")), frame(0x80, []byte("bc"))...); !bytes.Equal(b, want) {
+		t.Errorf("unexpected content: %v", b)
+	}
+
+	if _, err := decodeBase64([]byte("!!!")); err == nil {
+		t.Error("error expected")
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	compressed := frame(0x01, []byte("a"))
+	for name, b := range map[string][]byte{
+		"truncated header":  {0, 0},
+		"truncated payload": frame(0x00, []byte("abc"))[:6],
+		"compressed frame":  compressed,
+	} {
+		if _, err := readFrame(b); err == nil {
+			t.Errorf("%s: error expected", name)
+		}
+	}
+	if msg, err := readFrame(nil); err != nil || msg != nil {
+		t.Errorf("unexpected message: %v %v", msg, err)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	for _, tc := range []struct {
+		p      protocol
+		header string
+		value  string
+		want   time.Duration
+	}{
+		{p: protocolGrpcWeb, header: "Grpc-Timeout", value: "2S", want: 2 * time.Second},
+		{p: protocolGrpcWeb, header: "Grpc-Timeout", value: "150m", want: 150 * time.Millisecond},
+		{p: protocolGrpcWebText, header: "Grpc-Timeout", value: "1H", want: time.Hour},
+		{p: protocolGrpcWeb, header: "Grpc-Timeout", value: "10x"},
+		{p: protocolGrpcWeb, header: "Grpc-Timeout", value: "S"},
+		{p: protocolGrpcWeb, header: "Grpc-Timeout", value: "-1S"},
+		{p: protocolConnectJSON, header: "Connect-Timeout-Ms", value: "250", want: 250 * time.Millisecond},
+		{p: protocolConnectProto, header: "Connect-Timeout-Ms", value: "0"},
+		{p: protocolConnectProto, header: "Grpc-Timeout", value: "2S"},
+	} {
+		h := http.Header{}
+		h.Set(tc.header, tc.value)
+		d, ok := requestTimeout(tc.p, h)
+		if ok != (tc.want > 0) || d != tc.want {
+			t.Errorf("unexpected timeout of %s %q: %s %v", tc.header, tc.value, d, ok)
+		}
+	}
+}
+
+func TestNewEndpointRequest(t *testing.T) {
+	p := protoparse.Parser{Accessor: protoparse.FileContentsFromMap(map[string]string{
+		"users.proto": `syntax = "proto3"; package users; message User { string name = 1; }`,
+	})}
+	fds, err := p.ParseFiles("users.proto")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	user := fds[0].FindMessage("users.User")
+
+	r, _ := http.NewRequest(http.MethodPost, "/grpc.testing.TestService/UnaryCall?trace=1", http.NoBody)
+	r.Header.Set("Content-Type", "application/grpc-web")
+	r.Header.Set("Grpc-Timeout", "1S")
+	r.Header.Set("Authorization", "Bearer token")
+
+	for _, tc := range []struct {
+		name     string
+		endpoint string
+		value    string
+		target   string
+	}{
+		{name: "param", endpoint: "/users/:name", value: "john doe", target: "/users/john%20doe?trace=1"},
+		{name: "wildcard", endpoint: "/files/*name", value: "/a/b c", target: "/files/a/b%20c?trace=1"},
+		{name: "slash in param", endpoint: "/users/:name", value: "../admin"},
+		{name: "dot segment", endpoint: "/files/*name", value: "a/../admin"},
+		{name: "missing param", endpoint: "/users/:name"},
+	} {
+		in := dynamic.NewMessage(user)
+		if tc.value != "" {
+			in.SetFieldByName("name", tc.value)
+		}
+		req, err := newEndpointRequest(context.Background(), r, &config.EndpointConfig{Endpoint: tc.endpoint, Method: http.MethodGet}, in, nil)
+		if tc.target == "" {
+			if err == nil {
+				t.Errorf("%s: error expected", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.name, err.Error())
+			continue
+		}
+		if req.URL.String() != tc.target {
+			t.Errorf("%s: unexpected target: %s", tc.name, req.URL.String())
+		}
+		if req.Header.Get("Grpc-Timeout") != "" || req.Header.Get("Authorization") != "Bearer token" || req.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("%s: unexpected headers: %v", tc.name, req.Header)
+		}
+	}
+}
+
+// decodeGrpcWebResponse returns the message of the data frame, checking the OK trailer frame
+func decodeGrpcWebResponse(t *testing.T, b []byte) *testpb.SimpleResponse {
+	t.Helper()
+	out := &testpb.SimpleResponse{}
+	if len(b) < 5 {
+		t.Errorf("unexpected response: %q", b)
+		return out
+	}
+	size := binary.BigEndian.Uint32(b[1:5])
+	if err := proto.Unmarshal(b[5:5+size], out); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if want := frame(0x80, []byte("grpc-status: 0\r\n")); !bytes.Equal(b[5+size:], want) {
+		t.Errorf("unexpected trailer: %q", b[5+size:])
+	}
+	return out
+}
+
+func mustMarshal(m proto.Message) []byte {
+	b, _ := proto.Marshal(m)
+	return b
+}
@@ -1,6 +1,7 @@
 package krakend
 
 import (
+	"context"
 	"encoding/json"
 
 	"github.com/gin-gonic/gin"
@@ -13,6 +14,7 @@ import (
 	"api-gateway/v2/modules/lura/v2/core"
 	luragin "api-gateway/v2/modules/lura/v2/router/gin"
 	"api-gateway/v2/modules/lura/v2/transport/http/server"
+	grpcweb "api-gateway/v2/proxy/grpc/web"
 )
 
 // NewEngine creates a new gin engine with some default values and a secure middleware
@@ -45,6 +47,12 @@ func NewEngine(cfg config.ServiceConfig, opt luragin.EngineOptions) *gin.Engine
 
 	botdetector.Register(cfg, opt.Logger, engine)
 
+	ctx := opt.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	grpcweb.Register(ctx, cfg, opt.Logger, engine)
+
 	return engine
 }
 